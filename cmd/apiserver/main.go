@@ -5,6 +5,7 @@ import (
 	"github.com/BurntSushi/toml"
 	"github.com/zlyaptica/hotel_service_backend/internal/app/apiserver"
 	"log"
+	"os"
 )
 
 var (
@@ -22,6 +23,18 @@ func main() {
 	if err != nil {
 		log.Fatal(err)
 	}
+	switch flag.Arg(0) {
+	case "migrate":
+		if err := apiserver.Migrate(config, flag.Args()[1:], os.Stdout); err != nil {
+			log.Fatal(err)
+		}
+		return
+	case "admin":
+		if err := apiserver.Admin(config, flag.Args()[1:], os.Stdout); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
 	if err := apiserver.Start(config); err != nil {
 		log.Fatal(err)
 	}
@@ -1,30 +1,295 @@
 package apiserver
 
 import (
+	"context"
 	"database/sql"
+	"errors"
+	"fmt"
 	"github.com/gorilla/sessions"
+	"github.com/sirupsen/logrus"
+	"github.com/zlyaptica/hotel_service_backend/internal/app/exchange"
+	"github.com/zlyaptica/hotel_service_backend/internal/app/model"
+	"github.com/zlyaptica/hotel_service_backend/internal/app/ratelimit"
+	"github.com/zlyaptica/hotel_service_backend/internal/app/sms"
+	"github.com/zlyaptica/hotel_service_backend/internal/app/token"
+	"github.com/zlyaptica/hotel_service_backend/store"
 	"github.com/zlyaptica/hotel_service_backend/store/sqlstore"
+	"github.com/zlyaptica/hotel_service_backend/store/sqlstore/migrations"
+	"io"
+	"net"
 	"net/http"
+	"os"
+	"os/signal"
+	"strconv"
+	"syscall"
+	"text/tabwriter"
+	"time"
 )
 
+var (
+	errMigrateUsage = errors.New("usage: migrate up|down|status|to <version>|baseline <version>")
+	errAdminUsage   = errors.New("usage: admin <phone number> [<last name>]")
+)
+
+// Start serves the API until SIGINT or SIGTERM, then shuts the server down
+// gracefully.
 func Start(config *Config) error {
-	db, err := newDB(config.DatabaseURL)
+	db, err := newDB(config)
 	if err != nil {
 		return err
 	}
 	defer db.Close()
 
-	store := sqlstore.New(db)
+	rates := exchange.NewTable()
+	if config.ExchangeRatesPath != "" {
+		rates, err = exchange.LoadFile(config.ExchangeRatesPath)
+		if err != nil {
+			return err
+		}
+	}
+
+	smsSender, err := newSMSSender(config)
+	if err != nil {
+		return err
+	}
+
+	tokens, err := newTokenAuth(config)
+	if err != nil {
+		return err
+	}
+
+	limits, err := newRateLimits(config)
+	if err != nil {
+		return err
+	}
+
+	m, err := migrations.New(db)
+	if err != nil {
+		return err
+	}
+	if config.AutoMigrate {
+		if err := m.Up(); err != nil {
+			return err
+		}
+	}
+
+	store := sqlstore.New(db, config.QueryTimeout.Duration)
 	sessionStore := sessions.NewCookieStore([]byte(config.SessionKey))
-	s := newServer(store, sessionStore)
-	return http.ListenAndServe(config.BindAddr, s)
+	s := newServer(store, sessionStore, rates, smsSender, tokens, limits, &config.CORS, config.RequestTimeout.Duration, &dbHealth{db: db, migrator: m})
+	s.metrics.registerDBMetrics(db)
+
+	ln, err := net.Listen("tcp", config.BindAddr)
+	if err != nil {
+		return err
+	}
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	if config.MetricsAddr == "" {
+		return serveUntil(ctx, newHTTPServer(config, s), ln, config.ShutdownTimeout.Duration, s.logger)
+	}
+
+	metricsLn, err := net.Listen("tcp", config.MetricsAddr)
+	if err != nil {
+		ln.Close()
+		return err
+	}
+	// When one of the servers fails, the other one is shut down too.
+	errs := make(chan error, 2)
+	go func() {
+		errs <- serveUntil(ctx, newHTTPServer(config, s), ln, config.ShutdownTimeout.Duration, s.logger)
+	}()
+	go func() {
+		errs <- serveUntil(ctx, newHTTPServer(config, s.metricsHandler()), metricsLn, config.ShutdownTimeout.Duration, s.logger)
+	}()
+	err = <-errs
+	stop()
+	if metricsErr := <-errs; err == nil {
+		err = metricsErr
+	}
+	return err
+}
+
+func newHTTPServer(config *Config, handler http.Handler) *http.Server {
+	return &http.Server{
+		Addr:              config.BindAddr,
+		Handler:           handler,
+		ReadTimeout:       config.ReadTimeout.Duration,
+		ReadHeaderTimeout: config.ReadHeaderTimeout.Duration,
+		WriteTimeout:      config.WriteTimeout.Duration,
+		IdleTimeout:       config.IdleTimeout.Duration,
+	}
+}
+
+// serveUntil serves ln with srv until ctx is done. Then it stops accepting connections and
+// waits up to shutdownTimeout for the requests in flight. Requests still
+// running after that have their contexts cancelled, so their queries stop
+// before the database is closed.
+func serveUntil(ctx context.Context, srv *http.Server, ln net.Listener, shutdownTimeout time.Duration, logger *logrus.Logger) error {
+	requests, cancelRequests := context.WithCancel(context.Background())
+	defer cancelRequests()
+	srv.BaseContext = func(net.Listener) context.Context {
+		return requests
+	}
+
+	errs := make(chan error, 1)
+	go func() {
+		errs <- srv.Serve(ln)
+	}()
+	select {
+	case err := <-errs:
+		return err
+	case <-ctx.Done():
+	}
+
+	logger.Info("shutting down")
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	if err := srv.Shutdown(shutdownCtx); err != nil {
+		logger.Warnf("requests didn't finish in %v, cancelling them", shutdownTimeout)
+		cancelRequests()
+		srv.Close()
+		return err
+	}
+	return nil
+}
+
+// Migrate runs a migrate command on the database of the config: "up"
+// applies all pending migrations, "down" reverts the last one, "to"
+// migrates up or down to the version, "baseline" records the migrations up
+// to the version as applied without running them and "status" changes
+// nothing. The status of the migrations is printed to out afterwards.
+func Migrate(config *Config, args []string, out io.Writer) error {
+	if len(args) == 0 {
+		return errMigrateUsage
+	}
+	db, err := newDB(config)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	m, err := migrations.New(db)
+	if err != nil {
+		return err
+	}
+	switch {
+	case args[0] == "up" && len(args) == 1:
+		err = m.Up()
+	case args[0] == "down" && len(args) == 1:
+		err = m.Down()
+	case args[0] == "to" && len(args) == 2:
+		version, convErr := strconv.Atoi(args[1])
+		if convErr != nil {
+			return errMigrateUsage
+		}
+		err = m.To(version)
+	case args[0] == "baseline" && len(args) == 2:
+		version, convErr := strconv.Atoi(args[1])
+		if convErr != nil {
+			return errMigrateUsage
+		}
+		err = m.Baseline(version)
+	case args[0] == "status" && len(args) == 1:
+	default:
+		return errMigrateUsage
+	}
+	if err != nil {
+		return err
+	}
+
+	statuses, err := m.Status()
+	if err != nil {
+		return err
+	}
+	w := tabwriter.NewWriter(out, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "VERSION\tNAME\tAPPLIED AT")
+	for _, st := range statuses {
+		appliedAt := "pending"
+		if st.AppliedAt != nil {
+			appliedAt = st.AppliedAt.Format("2006-01-02 15:04:05 MST")
+		}
+		fmt.Fprintf(w, "%04d\t%s\t%s\n", st.Version, st.Name, appliedAt)
+	}
+	return w.Flush()
+}
+
+// Admin runs the admin command, making the user with the phone number an
+// administrator so the first one can be set up without the API. A user who
+// doesn't exist yet is created with the last name.
+func Admin(config *Config, args []string, out io.Writer) error {
+	if len(args) == 0 || len(args) > 2 {
+		return errAdminUsage
+	}
+	db, err := newDB(config)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	lastName := ""
+	if len(args) == 2 {
+		lastName = args[1]
+	}
+	u, err := makeAdmin(context.Background(), sqlstore.New(db, config.QueryTimeout.Duration), args[0], lastName)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(out, "user %d (%s) is an administrator\n", u.ID, u.PhoneNumber)
+	return nil
+}
+
+// makeAdmin gives the user with the phone number the admin role, creating
+// them when lastName is set.
+func makeAdmin(ctx context.Context, st store.Store, phoneNumber, lastName string) (*model.User, error) {
+	phoneNumber = model.NormalizePhoneNumber(phoneNumber)
+	var u *model.User
+	err := st.WithTx(ctx, func(st store.Store) error {
+		var err error
+		u, err = st.User().FindByPhone(ctx, phoneNumber)
+		if err == store.ErrRecordNotFound && lastName != "" {
+			u = &model.User{
+				LName:       lastName,
+				PhoneNumber: phoneNumber,
+				Role:        model.RoleAdmin,
+			}
+			if err := u.Validate(); err != nil {
+				return err
+			}
+			return st.User().Create(ctx, u)
+		}
+		if err != nil {
+			return err
+		}
+		u.Role = model.RoleAdmin
+		u.HotelIDs = nil
+		return st.User().SetRole(ctx, u)
+	})
+	return u, err
+}
+
+// dbHealth checks the database of the server for the readiness probe.
+type dbHealth struct {
+	db       *sql.DB
+	migrator *migrations.Migrator
+}
+
+func (h *dbHealth) Ping(ctx context.Context) error {
+	return h.db.PingContext(ctx)
 }
 
-func newDB(databaseURL string) (*sql.DB, error) {
-	db, err := sql.Open("postgres", databaseURL)
+func (h *dbHealth) SchemaVersion(ctx context.Context) (int, int, error) {
+	applied, err := h.migrator.Version(ctx)
+	return applied, h.migrator.Latest(), err
+}
+
+func newDB(config *Config) (*sql.DB, error) {
+	db, err := sql.Open("postgres", config.DatabaseURL)
 	if err != nil {
 		return nil, err
 	}
+	db.SetMaxOpenConns(config.DBMaxOpenConns)
+	db.SetMaxIdleConns(config.DBMaxIdleConns)
+	db.SetConnMaxLifetime(config.DBConnMaxLifetime.Duration)
 
 	if err := db.Ping(); err != nil {
 		return nil, err
@@ -32,3 +297,50 @@ func newDB(databaseURL string) (*sql.DB, error) {
 
 	return db, nil
 }
+
+func newSMSSender(config *Config) (sms.Sender, error) {
+	switch config.SMSSender {
+	case "", "log":
+		return sms.NewLogSender(logrus.New()), nil
+	case "file":
+		if config.SMSFilePath == "" {
+			return nil, errors.New("sms_file_path is required for the file sms sender")
+		}
+		return sms.NewFileSender(config.SMSFilePath), nil
+	default:
+		return nil, fmt.Errorf("unknown sms sender %q", config.SMSSender)
+	}
+}
+
+// newTokenAuth returns the token issuer of the token auth mode, or nil in
+// the session mode.
+func newTokenAuth(config *Config) (*tokenAuth, error) {
+	switch config.AuthMode {
+	case "", authModeSession:
+		return nil, nil
+	case authModeToken:
+		if config.TokenKey == "" {
+			return nil, errors.New("token_key is required to sign tokens")
+		}
+		if config.TokenKey == config.SessionKey {
+			return nil, errors.New("token_key must differ from session_key")
+		}
+		return &tokenAuth{
+			signer:     token.NewSigner([]byte(config.TokenKey), config.AccessTokenTTL.Duration),
+			refreshTTL: config.RefreshTokenTTL.Duration,
+		}, nil
+	default:
+		return nil, fmt.Errorf("unknown auth mode %q", config.AuthMode)
+	}
+}
+
+func newRateLimits(config *Config) (map[string]*ratelimit.Limiter, error) {
+	limits := make(map[string]*ratelimit.Limiter, len(config.RateLimits))
+	for route, l := range config.RateLimits {
+		if l.Requests <= 0 || l.Per.Duration <= 0 {
+			return nil, fmt.Errorf("rate limit of %q must allow a positive number of requests per positive period", route)
+		}
+		limits[route] = ratelimit.New(l.Requests, l.Per.Duration)
+	}
+	return limits, nil
+}
@@ -0,0 +1,130 @@
+package apiserver
+
+import (
+	"context"
+	"github.com/sirupsen/logrus"
+	"github.com/zlyaptica/hotel_service_backend/internal/app/model"
+	"github.com/zlyaptica/hotel_service_backend/store"
+	"github.com/zlyaptica/hotel_service_backend/store/teststore"
+	"io"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestServeUntil_DrainsRequests(t *testing.T) {
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	started := make(chan struct{})
+	release := make(chan struct{})
+	srv := &http.Server{
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			close(started)
+			<-release
+			w.WriteHeader(http.StatusOK)
+		}),
+	}
+
+	ctx, stop := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() {
+		done <- serveUntil(ctx, srv, ln, time.Minute, logger)
+	}()
+	codes := make(chan int, 1)
+	go func() {
+		resp, err := http.Get("http://" + ln.Addr().String())
+		if err != nil {
+			codes <- 0
+			return
+		}
+		resp.Body.Close()
+		codes <- resp.StatusCode
+	}()
+
+	<-started
+	stop()
+	time.Sleep(50 * time.Millisecond)
+	if _, err := http.Get("http://" + ln.Addr().String()); err == nil {
+		t.Error("new connections are accepted during shutdown")
+	}
+	close(release)
+	if code := <-codes; code != http.StatusOK {
+		t.Errorf("request in flight: got %d, want %d", code, http.StatusOK)
+	}
+	if err := <-done; err != nil {
+		t.Errorf("shutdown: %v", err)
+	}
+}
+
+func TestServeUntil_CancelsRequestsAfterTimeout(t *testing.T) {
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	started := make(chan struct{})
+	cancelled := make(chan struct{})
+	srv := &http.Server{
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			close(started)
+			<-r.Context().Done()
+			close(cancelled)
+		}),
+	}
+
+	ctx, stop := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() {
+		done <- serveUntil(ctx, srv, ln, 10*time.Millisecond, logger)
+	}()
+	go http.Get("http://" + ln.Addr().String())
+
+	<-started
+	stop()
+	if err := <-done; err == nil {
+		t.Error("shutdown: got no error, want the timeout")
+	}
+	select {
+	case <-cancelled:
+	case <-time.After(time.Second):
+		t.Error("request in flight was not cancelled")
+	}
+}
+
+func TestMakeAdmin(t *testing.T) {
+	st := teststore.New()
+	ctx := context.Background()
+	manager := &model.User{LName: "Petrov", PhoneNumber: "+79811234567", Role: model.RoleHotelManager, HotelIDs: []int{1}}
+	if err := st.User().Create(ctx, manager); err != nil {
+		t.Fatal(err)
+	}
+
+	u, err := makeAdmin(ctx, st, manager.PhoneNumber, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if u.ID != manager.ID || !u.IsAdmin() {
+		t.Errorf("existing user: got %+v", u)
+	}
+
+	if _, err := makeAdmin(ctx, st, "+79817654321", ""); err != store.ErrRecordNotFound {
+		t.Errorf("unknown user without a name: got %v, want %v", err, store.ErrRecordNotFound)
+	}
+	u, err = makeAdmin(ctx, st, "+79817654321", "Ivanov")
+	if err != nil {
+		t.Fatal(err)
+	}
+	found, err := st.User().FindByPhone(ctx, "+79817654321")
+	if err != nil || found.ID != u.ID || !found.IsAdmin() {
+		t.Errorf("new user: got %+v, %v", found, err)
+	}
+	if _, err := makeAdmin(ctx, st, "123", "Ivanov"); err == nil {
+		t.Error("invalid phone number: got no error")
+	}
+}
@@ -0,0 +1,48 @@
+package apiserver
+
+import (
+	"runtime"
+	"runtime/debug"
+)
+
+// commit and buildTime are set when building a release:
+//
+//	go build -ldflags "-X github.com/zlyaptica/hotel_service_backend/internal/app/apiserver.commit=$(git rev-parse HEAD) -X github.com/zlyaptica/hotel_service_backend/internal/app/apiserver.buildTime=$(date -u +%Y-%m-%dT%H:%M:%SZ)" ./cmd/apiserver
+//
+// Otherwise they are taken from the version control information Go stamps
+// into binaries built inside a git checkout.
+var (
+	commit    string
+	buildTime string
+)
+
+type buildInfo struct {
+	Commit    string
+	BuildTime string
+	GoVersion string
+}
+
+func readBuildInfo() buildInfo {
+	info := buildInfo{
+		Commit:    commit,
+		BuildTime: buildTime,
+		GoVersion: runtime.Version(),
+	}
+	if bi, ok := debug.ReadBuildInfo(); ok {
+		for _, setting := range bi.Settings {
+			switch {
+			case setting.Key == "vcs.revision" && info.Commit == "":
+				info.Commit = setting.Value
+			case setting.Key == "vcs.time" && info.BuildTime == "":
+				info.BuildTime = setting.Value
+			}
+		}
+	}
+	if info.Commit == "" {
+		info.Commit = "unknown"
+	}
+	if info.BuildTime == "" {
+		info.BuildTime = "unknown"
+	}
+	return info
+}
@@ -1,15 +1,150 @@
 package apiserver
 
+import "time"
+
+// Auth modes. In the session mode guests are authenticated by the session
+// cookie, in the token mode by signed access tokens and refresh tokens.
+const (
+	authModeSession = "session"
+	authModeToken   = "token"
+)
+
 type Config struct {
-	BindAddr    string `toml:"bind_addr"`
+	BindAddr string `toml:"bind_addr"`
+	// MetricsAddr serves /metrics apart from the API, as the metrics tell
+	// the revenue of the hotels. Keep it reachable only from the internal
+	// network. Empty serves no metrics.
+	MetricsAddr string `toml:"metrics_addr"`
 	LogLevel    string `toml:"log_level"`
 	DatabaseURL string `toml:"database_url"`
 	SessionKey  string `toml:"session_key"`
+	// The database pool keeps up to DBMaxOpenConns connections, DBMaxIdleConns
+	// of them idle, and replaces connections older than DBConnMaxLifetime.
+	// Zero open connections or lifetime means no limit, zero idle
+	// connections keeps none.
+	DBMaxOpenConns    int      `toml:"db_max_open_conns"`
+	DBMaxIdleConns    int      `toml:"db_max_idle_conns"`
+	DBConnMaxLifetime Duration `toml:"db_conn_max_lifetime"`
+	// The timeouts of http.Server. On SIGINT or SIGTERM the server stops
+	// accepting connections and waits ShutdownTimeout for the requests in
+	// flight before cancelling them.
+	ReadTimeout       Duration `toml:"read_timeout"`
+	ReadHeaderTimeout Duration `toml:"read_header_timeout"`
+	WriteTimeout      Duration `toml:"write_timeout"`
+	IdleTimeout       Duration `toml:"idle_timeout"`
+	ShutdownTimeout   Duration `toml:"shutdown_timeout"`
+	// AutoMigrate applies pending schema migrations on start.
+	AutoMigrate bool `toml:"auto_migrate"`
+	// QueryTimeout limits each call of a repository and RequestTimeout the
+	// whole handling of a request. Requests running out of them get 504 and
+	// 503. Zero means no limit.
+	QueryTimeout   Duration `toml:"query_timeout"`
+	RequestTimeout Duration `toml:"request_timeout"`
+	// ExchangeRatesPath is a JSON file with the exchange rates loaded on
+	// start. Without it prices can't be converted until an administrator
+	// uploads rates.
+	ExchangeRatesPath string `toml:"exchange_rates_path"`
+	// SMSSender chooses how login codes are delivered: "log" writes them to
+	// the log, "file" appends them to SMSFilePath.
+	SMSSender   string `toml:"sms_sender"`
+	SMSFilePath string `toml:"sms_file_path"`
+	// AuthMode is "session" or "token". Access tokens are signed with
+	// TokenKey, which must differ from SessionKey.
+	AuthMode        string   `toml:"auth_mode"`
+	TokenKey        string   `toml:"token_key"`
+	AccessTokenTTL  Duration `toml:"access_token_ttl"`
+	RefreshTokenTTL Duration `toml:"refresh_token_ttl"`
+	// RateLimits limits the requests of each client to a route. Routes are
+	// named by the method and path template, like "POST /transacts".
+	RateLimits map[string]RateLimit `toml:"rate_limits"`
+	CORS       CORSConfig           `toml:"cors"`
+}
+
+// CORSConfig tells browsers which front ends may call the API.
+type CORSConfig struct {
+	Origins []CORSOrigin `toml:"origins"`
+	// AllowedHeaders may be sent by the front ends, ExposedHeaders may be
+	// read by them.
+	AllowedHeaders []string `toml:"allowed_headers"`
+	ExposedHeaders []string `toml:"exposed_headers"`
+	// MaxAge is how long browsers may cache the answer to a preflight.
+	MaxAge Duration `toml:"max_age"`
+}
+
+// CORSOrigin is an allowed origin like "https://hotelservice.ru". A single
+// "*" in Origin matches any part of the host, as in
+// "https://*.hotelservice.ru". AllowCredentials lets the front end send
+// cookies.
+type CORSOrigin struct {
+	Origin           string `toml:"origin"`
+	AllowCredentials bool   `toml:"allow_credentials"`
+}
+
+// RateLimit allows Requests requests per Per to each client.
+type RateLimit struct {
+	Requests int      `toml:"requests"`
+	Per      Duration `toml:"per"`
+}
+
+// Duration is a time.Duration written in the config as "15m" or "720h".
+type Duration struct {
+	time.Duration
+}
+
+func (d *Duration) UnmarshalText(text []byte) error {
+	var err error
+	d.Duration, err = time.ParseDuration(string(text))
+	return err
 }
 
 func NewConfig() *Config {
 	return &Config{
-		BindAddr: ":8080",
-		LogLevel: "debug",
+		BindAddr:       ":8080",
+		MetricsAddr:    "127.0.0.1:9090",
+		LogLevel:       "debug",
+		SMSSender:      "log",
+		AuthMode:       authModeSession,
+		DBMaxOpenConns: 20,
+		DBMaxIdleConns: 10,
+		DBConnMaxLifetime: Duration{
+			Duration: 30 * time.Minute,
+		},
+		ReadTimeout: Duration{
+			Duration: 15 * time.Second,
+		},
+		ReadHeaderTimeout: Duration{
+			Duration: 5 * time.Second,
+		},
+		WriteTimeout: Duration{
+			Duration: 30 * time.Second,
+		},
+		IdleTimeout: Duration{
+			Duration: time.Minute,
+		},
+		ShutdownTimeout: Duration{
+			Duration: 30 * time.Second,
+		},
+		QueryTimeout: Duration{
+			Duration: 5 * time.Second,
+		},
+		RequestTimeout: Duration{
+			Duration: 15 * time.Second,
+		},
+		AccessTokenTTL: Duration{
+			Duration: 15 * time.Minute,
+		},
+		RefreshTokenTTL: Duration{
+			Duration: 30 * 24 * time.Hour,
+		},
+		CORS: CORSConfig{
+			Origins: []CORSOrigin{
+				{Origin: "http://localhost:3000", AllowCredentials: true},
+			},
+			AllowedHeaders: []string{"Content-Type", "Authorization", "X-Request-ID"},
+			ExposedHeaders: []string{"X-Request-ID", "X-RateLimit-Limit", "X-RateLimit-Remaining", "Retry-After"},
+			MaxAge: Duration{
+				Duration: 10 * time.Minute,
+			},
+		},
 	}
 }
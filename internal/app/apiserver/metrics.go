@@ -0,0 +1,143 @@
+package apiserver
+
+import (
+	"database/sql"
+	"github.com/gorilla/mux"
+	"github.com/zlyaptica/hotel_service_backend/internal/app/metrics"
+	"github.com/zlyaptica/hotel_service_backend/internal/app/model"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+const metricsNamespace = "hotelservice_"
+
+// serverMetrics are the metrics served on /metrics of the metrics address.
+type serverMetrics struct {
+	registry          *metrics.Registry
+	requests          *metrics.CounterVec
+	requestDurations  *metrics.HistogramVec
+	bookingsCreated   *metrics.CounterVec
+	bookingsCancelled *metrics.CounterVec
+	revenue           *metrics.CounterVec
+	refunds           *metrics.CounterVec
+}
+
+func newServerMetrics() *serverMetrics {
+	r := metrics.NewRegistry()
+	return &serverMetrics{
+		registry: r,
+		requests: r.Counter(
+			metricsNamespace+"http_requests_total",
+			"Requests handled, by method, route template and status.",
+			"method", "route", "status",
+		),
+		requestDurations: r.Histogram(
+			metricsNamespace+"http_request_duration_seconds",
+			"Time spent handling requests, by method and route template.",
+			metrics.DefBuckets,
+			"method", "route",
+		),
+		bookingsCreated: r.Counter(
+			metricsNamespace+"bookings_created_total",
+			"Bookings made, by hotel.",
+			"hotel_id",
+		),
+		bookingsCancelled: r.Counter(
+			metricsNamespace+"bookings_cancelled_total",
+			"Bookings cancelled, by hotel.",
+			"hotel_id",
+		),
+		revenue: r.Counter(
+			metricsNamespace+"booking_revenue_total",
+			"Price of the bookings made in major currency units, by hotel and currency.",
+			"hotel_id", "currency",
+		),
+		refunds: r.Counter(
+			metricsNamespace+"booking_refunds_total",
+			"Money refunded on cancellation or taken off the price of a changed booking in major currency units, by hotel and currency.",
+			"hotel_id", "currency",
+		),
+	}
+}
+
+// observeRequest counts a handled request. Requests matching no route are
+// counted under the "unmatched" route, so unknown paths can't blow up the
+// number of series.
+func (m *serverMetrics) observeRequest(r *http.Request, code int, d time.Duration) {
+	route := "unmatched"
+	if current := mux.CurrentRoute(r); current != nil {
+		if tpl, err := current.GetPathTemplate(); err == nil {
+			route = tpl
+		}
+	}
+	m.requests.Inc(r.Method, route, strconv.Itoa(code))
+	m.requestDurations.Observe(d.Seconds(), r.Method, route)
+}
+
+func (m *serverMetrics) bookingCreated(hotelID int, price model.Money) {
+	id := strconv.Itoa(hotelID)
+	m.bookingsCreated.Inc(id)
+	m.revenue.Add(majorUnits(price), id, price.Currency)
+}
+
+// bookingRepriced records the change of the price of a changed booking.
+// Counters only go up, so a higher price adds to the revenue and a lower one
+// to the refunds, keeping revenue minus refunds right.
+func (m *serverMetrics) bookingRepriced(hotelID int, previous, price model.Money) {
+	id := strconv.Itoa(hotelID)
+	if previous.Currency != price.Currency {
+		m.refunds.Add(majorUnits(previous), id, previous.Currency)
+		m.revenue.Add(majorUnits(price), id, price.Currency)
+		return
+	}
+	delta := price.Amount - previous.Amount
+	switch {
+	case delta > 0:
+		m.revenue.Add(majorUnits(model.Money{Amount: delta, Currency: price.Currency}), id, price.Currency)
+	case delta < 0:
+		m.refunds.Add(majorUnits(model.Money{Amount: -delta, Currency: price.Currency}), id, price.Currency)
+	}
+}
+
+func (m *serverMetrics) bookingCancelled(hotelID int, refund model.Money) {
+	id := strconv.Itoa(hotelID)
+	m.bookingsCancelled.Inc(id)
+	m.refunds.Add(majorUnits(refund), id, refund.Currency)
+}
+
+// registerDBMetrics exposes the statistics of the connection pool.
+func (m *serverMetrics) registerDBMetrics(db *sql.DB) {
+	r := m.registry
+	r.GaugeFunc(metricsNamespace+"db_max_open_connections", "Maximum number of open connections to the database.", func() float64 {
+		return float64(db.Stats().MaxOpenConnections)
+	})
+	r.GaugeFunc(metricsNamespace+"db_open_connections", "Established connections, in use or idle.", func() float64 {
+		return float64(db.Stats().OpenConnections)
+	})
+	r.GaugeFunc(metricsNamespace+"db_in_use_connections", "Connections currently in use.", func() float64 {
+		return float64(db.Stats().InUse)
+	})
+	r.GaugeFunc(metricsNamespace+"db_idle_connections", "Idle connections.", func() float64 {
+		return float64(db.Stats().Idle)
+	})
+	r.CounterFunc(metricsNamespace+"db_wait_count_total", "Connections waited for.", func() float64 {
+		return float64(db.Stats().WaitCount)
+	})
+	r.CounterFunc(metricsNamespace+"db_wait_duration_seconds_total", "Time blocked waiting for a connection.", func() float64 {
+		return db.Stats().WaitDuration.Seconds()
+	})
+	r.CounterFunc(metricsNamespace+"db_max_idle_closed_total", "Connections closed due to the idle connections limit.", func() float64 {
+		return float64(db.Stats().MaxIdleClosed)
+	})
+	r.CounterFunc(metricsNamespace+"db_max_lifetime_closed_total", "Connections closed due to the connection lifetime limit.", func() float64 {
+		return float64(db.Stats().MaxLifetimeClosed)
+	})
+}
+
+// majorUnits converts money to major currency units. Metrics are floats
+// anyway, so the rounding doesn't matter there.
+func majorUnits(m model.Money) float64 {
+	return float64(m.Amount) / math.Pow10(model.CurrencyExponent(m.Currency))
+}
@@ -2,19 +2,68 @@ package apiserver
 
 import (
 	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
+	validation "github.com/go-ozzo/ozzo-validation"
 	"github.com/google/uuid"
 	"github.com/gorilla/mux"
 	"github.com/gorilla/sessions"
 	"github.com/sirupsen/logrus"
+	"github.com/zlyaptica/hotel_service_backend/internal/app/exchange"
+	"github.com/zlyaptica/hotel_service_backend/internal/app/metrics"
 	"github.com/zlyaptica/hotel_service_backend/internal/app/model"
+	"github.com/zlyaptica/hotel_service_backend/internal/app/pricing"
+	"github.com/zlyaptica/hotel_service_backend/internal/app/ratelimit"
+	"github.com/zlyaptica/hotel_service_backend/internal/app/sms"
+	"github.com/zlyaptica/hotel_service_backend/internal/app/token"
 	"github.com/zlyaptica/hotel_service_backend/store"
+	"io"
+	"math"
+	"math/big"
+	"net"
 	"net/http"
 	"strconv"
+	"strings"
 	"time"
 )
 
+const dateLayout = "2006-01-02"
+
+// Machine-readable error codes returned alongside the error message.
+const (
+	codeMinStay         = "min_stay"
+	codeUnknownCurrency = "unknown_currency"
+	codeCurrencyInUse   = "currency_in_use"
+	codeStayBegun       = "stay_begun"
+)
+
+// One-time login codes expire after otpTTL and stop working after
+// otpMaxAttempts guesses. A new code is sent at most once per
+// otpResendInterval, and a phone number gets otpMaxFailures guesses per
+// otpFailureWindow however many codes it asks for.
+const (
+	otpDigits         = 6
+	otpTTL            = 5 * time.Minute
+	otpMaxAttempts    = 5
+	otpResendInterval = time.Minute
+	otpMaxFailures    = 10
+	otpFailureWindow  = time.Hour
+)
+
+// apiKeyTouchInterval limits how often the last use of an API key is
+// written, so busy integrations don't update the key on every request.
+const apiKeyTouchInterval = time.Minute
+
+// probeTimeout limits the database checks of the probes, so a stuck database
+// fails the probe instead of hanging it.
+const probeTimeout = 2 * time.Second
+
 const (
 	sessionName        = "hotelservice"
 	ctxKeyUser  ctxKey = iota
@@ -24,44 +73,130 @@ const (
 type ctxKey int8
 
 var (
+	getHealthz = "/healthz"
+	getReadyz  = "/readyz"
+	getVersion = "/version"
+	getMetrics = "/metrics"
+
 	getApartmentClasses = "/apartmentclasses"
 
-	createUsers = "/users"
-	deleteUsers = "/users/{phone_number}"
-	//createSession = "/sessions"
-	//whoami        = "/whoami"
+	getExchangeRates    = "/exchangerates"
+	updateExchangeRates = "/exchangerates"
+
+	getAPIKeys   = "/apikeys"
+	createAPIKey = "/apikeys"
+	rotateAPIKey = "/apikeys/{id}/rotate"
+	revokeAPIKey = "/apikeys/{id}"
+
+	createUsers    = "/users"
+	deleteUsers    = "/users/{phone_number}"
+	updateUserRole = "/users/{phone_number}/role"
+	refreshToken   = "/tokens/refresh"
+	createOTP      = "/sessions/otp"
+	createSession  = "/sessions"
+	deleteSession  = "/sessions"
+	whoami         = "/whoami"
 
 	postTransact         = "/transacts"
+	updateTransact       = "/transacts/{id}"
+	cancelTransact       = "/transacts/{id}/cancel"
+	getTransactHistory   = "/transacts/{id}/history"
 	getTransactsByUserID = "/user/{phoneNumber}/transacts"
 
-	getHotels   = "/hotels"
-	getHotel    = "/hotels/{id}"
-	createHotel = "/hotels"
-	updateHotel = "/hotels/{id}"
+	getHotels    = "/hotels"
+	searchHotels = "/search"
+	getHotel     = "/hotels/{id}"
+	createHotel  = "/hotels"
+	updateHotel  = "/hotels/{id}"
 	//deleteHotel = "/hotels/{id}"
-	//getHotelsByCountry = "/hotels/{country}"
-	//getHotelsByCity    = "/hotels/{city}"
 
 	postApartments         = "/apartments"
 	getApartmentsByHotelID = "/hotel/{id}/apartments"
+	getApartmentQuote      = "/apartments/{id}/quote"
+	getRatePlan            = "/apartments/{id}/rateplan"
+	updateRatePlan         = "/apartments/{id}/rateplan"
 
-	//errNotAuthenticated = errors.New("not authenticated")
-	//errIncorrectNumber  = errors.New("incorrect number")
+	errNotFound         = errors.New("not found")
+	errMethodNotAllowed = errors.New("method not allowed")
+	errNotAuthenticated = errors.New("not authenticated")
+	errForbidden        = errors.New("forbidden")
+	errInvalidAPIKey    = errors.New("invalid api key")
+	errRateLimited      = errors.New("too many requests")
+	errInvalidDate      = fmt.Errorf("must be a date like %s", dateLayout)
+	errInvalidNumber    = errors.New("must be a number")
+	errInvalidCode      = errors.New("invalid code")
+	errCodeExpired      = errors.New("code expired")
+	errTooManyAttempts  = errors.New("too many attempts, request a new code")
+	errTooManyFailures  = errors.New("too many failed attempts, try again later")
+	errIncompleteStay   = errors.New("both arrival and departure must be set")
+	errInvalidStay      = errors.New("departure must be after arrival")
+	errOtherHotel       = errors.New("apartment belongs to another hotel")
+	errEmptyQuery       = errors.New("search query is empty")
+	errCurrencyInUse    = errors.New("currency of a hotel with apartments can't be changed")
+	errStayBegun        = errors.New("stay has already begun and can't be cancelled")
+	errInvalidLimit     = fmt.Errorf("must be between 1 and %d", store.MaxLimit)
 )
 
 type server struct {
-	router       *mux.Router
+	router *mux.Router
+	// probes routes the probes of the orchestrator, which skip logging,
+	// authentication and rate limiting.
+	probes       *mux.Router
 	logger       *logrus.Logger
+	metrics      *serverMetrics
 	store        store.Store
 	sessionStore sessions.Store
+	pricing      *pricing.Engine
+	rates        *exchange.Table
+	smsSender    sms.Sender
+	tokens       *tokenAuth
+	limits       map[string]*ratelimit.Limiter
+	cors         *CORSConfig
+	// requestTimeout limits the time a handler may spend on a request, 0
+	// means no limit.
+	requestTimeout time.Duration
+	health         healthChecker
+}
+
+// healthChecker tells the readiness probe whether the database can be used.
+type healthChecker interface {
+	// Ping checks that the database is reachable.
+	Ping(ctx context.Context) error
+	// SchemaVersion returns the applied and the latest migration versions.
+	SchemaVersion(ctx context.Context) (applied, latest int, err error)
+}
+
+// tokenAuth issues access and refresh tokens in the token auth mode.
+type tokenAuth struct {
+	signer     *token.Signer
+	refreshTTL time.Duration
 }
 
-func newServer(store store.Store, sessionStore sessions.Store) *server {
+// tokenPair is returned to the client on login and on every refresh.
+type tokenPair struct {
+	AccessToken  string      `json:"access_token"`
+	RefreshToken string      `json:"refresh_token"`
+	TokenType    string      `json:"token_type"`
+	ExpiresIn    int         `json:"expires_in"`
+	User         *model.User `json:"user"`
+}
+
+func newServer(store store.Store, sessionStore sessions.Store, rates *exchange.Table, smsSender sms.Sender, tokens *tokenAuth, limits map[string]*ratelimit.Limiter, cors *CORSConfig, requestTimeout time.Duration, health healthChecker) *server {
 	s := &server{
-		router:       mux.NewRouter(),
-		logger:       logrus.New(),
-		store:        store,
-		sessionStore: sessionStore,
+		router:         mux.NewRouter(),
+		probes:         mux.NewRouter(),
+		logger:         logrus.New(),
+		metrics:        newServerMetrics(),
+		store:          store,
+		sessionStore:   sessionStore,
+		pricing:        pricing.NewDefaultEngine(),
+		rates:          rates,
+		smsSender:      smsSender,
+		tokens:         tokens,
+		limits:         limits,
+		cors:           cors,
+		requestTimeout: requestTimeout,
+		health:         health,
 	}
 
 	s.configureRouter()
@@ -70,56 +205,238 @@ func newServer(store store.Store, sessionStore sessions.Store) *server {
 }
 
 func (s *server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var match mux.RouteMatch
+	if s.probes.Match(r, &match) {
+		s.probes.ServeHTTP(w, r)
+		return
+	}
+	if r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != "" {
+		s.handlePreflight(w, r)
+		return
+	}
 	s.router.ServeHTTP(w, r)
 }
 
 func (s *server) configureRouter() {
+	// пробы оркестратора, без логов и авторизации
+	s.probes.HandleFunc(getHealthz, s.handleHealthz()).Methods("GET")
+	s.probes.HandleFunc(getReadyz, s.handleReadyz()).Methods("GET")
+	s.probes.HandleFunc(getVersion, s.handleVersion()).Methods("GET")
+
 	s.router.Use(s.setRequestID)
 	s.router.Use(s.logRequest)
+	// middleware doesn't run for requests matching no route, so log them
+	// explicitly
+	s.router.NotFoundHandler = s.setRequestID(s.logRequest(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.error(w, r, http.StatusNotFound, errNotFound)
+	})))
+	s.router.MethodNotAllowedHandler = s.setRequestID(s.logRequest(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.error(w, r, http.StatusMethodNotAllowed, errMethodNotAllowed)
+	})))
+	s.router.Use(s.setTimeout)
 	s.router.Use(s.setCORS)
-	s.router.HandleFunc(createUsers, s.handleUsersCreate()).Methods("POST", "OPTIONS")
-	s.router.HandleFunc(deleteUsers, s.handleUsersDelete()).Methods("DELETE", "OPTIONS")
-	//s.router.HandleFunc(createSession, s.handleSessionCreate()).Methods("POST", "OPTIONS")
+	s.router.Use(s.authenticateAPIKey)
+	s.router.Use(s.rateLimit)
+	// маршруты гостя, доступные только после входа
+	guest := s.router.NewRoute().Subrouter()
+	guest.Use(s.authenticateUser)
+	// маршруты менеджеров отелей и администраторов
+	manager := guest.NewRoute().Subrouter()
+	manager.Use(s.requireRole(model.RoleHotelManager, model.RoleAdmin))
+	// маршруты администраторов
+	admin := guest.NewRoute().Subrouter()
+	admin.Use(s.requireRole(model.RoleAdmin))
+
+	s.router.HandleFunc(createUsers, s.handleUsersCreate()).Methods("POST")
+	guest.HandleFunc(deleteUsers, s.handleUsersDelete()).Methods("DELETE")
+	admin.HandleFunc(updateUserRole, s.handleUserRoleUpdate()).Methods("PUT")
+	s.router.HandleFunc(createOTP, s.handleOTPCreate()).Methods("POST")
+	s.router.HandleFunc(createSession, s.handleSessionCreate()).Methods("POST")
+	s.router.HandleFunc(deleteSession, s.handleSessionDelete()).Methods("DELETE")
+	if s.tokens != nil {
+		s.router.HandleFunc(refreshToken, s.handleTokenRefresh()).Methods("POST")
+	}
+
+	private := s.router.PathPrefix("/private").Subrouter()
+	private.Use(s.authenticateUser)
+	private.HandleFunc(whoami, s.handleWhoami()).Methods("GET")
 
-	//private := s.router.PathPrefix("/private").Subrouter()
-	//private.Use(s.authenticateUser)
-	//private.HandleFunc(whoami, s.handleWhoami()).Methods("GET")
+	// API-КЛЮЧИ
+	admin.HandleFunc(getAPIKeys, s.handleAPIKeysGet()).Methods("GET")
+	admin.HandleFunc(createAPIKey, s.handleAPIKeyCreate()).Methods("POST")
+	admin.HandleFunc(rotateAPIKey, s.handleAPIKeyRotate()).Methods("POST")
+	admin.HandleFunc(revokeAPIKey, s.handleAPIKeyRevoke()).Methods("DELETE")
 
 	// ТРАНЗАКЦИИ
-	s.router.HandleFunc(postTransact, s.handleTransactCreate()).Methods("POST", "OPTIONS")
-	s.router.HandleFunc(getTransactsByUserID, s.handleTransactsGetByUserID()).Methods("GET")
+	guest.HandleFunc(postTransact, s.handleTransactCreate()).Methods("POST")
+	guest.HandleFunc(updateTransact, s.handleTransactUpdate()).Methods("PATCH")
+	guest.HandleFunc(cancelTransact, s.handleTransactCancel()).Methods("POST")
+	guest.HandleFunc(getTransactHistory, s.handleTransactHistoryGet()).Methods("GET")
+	guest.HandleFunc(getTransactsByUserID, s.handleTransactsGetByUserID()).Methods("GET")
 
 	// ОТЕЛИ
 	s.router.HandleFunc(getHotels, s.handleHotelsGet()).Methods("GET") // хэндлер на путь localhost:8080/hotels
 	// с методом GET
 	s.router.HandleFunc(getHotel, s.handleHotelGet()).Methods("GET")
-	s.router.HandleFunc(createHotel, s.handleHotelCreate()).Methods("POST", "OPTIONS")
-	s.router.HandleFunc(updateHotel, s.handleHotelUpdate()).Methods("PUT", "OPTIONS")
+	s.router.HandleFunc(searchHotels, s.handleHotelsSearch()).Methods("GET")
+	admin.HandleFunc(createHotel, s.handleHotelCreate()).Methods("POST")
+	manager.HandleFunc(updateHotel, s.handleHotelUpdate()).Methods("PUT")
 
 	// АПАРТАМЕНТЫ
-	s.router.HandleFunc(postApartments, s.handleApartmentsCreate()).Methods("POST", "OPTIONS")
+	manager.HandleFunc(postApartments, s.handleApartmentsCreate()).Methods("POST")
 	s.router.HandleFunc(getApartmentsByHotelID, s.handleApartmentsByHotelIDGet()).Methods("GET")
+	s.router.HandleFunc(getApartmentQuote, s.handleApartmentQuoteGet()).Methods("GET")
+	s.router.HandleFunc(getRatePlan, s.handleRatePlanGet()).Methods("GET")
+	manager.HandleFunc(updateRatePlan, s.handleRatePlanUpdate()).Methods("PUT")
 
 	// КЛАСС АПАРТАМЕНТА
 	s.router.HandleFunc(getApartmentClasses, s.handleApartmentClassesGet()).Methods("GET")
+
+	// КУРСЫ ВАЛЮТ
+	s.router.HandleFunc(getExchangeRates, s.handleExchangeRatesGet()).Methods("GET")
+	admin.HandleFunc(updateExchangeRates, s.handleExchangeRatesUpdate()).Methods("PUT")
 }
 
+// setCORS lets allowed front ends read the response.
 func (s *server) setCORS(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
-		w.Header().Set("Access-Control-Allow-Methods", "GET, POST, DELETE, PUT, OPTIONS")
-		w.Header().Set("Access-Control-Allow-Origin", "http://localhost:3000")
+		w.Header().Add("Vary", "Origin")
+		if o, ok := s.corsOrigin(r); ok {
+			s.setCORSOrigin(w, r, o)
+			if len(s.cors.ExposedHeaders) > 0 {
+				w.Header().Set("Access-Control-Expose-Headers", strings.Join(s.cors.ExposedHeaders, ", "))
+			}
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// handlePreflight answers the browser whether an allowed front end may make
+// the request. Only requests to existing routes are allowed.
+func (s *server) handlePreflight(w http.ResponseWriter, r *http.Request) {
+	w.Header().Add("Vary", "Origin")
+	o, ok := s.corsOrigin(r)
+	if !ok {
+		w.WriteHeader(http.StatusForbidden)
+		return
+	}
+
+	method := r.Header.Get("Access-Control-Request-Method")
+	req := r.Clone(r.Context())
+	req.Method = method
+	// with the not found handlers set, Match reports unknown routes as
+	// matched with an error
+	var match mux.RouteMatch
+	if !s.router.Match(req, &match) || match.MatchErr != nil {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	s.setCORSOrigin(w, r, o)
+	w.Header().Set("Access-Control-Allow-Methods", method)
+	if len(s.cors.AllowedHeaders) > 0 {
+		w.Header().Set("Access-Control-Allow-Headers", strings.Join(s.cors.AllowedHeaders, ", "))
+	}
+	if s.cors.MaxAge.Duration > 0 {
+		w.Header().Set("Access-Control-Max-Age", strconv.Itoa(int(s.cors.MaxAge.Seconds())))
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// corsOrigin returns the configured origin matching the Origin header.
+func (s *server) corsOrigin(r *http.Request) (*CORSOrigin, bool) {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return nil, false
+	}
+	for i := range s.cors.Origins {
+		if matchOrigin(s.cors.Origins[i].Origin, origin) {
+			return &s.cors.Origins[i], true
+		}
+	}
+	return nil, false
+}
+
+func (s *server) setCORSOrigin(w http.ResponseWriter, r *http.Request, o *CORSOrigin) {
+	w.Header().Set("Access-Control-Allow-Origin", r.Header.Get("Origin"))
+	if o.AllowCredentials {
 		w.Header().Set("Access-Control-Allow-Credentials", "true")
+	}
+}
+
+// matchOrigin reports whether origin matches pattern, in which a single "*"
+// stands for any part of the host.
+func matchOrigin(pattern, origin string) bool {
+	star := strings.Index(pattern, "*")
+	if star < 0 {
+		return pattern == origin
+	}
+	prefix, suffix := pattern[:star], pattern[star+1:]
+	if len(origin) < len(prefix)+len(suffix) || !strings.HasPrefix(origin, prefix) || !strings.HasSuffix(origin, suffix) {
+		return false
+	}
+	host := origin[len(prefix) : len(origin)-len(suffix)]
+	return host != "" && !strings.ContainsAny(host, "/:")
+}
 
-		if r.Method == "OPTIONS" {
-			w.WriteHeader(http.StatusOK)
+// rateLimit applies the limit configured for the matched route to each
+// client and reports the quota left in the X-RateLimit headers.
+func (s *server) rateLimit(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		route := mux.CurrentRoute(r)
+		if route == nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+		template, err := route.GetPathTemplate()
+		if err != nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+		l, ok := s.limits[r.Method+" "+template]
+		if !ok {
+			next.ServeHTTP(w, r)
 			return
 		}
 
+		allowed, remaining, wait := l.Allow(s.clientKey(r), time.Now())
+		w.Header().Set("X-RateLimit-Limit", strconv.Itoa(l.Limit()))
+		w.Header().Set("X-RateLimit-Remaining", strconv.Itoa(remaining))
+		if !allowed {
+			w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+			s.error(w, r, http.StatusTooManyRequests, errRateLimited)
+			return
+		}
 		next.ServeHTTP(w, r)
 	})
 }
 
+// clientKey identifies the client for rate limiting: by the API key, by the
+// signed-in user or else by the IP address. The user is found like
+// authenticateUser does, by the bearer token when one is sent in the token
+// mode and by the session otherwise.
+func (s *server) clientKey(r *http.Request) string {
+	if u, ok := r.Context().Value(ctxKeyUser).(*model.User); ok && u.APIKeyID != 0 {
+		return "key:" + strconv.Itoa(u.APIKeyID)
+	}
+	if s.tokens != nil && bearerToken(r) != "" {
+		if id, err := s.tokens.signer.Verify(bearerToken(r), time.Now()); err == nil {
+			return "user:" + strconv.Itoa(id)
+		}
+	} else if session, err := s.sessionStore.Get(r, sessionName); err == nil {
+		if id, ok := session.Values["user_id"].(int); ok {
+			return "user:" + strconv.Itoa(id)
+		}
+	}
+
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	return "ip:" + host
+}
+
 func (s *server) setRequestID(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		id := uuid.New().String()
@@ -128,6 +445,19 @@ func (s *server) setRequestID(next http.Handler) http.Handler {
 	})
 }
 
+// setTimeout puts the request timeout on the context of the request, so the
+// queries of a slow request are cancelled and it is answered with 503.
+func (s *server) setTimeout(next http.Handler) http.Handler {
+	if s.requestTimeout <= 0 {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx, cancel := context.WithTimeout(r.Context(), s.requestTimeout)
+		defer cancel()
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
 func (s *server) logRequest(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		logger := s.logger.WithFields(logrus.Fields{
@@ -140,15 +470,112 @@ func (s *server) logRequest(next http.Handler) http.Handler {
 		rw := &responseWriter{w, http.StatusOK}
 		next.ServeHTTP(rw, r)
 
+		duration := time.Now().Sub(start)
 		logger.Infof(
 			"completed with %d %s in %v",
 			rw.code,
 			http.StatusText(rw.code),
-			time.Now().Sub(start),
+			duration,
 		)
+		s.metrics.observeRequest(r, rw.code, duration)
 	})
 }
 
+// handleHealthz answers as long as the process serves requests.
+func (s *server) handleHealthz() http.HandlerFunc {
+	type response struct {
+		Status string `json:"status"`
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		s.respond(w, r, http.StatusOK, &response{Status: "ok"})
+	}
+}
+
+// handleReadyz answers 200 when the database is reachable and migrated to the
+// latest version, and 503 with the failed checks otherwise.
+func (s *server) handleReadyz() http.HandlerFunc {
+	type response struct {
+		Status string            `json:"status"`
+		Checks map[string]string `json:"checks"`
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx, cancel := context.WithTimeout(r.Context(), probeTimeout)
+		defer cancel()
+
+		resp := &response{
+			Status: "ready",
+			Checks: map[string]string{
+				"database":   "ok",
+				"migrations": "ok",
+			},
+		}
+		if err := s.health.Ping(ctx); err != nil {
+			s.logger.Warnf("readiness: database: %v", err)
+			resp.Checks["database"] = "unreachable"
+			resp.Checks["migrations"] = "unknown"
+		} else if applied, latest, err := s.health.SchemaVersion(ctx); err != nil {
+			s.logger.Warnf("readiness: migrations: %v", err)
+			resp.Checks["migrations"] = "unknown"
+		} else if applied != latest {
+			resp.Checks["migrations"] = fmt.Sprintf("schema version %d, want %d", applied, latest)
+		}
+
+		code := http.StatusOK
+		for _, c := range resp.Checks {
+			if c != "ok" {
+				resp.Status = "not_ready"
+				code = http.StatusServiceUnavailable
+			}
+		}
+		s.respond(w, r, code, resp)
+	}
+}
+
+// metricsHandler serves the metrics. It isn't routed with the API, as the
+// metrics tell the revenue of the hotels, but served on the internal
+// metrics address.
+func (s *server) metricsHandler() http.Handler {
+	router := mux.NewRouter()
+	router.HandleFunc(getMetrics, s.handleMetrics()).Methods("GET")
+	return router
+}
+
+// handleMetrics writes the metrics in the Prometheus text format.
+func (s *server) handleMetrics() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", metrics.ContentType)
+		if _, err := s.metrics.registry.WriteTo(w); err != nil {
+			s.logger.Warnf("writing metrics: %v", err)
+		}
+	}
+}
+
+// handleVersion tells which build is running. The schema version is null
+// when the database can't be reached.
+func (s *server) handleVersion() http.HandlerFunc {
+	type response struct {
+		Commit        string `json:"commit"`
+		BuildTime     string `json:"build_time"`
+		GoVersion     string `json:"go_version"`
+		SchemaVersion *int   `json:"schema_version"`
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx, cancel := context.WithTimeout(r.Context(), probeTimeout)
+		defer cancel()
+
+		info := readBuildInfo()
+		resp := &response{
+			Commit:    info.Commit,
+			BuildTime: info.BuildTime,
+			GoVersion: info.GoVersion,
+		}
+		if applied, _, err := s.health.SchemaVersion(ctx); err == nil {
+			resp.SchemaVersion = &applied
+		}
+		s.respond(w, r, http.StatusOK, resp)
+	}
+}
+
 func (s *server) handleUsersCreate() http.HandlerFunc {
 	type request struct {
 		LName       string `json:"l_name"`
@@ -164,10 +591,14 @@ func (s *server) handleUsersCreate() http.HandlerFunc {
 		u := &model.User{
 			LName:       req.LName,
 			FName:       req.FName,
-			PhoneNumber: req.PhoneNumber,
+			PhoneNumber: model.NormalizePhoneNumber(req.PhoneNumber),
 		}
-		if err := s.store.User().Create(u); err != nil {
-			s.error(w, r, http.StatusUnprocessableEntity, err)
+		if err := u.Validate(); err != nil {
+			s.validationError(w, r, err)
+			return
+		}
+		if err := s.store.User().Create(r.Context(), u); err != nil {
+			s.respondError(w, r, err)
 			return
 		}
 
@@ -175,83 +606,147 @@ func (s *server) handleUsersCreate() http.HandlerFunc {
 	}
 }
 
-//func (s *server) authenticateUser(next http.Handler) http.Handler {
-//	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-//		session, err := s.sessionStore.Get(r, sessionName)
-//		if err != nil {
-//			s.error(w, r, http.StatusInternalServerError, err)
-//			return
-//		}
-//
-//		id, ok := session.Values["user_id"]
-//		if !ok {
-//			s.error(w, r, http.StatusUnauthorized, errNotAuthenticated)
-//			return
-//		}
-//		g, err := s.store.User().Find(id.(int))
-//		if err != nil {
-//			s.error(w, r, http.StatusUnauthorized, errNotAuthenticated)
-//			return
-//		}
-//		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), ctxKeyUser, g)))
-//	})
-//}
-
-//func (s *server) handleWhoami() http.HandlerFunc {
-//	return func(w http.ResponseWriter, r *http.Request) {
-//		s.respond(w, r, http.StatusOK, r.Context().Value(ctxKeyUser).(*model.User))
-//	}
-//}
-
-//func (s *server) handleSessionCreate() http.HandlerFunc {
-//	type request struct {
-//		PhoneNumber string `json:"phone_number"`
-//	}
-//
-//	return func(w http.ResponseWriter, r *http.Request) {
-//		req := &request{}
-//		if err := json.NewDecoder(r.Body).Decode(req); err != nil {
-//			s.error(w, r, http.StatusBadRequest, err)
-//			return
-//		}
-//		g, err := s.store.User().FindByPhone(req.PhoneNumber)
-//		if err != nil {
-//			s.error(w, r, http.StatusUnauthorized, errIncorrectNumber)
-//		}
-//
-//		session, err := s.sessionStore.Get(r, sessionName)
-//		if err != nil {
-//			s.error(w, r, http.StatusInternalServerError, err)
-//			return
-//		}
-//
-//		session.Values["user_id"] = g.ID
-//		if err := s.sessionStore.Save(r, w, session); err != nil {
-//			s.error(w, r, http.StatusInternalServerError, err)
-//			return
-//		}
-//
-//		s.respond(w, r, http.StatusOK, nil)
-//	}
-//}
+// authenticateAPIKey authenticates requests with an
+// "Authorization: Bearer hs_..." API key. Requests without a key are passed
+// on unchanged and may still be authenticated by a session.
+func (s *server) authenticateAPIKey(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		key := bearerToken(r)
+		if !strings.HasPrefix(key, model.APIKeyPrefix) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		k, err := s.store.APIKey().FindByHash(r.Context(), hashSecret(key))
+		if err != nil {
+			if err == store.ErrRecordNotFound {
+				s.error(w, r, http.StatusUnauthorized, errInvalidAPIKey)
+				return
+			}
+			s.respondError(w, r, err)
+			return
+		}
+		if k.RevokedAt != nil {
+			s.error(w, r, http.StatusUnauthorized, errInvalidAPIKey)
+			return
+		}
+
+		now := time.Now()
+		if k.LastUsedAt == nil || now.Sub(*k.LastUsedAt) >= apiKeyTouchInterval {
+			if err := s.store.APIKey().Touch(r.Context(), k.ID, now); err != nil {
+				s.logger.WithField("api_key_id", k.ID).Warnf("touch api key: %v", err)
+			}
+		}
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), ctxKeyUser, k.User())))
+	})
+}
 
-func (s *server) handleUsersDelete() http.HandlerFunc {
-	return func(w http.ResponseWriter, r *http.Request) {
-		vars := mux.Vars(r)
-		phoneNumber := vars["phone_number"]
-		if err := s.store.User().Delete(phoneNumber); err != nil {
-			s.error(w, r, http.StatusInternalServerError, err)
+// authenticateUser lets through requests authenticated by an API key, an
+// access token in the token auth mode or the session cookie. Sessions keep
+// working in the token mode, so clients logged in before a switch of the
+// mode aren't logged out.
+func (s *server) authenticateUser(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if _, ok := r.Context().Value(ctxKeyUser).(*model.User); ok {
+			next.ServeHTTP(w, r)
 			return
 		}
+
+		var id int
+		if s.tokens != nil && bearerToken(r) != "" {
+			var err error
+			id, err = s.tokens.signer.Verify(bearerToken(r), time.Now())
+			if err != nil {
+				if err == token.ErrExpiredToken {
+					s.error(w, r, http.StatusUnauthorized, err)
+					return
+				}
+				s.error(w, r, http.StatusUnauthorized, errNotAuthenticated)
+				return
+			}
+		} else {
+			session, err := s.sessionStore.Get(r, sessionName)
+			if err != nil {
+				s.error(w, r, http.StatusUnauthorized, errNotAuthenticated)
+				return
+			}
+			var ok bool
+			id, ok = session.Values["user_id"].(int)
+			if !ok {
+				s.error(w, r, http.StatusUnauthorized, errNotAuthenticated)
+				return
+			}
+		}
+
+		g, err := s.store.User().Find(r.Context(), id)
+		if err != nil {
+			if err == store.ErrRecordNotFound {
+				s.error(w, r, http.StatusUnauthorized, errNotAuthenticated)
+				return
+			}
+			s.respondError(w, r, err)
+			return
+		}
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), ctxKeyUser, g)))
+	})
+}
+
+// bearerToken returns the token of the "Authorization: Bearer" header.
+func bearerToken(r *http.Request) string {
+	h := r.Header.Get("Authorization")
+	if !strings.HasPrefix(h, "Bearer ") {
+		return ""
 	}
+	return strings.TrimPrefix(h, "Bearer ")
 }
 
-func (s *server) handleTransactCreate() http.HandlerFunc {
+// requireRole lets through only users authenticated by authenticateUser
+// with one of the roles.
+func (s *server) requireRole(roles ...string) mux.MiddlewareFunc {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			u := currentUser(r)
+			for _, role := range roles {
+				if u.Role == role {
+					next.ServeHTTP(w, r)
+					return
+				}
+			}
+			s.error(w, r, http.StatusForbidden, errForbidden)
+		})
+	}
+}
+
+// currentUser returns the guest authenticated by authenticateUser.
+func currentUser(r *http.Request) *model.User {
+	return r.Context().Value(ctxKeyUser).(*model.User)
+}
+
+// isSelf reports whether the phone number belongs to the current user.
+// Administrators act on behalf of everyone.
+func isSelf(r *http.Request, phoneNumber string) bool {
+	u := currentUser(r)
+	return u.PhoneNumber == phoneNumber || u.IsAdmin()
+}
+
+// ownsTransact reports whether the current user may see and change t.
+func ownsTransact(r *http.Request, t *model.Transact) bool {
+	u := currentUser(r)
+	return t.User.ID == u.ID || u.IsAdmin()
+}
+
+func (s *server) handleWhoami() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		s.respond(w, r, http.StatusOK, currentUser(r))
+	}
+}
+
+// handleOTPCreate sends a one-time login code to the phone number. It answers
+// the same way whether the number is registered or not, so it can't be used
+// to find out who is a guest.
+func (s *server) handleOTPCreate() http.HandlerFunc {
 	type request struct {
-		PhoneNumber   string `json:"phone_number"`
-		ApartmentID   int    `json:"apartment_id"`
-		DateArrival   string `json:"date_arrival"`
-		DateDeparture string `json:"date_departure"`
+		PhoneNumber string `json:"phone_number"`
 	}
 
 	return func(w http.ResponseWriter, r *http.Request) {
@@ -260,137 +755,211 @@ func (s *server) handleTransactCreate() http.HandlerFunc {
 			s.error(w, r, http.StatusBadRequest, err)
 			return
 		}
-		u := &model.User{
-			PhoneNumber: req.PhoneNumber,
+		req.PhoneNumber = model.NormalizePhoneNumber(req.PhoneNumber)
+		if err := model.ValidatePhoneNumber(req.PhoneNumber); err != nil {
+			s.validationError(w, r, validation.Errors{"phone_number": err})
+			return
 		}
-		a := &model.Apartment{
-			ID: req.ApartmentID,
+
+		if _, err := s.store.User().FindByPhone(r.Context(), req.PhoneNumber); err != nil {
+			if err == store.ErrRecordNotFound {
+				s.respond(w, r, http.StatusAccepted, nil)
+				return
+			}
+			s.respondError(w, r, err)
+			return
 		}
 
-		dateArrival, err := time.Parse("2006-01-02", req.DateArrival)
-		if err != nil {
-			s.error(w, r, http.StatusUnprocessableEntity, err)
+		now := time.Now()
+		// Within the resend interval the code already sent stays valid.
+		// The response is the same, so it doesn't tell which numbers are
+		// registered.
+		latest, err := s.store.OTP().FindLatest(r.Context(), req.PhoneNumber)
+		if err != nil && err != store.ErrRecordNotFound {
+			s.respondError(w, r, err)
 			return
 		}
-		dateDeparture, err := time.Parse("2006-01-02", req.DateDeparture)
-		if err != nil {
-			s.error(w, r, http.StatusUnprocessableEntity, err)
+		if latest != nil && now.Sub(latest.CreatedAt) < otpResendInterval {
+			s.respond(w, r, http.StatusAccepted, nil)
 			return
 		}
 
-		dur := dateDeparture.Sub(dateArrival).Hours() / 24
-		apartmentPrice, err := s.store.Apartment().GetPriceApartment(req.ApartmentID)
+		code, err := newOTPCode()
 		if err != nil {
-			s.error(w, r, http.StatusInternalServerError, err)
+			s.respondError(w, r, err)
 			return
 		}
-		finishPrice := dur * float64(apartmentPrice)
-		t := &model.Transact{
-			Apartment:     a,
-			User:          u,
-			DateArrival:   dateArrival,
-			DateDeparture: dateDeparture,
-			Price:         int(finishPrice),
+		o := &model.OTP{
+			PhoneNumber: req.PhoneNumber,
+			CodeHash:    hashOTPCode(req.PhoneNumber, code),
+			ExpiresAt:   now.Add(otpTTL),
+			CreatedAt:   now,
 		}
-		if err := s.store.Transact().CreateTransact(t); err != nil {
-			s.error(w, r, http.StatusUnprocessableEntity, err)
+		// Older codes can't be used anymore, but their attempts count
+		// against the phone number until they leave the failure window.
+		if err := s.store.OTP().DeleteByPhoneNumber(r.Context(), req.PhoneNumber, now.Add(-otpFailureWindow)); err != nil {
+			s.respondError(w, r, err)
 			return
 		}
-
-		err = s.store.Apartment().FillRoom(req.ApartmentID)
-		if err != nil {
-			s.error(w, r, http.StatusInternalServerError, err)
+		if err := s.store.OTP().Create(r.Context(), o); err != nil {
+			s.respondError(w, r, err)
 			return
 		}
-		s.respond(w, r, http.StatusOK, nil)
+		if err := s.smsSender.Send(req.PhoneNumber, fmt.Sprintf("Your login code: %s", code)); err != nil {
+			s.error(w, r, http.StatusBadGateway, err)
+			return
+		}
+
+		s.respond(w, r, http.StatusAccepted, nil)
 	}
 }
 
-func (s *server) handleTransactsGetByUserID() http.HandlerFunc {
-	type response struct {
-		Items []model.Transact `json:"items"`
+func (s *server) handleSessionCreate() http.HandlerFunc {
+	type request struct {
+		PhoneNumber string `json:"phone_number"`
+		Code        string `json:"code"`
 	}
+
 	return func(w http.ResponseWriter, r *http.Request) {
-		vars := mux.Vars(r)
-		phoneNumber := vars["phoneNumber"]
-		transacts, err := s.store.Transact().FindTransactsByPhoneNumber(phoneNumber)
-		if err != nil {
-			s.error(w, r, http.StatusInternalServerError, err)
+		req := &request{}
+		if err := json.NewDecoder(r.Body).Decode(req); err != nil {
+			s.error(w, r, http.StatusBadRequest, err)
 			return
 		}
-
-		resp := &response{
-			Items: transacts,
+		req.PhoneNumber = model.NormalizePhoneNumber(req.PhoneNumber)
+		errs := validation.Errors{
+			"phone_number": model.ValidatePhoneNumber(req.PhoneNumber),
+			"code":         validation.Validate(req.Code, validation.Required),
+		}
+		if err := errs.Filter(); err != nil {
+			s.validationError(w, r, err)
+			return
 		}
 
-		s.respond(w, r, http.StatusOK, resp)
-	}
-}
-
-func (s *server) handleApartmentClassesGet() http.HandlerFunc {
-	type responce struct {
-		Items []model.ApartmentClass `json:"items"`
-	}
-	return func(w http.ResponseWriter, r *http.Request) {
-		apartmentClasses, err := s.store.ApartmentClass().FindAll()
+		o, err := s.store.OTP().FindLatest(r.Context(), req.PhoneNumber)
 		if err != nil {
-			s.error(w, r, http.StatusInternalServerError, err)
+			if err == store.ErrRecordNotFound {
+				s.error(w, r, http.StatusUnauthorized, errInvalidCode)
+				return
+			}
+			s.respondError(w, r, err)
 			return
 		}
-		resp := &responce{
-			Items: apartmentClasses,
+		now := time.Now()
+		if now.After(o.ExpiresAt) {
+			s.error(w, r, http.StatusUnauthorized, errCodeExpired)
+			return
+		}
+		failures, err := s.store.OTP().CountAttempts(r.Context(), req.PhoneNumber, now.Add(-otpFailureWindow))
+		if err != nil {
+			s.respondError(w, r, err)
+			return
+		}
+		if failures >= otpMaxFailures {
+			s.error(w, r, http.StatusTooManyRequests, errTooManyFailures)
+			return
+		}
+		// The attempt is counted before the code is checked, so parallel
+		// guesses can't get past the limit.
+		if err := s.store.OTP().IncrementAttempts(r.Context(), o.ID, otpMaxAttempts); err != nil {
+			if err == store.ErrRecordNotFound {
+				s.error(w, r, http.StatusTooManyRequests, errTooManyAttempts)
+				return
+			}
+			s.respondError(w, r, err)
+			return
+		}
+		if subtle.ConstantTimeCompare([]byte(o.CodeHash), []byte(hashOTPCode(req.PhoneNumber, req.Code))) != 1 {
+			s.error(w, r, http.StatusUnauthorized, errInvalidCode)
+			return
+		}
+		if err := s.store.OTP().DeleteByPhoneNumber(r.Context(), req.PhoneNumber, now); err != nil {
+			s.respondError(w, r, err)
+			return
 		}
-		s.respond(w, r, http.StatusOK, resp)
-	}
-}
 
-func (s *server) handleHotelsGet() http.HandlerFunc {
-	type response struct { // структура с массивом отелей для отправки на сайт
-		Hotels []model.Hotel `json:"hotels"`
-	}
-	return func(w http.ResponseWriter, r *http.Request) {
-		hotels, err := s.store.Hotel().FindAll() // в отели получаем массив отелей,
-		// в ошибку - ошибку
+		g, err := s.store.User().FindByPhone(r.Context(), req.PhoneNumber)
 		if err != nil {
-			s.error(w, r, http.StatusInternalServerError, err)
-			return // если есть ошибка, то логируем ее с 500 ошибкой и выходим с функции
+			if err == store.ErrRecordNotFound {
+				s.error(w, r, http.StatusUnauthorized, errInvalidCode)
+				return
+			}
+			s.respondError(w, r, err)
+			return
 		}
-		resp := &response{
-			Hotels: hotels, // если все ок, то добавляем отели в структуру, которая отправится на сайт
+
+		if s.tokens != nil {
+			pair, err := s.issueTokens(r.Context(), g)
+			if err != nil {
+				s.respondError(w, r, err)
+				return
+			}
+			s.respond(w, r, http.StatusOK, pair)
+			return
 		}
-		s.respond(w, r, http.StatusOK, resp)
+
+		session, _ := s.sessionStore.Get(r, sessionName)
+		session.Values["user_id"] = g.ID
+		if err := s.sessionStore.Save(r, w, session); err != nil {
+			s.respondError(w, r, err)
+			return
+		}
+
+		s.respond(w, r, http.StatusOK, g)
 	}
 }
 
-func (s *server) handleHotelGet() http.HandlerFunc {
-	type responce struct {
-		Item *model.Hotel `json:"item"`
+// handleSessionDelete logs out. In the token mode the client sends its
+// refresh token to revoke; access tokens expire on their own.
+func (s *server) handleSessionDelete() http.HandlerFunc {
+	type request struct {
+		RefreshToken string `json:"refresh_token"`
 	}
 	return func(w http.ResponseWriter, r *http.Request) {
-		vars := mux.Vars(r)
-		id, err := strconv.Atoi(vars["id"])
-		hotel, err := s.store.Hotel().Find(id)
-		if err != nil {
-			s.error(w, r, http.StatusInternalServerError, err)
-			return
+		if s.tokens != nil {
+			// Clients signed in with a session send no body.
+			req := &request{}
+			if err := json.NewDecoder(r.Body).Decode(req); err != nil && err != io.EOF {
+				s.error(w, r, http.StatusBadRequest, err)
+				return
+			}
+			if req.RefreshToken != "" {
+				t, err := s.store.RefreshToken().FindByHash(r.Context(), hashSecret(req.RefreshToken))
+				if err != nil && err != store.ErrRecordNotFound {
+					s.respondError(w, r, err)
+					return
+				}
+				if t != nil {
+					if err := s.store.RefreshToken().Revoke(r.Context(), t.ID, time.Now()); err != nil && err != store.ErrRecordNotFound {
+						s.respondError(w, r, err)
+						return
+					}
+				}
+			}
 		}
-		resp := &responce{
-			Item: hotel,
+
+		// The token mode accepts sessions too, so a session is ended in
+		// both modes.
+		session, _ := s.sessionStore.Get(r, sessionName)
+		if s.tokens == nil || !session.IsNew {
+			delete(session.Values, "user_id")
+			session.Options.MaxAge = -1
+			if err := s.sessionStore.Save(r, w, session); err != nil {
+				s.respondError(w, r, err)
+				return
+			}
 		}
-		s.respond(w, r, http.StatusOK, resp)
+
+		s.respond(w, r, http.StatusNoContent, nil)
 	}
 }
 
-func (s *server) handleHotelCreate() http.HandlerFunc {
+// handleTokenRefresh exchanges a refresh token for a new pair of tokens. A
+// refresh token works once; presenting a used one means it was stolen, so
+// all tokens of the user are revoked.
+func (s *server) handleTokenRefresh() http.HandlerFunc {
 	type request struct {
-		Name               string `json:"name"`
-		StarsCount         int    `json:"stars_count"`
-		Description        string `json:"description"`
-		Country            string `json:"country"`
-		City               string `json:"city"`
-		Street             string `json:"street"`
-		House              string `json:"house"`
-		HeaderImageAddress string `json:"header_image_address"`
+		RefreshToken string `json:"refresh_token"`
 	}
 	return func(w http.ResponseWriter, r *http.Request) {
 		req := &request{}
@@ -398,21 +967,803 @@ func (s *server) handleHotelCreate() http.HandlerFunc {
 			s.error(w, r, http.StatusBadRequest, err)
 			return
 		}
-		a := &model.Address{
-			Country: req.Country,
-			City:    req.City,
-			Street:  req.Street,
-			House:   req.House,
+
+		t, err := s.store.RefreshToken().FindByHash(r.Context(), hashSecret(req.RefreshToken))
+		if err != nil {
+			if err == store.ErrRecordNotFound {
+				s.error(w, r, http.StatusUnauthorized, token.ErrInvalidToken)
+				return
+			}
+			s.respondError(w, r, err)
+			return
 		}
-		h := &model.Hotel{
-			Name:               req.Name,
-			Address:            a,
+		now := time.Now()
+		if t.RevokedAt != nil {
+			s.refreshTokenReused(w, r, t.UserID, now)
+			return
+		}
+		if now.After(t.ExpiresAt) {
+			s.error(w, r, http.StatusUnauthorized, token.ErrExpiredToken)
+			return
+		}
+		if err := s.store.RefreshToken().Revoke(r.Context(), t.ID, now); err != nil {
+			if err == store.ErrRecordNotFound {
+				s.refreshTokenReused(w, r, t.UserID, now)
+				return
+			}
+			s.respondError(w, r, err)
+			return
+		}
+
+		g, err := s.store.User().Find(r.Context(), t.UserID)
+		if err != nil {
+			if err == store.ErrRecordNotFound {
+				s.error(w, r, http.StatusUnauthorized, token.ErrInvalidToken)
+				return
+			}
+			s.respondError(w, r, err)
+			return
+		}
+		pair, err := s.issueTokens(r.Context(), g)
+		if err != nil {
+			s.respondError(w, r, err)
+			return
+		}
+
+		s.respond(w, r, http.StatusOK, pair)
+	}
+}
+
+// refreshTokenReused revokes all refresh tokens of the user after one of
+// them was presented twice.
+func (s *server) refreshTokenReused(w http.ResponseWriter, r *http.Request, userID int, now time.Time) {
+	if err := s.store.RefreshToken().RevokeByUserID(r.Context(), userID, now); err != nil {
+		s.respondError(w, r, err)
+		return
+	}
+	s.error(w, r, http.StatusUnauthorized, token.ErrInvalidToken)
+}
+
+// issueTokens signs an access token of the user and stores a new refresh
+// token.
+func (s *server) issueTokens(ctx context.Context, g *model.User) (*tokenPair, error) {
+	now := time.Now()
+	access, err := s.tokens.signer.Sign(g.ID, now)
+	if err != nil {
+		return nil, err
+	}
+	refresh, err := randomSecret()
+	if err != nil {
+		return nil, err
+	}
+	t := &model.RefreshToken{
+		UserID:    g.ID,
+		TokenHash: hashSecret(refresh),
+		ExpiresAt: now.Add(s.tokens.refreshTTL),
+		CreatedAt: now,
+	}
+	if err := s.store.RefreshToken().Create(ctx, t); err != nil {
+		return nil, err
+	}
+
+	return &tokenPair{
+		AccessToken:  access,
+		RefreshToken: refresh,
+		TokenType:    "Bearer",
+		ExpiresIn:    int(s.tokens.signer.TTL().Seconds()),
+		User:         g,
+	}, nil
+}
+
+// newOTPCode returns a random code of otpDigits digits.
+func newOTPCode() (string, error) {
+	max := big.NewInt(1)
+	for i := 0; i < otpDigits; i++ {
+		max.Mul(max, big.NewInt(10))
+	}
+	n, err := rand.Int(rand.Reader, max)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%0*d", otpDigits, n), nil
+}
+
+// newAPIKey generates a new secret for k and sets its key, prefix and hash.
+func newAPIKey(k *model.APIKey) error {
+	secret, err := randomSecret()
+	if err != nil {
+		return err
+	}
+	k.Key = model.APIKeyPrefix + secret
+	k.Prefix = k.Key[:len(model.APIKeyPrefix)+8]
+	k.KeyHash = hashSecret(k.Key)
+	return nil
+}
+
+// randomSecret returns 32 random bytes encoded for use in headers and JSON.
+func randomSecret() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// hashSecret returns the hash of an API key or refresh token stored instead
+// of the secret itself.
+func hashSecret(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
+
+// hashOTPCode returns the hash of a code stored instead of the code itself.
+// The phone number is mixed in so equal codes of different guests differ.
+func hashOTPCode(phoneNumber, code string) string {
+	sum := sha256.Sum256([]byte(phoneNumber + ":" + code))
+	return hex.EncodeToString(sum[:])
+}
+
+func (s *server) handleUsersDelete() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		vars := mux.Vars(r)
+		phoneNumber := model.NormalizePhoneNumber(vars["phone_number"])
+		if !isSelf(r, phoneNumber) {
+			s.error(w, r, http.StatusForbidden, errForbidden)
+			return
+		}
+		if err := s.store.User().Delete(r.Context(), phoneNumber); err != nil {
+			s.respondError(w, r, err)
+			return
+		}
+	}
+}
+
+// handleUserRoleUpdate changes the role of a user. Hotel managers get the
+// hotels they manage with it.
+func (s *server) handleUserRoleUpdate() http.HandlerFunc {
+	type request struct {
+		Role     string `json:"role"`
+		HotelIDs []int  `json:"hotel_ids"`
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		vars := mux.Vars(r)
+		req := &request{}
+		if err := json.NewDecoder(r.Body).Decode(req); err != nil {
+			s.error(w, r, http.StatusBadRequest, err)
+			return
+		}
+		u, err := s.store.User().FindByPhone(r.Context(), model.NormalizePhoneNumber(vars["phone_number"]))
+		if err != nil {
+			s.respondError(w, r, err)
+			return
+		}
+
+		u.Role = req.Role
+		u.HotelIDs = req.HotelIDs
+		if err := u.ValidateRole(); err != nil {
+			s.validationError(w, r, err)
+			return
+		}
+		if err := s.findHotels(r.Context(), req.HotelIDs); err != nil {
+			s.validationError(w, r, err)
+			return
+		}
+		if err := s.store.User().SetRole(r.Context(), u); err != nil {
+			s.respondError(w, r, err)
+			return
+		}
+
+		s.respond(w, r, http.StatusOK, u)
+	}
+}
+
+func (s *server) handleAPIKeysGet() http.HandlerFunc {
+	type response struct {
+		Items []model.APIKey `json:"items"`
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		keys, err := s.store.APIKey().FindAll(r.Context())
+		if err != nil {
+			s.respondError(w, r, err)
+			return
+		}
+
+		resp := &response{
+			Items: keys,
+		}
+		s.respond(w, r, http.StatusOK, resp)
+	}
+}
+
+// handleAPIKeyCreate issues a key. The key itself is returned only in this
+// response.
+func (s *server) handleAPIKeyCreate() http.HandlerFunc {
+	type request struct {
+		Name     string `json:"name"`
+		Role     string `json:"role"`
+		HotelIDs []int  `json:"hotel_ids"`
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		req := &request{}
+		if err := json.NewDecoder(r.Body).Decode(req); err != nil {
+			s.error(w, r, http.StatusBadRequest, err)
+			return
+		}
+
+		k := &model.APIKey{
+			Name:      req.Name,
+			Role:      req.Role,
+			HotelIDs:  req.HotelIDs,
+			CreatedAt: time.Now(),
+		}
+		if err := k.Validate(); err != nil {
+			s.validationError(w, r, err)
+			return
+		}
+		if err := s.findHotels(r.Context(), req.HotelIDs); err != nil {
+			s.validationError(w, r, err)
+			return
+		}
+		if err := newAPIKey(k); err != nil {
+			s.respondError(w, r, err)
+			return
+		}
+		if err := s.store.APIKey().Create(r.Context(), k); err != nil {
+			s.respondError(w, r, err)
+			return
+		}
+
+		s.respond(w, r, http.StatusCreated, k)
+	}
+}
+
+// handleAPIKeyRotate replaces the secret of a key, keeping its role and
+// hotels. The old secret stops working at once.
+func (s *server) handleAPIKeyRotate() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		vars := mux.Vars(r)
+		id, err := strconv.Atoi(vars["id"])
+		if err != nil {
+			s.error(w, r, http.StatusBadRequest, err)
+			return
+		}
+
+		k, err := s.store.APIKey().Find(r.Context(), id)
+		if err != nil {
+			s.respondError(w, r, err)
+			return
+		}
+		if err := newAPIKey(k); err != nil {
+			s.respondError(w, r, err)
+			return
+		}
+		if err := s.store.APIKey().Rotate(r.Context(), k); err != nil {
+			s.respondError(w, r, err)
+			return
+		}
+
+		s.respond(w, r, http.StatusOK, k)
+	}
+}
+
+func (s *server) handleAPIKeyRevoke() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		vars := mux.Vars(r)
+		id, err := strconv.Atoi(vars["id"])
+		if err != nil {
+			s.error(w, r, http.StatusBadRequest, err)
+			return
+		}
+
+		if err := s.store.APIKey().Revoke(r.Context(), id, time.Now()); err != nil {
+			s.respondError(w, r, err)
+			return
+		}
+
+		s.respond(w, r, http.StatusNoContent, nil)
+	}
+}
+
+func (s *server) handleTransactCreate() http.HandlerFunc {
+	type request struct {
+		ApartmentID   int    `json:"apartment_id"`
+		DateArrival   string `json:"date_arrival"`
+		DateDeparture string `json:"date_departure"`
+		Currency      string `json:"currency"`
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		req := &request{}
+		if err := json.NewDecoder(r.Body).Decode(req); err != nil {
+			s.error(w, r, http.StatusBadRequest, err)
+			return
+		}
+		u := currentUser(r)
+		if u.APIKeyID != 0 {
+			s.error(w, r, http.StatusForbidden, errForbidden)
+			return
+		}
+		a := &model.Apartment{
+			ID: req.ApartmentID,
+		}
+
+		dateArrival, dateDeparture, err := parseStayFields(req.DateArrival, req.DateDeparture)
+		if err != nil {
+			s.validationError(w, r, err)
+			return
+		}
+		t := &model.Transact{
+			Apartment:     a,
+			User:          u,
+			DateArrival:   dateArrival,
+			DateDeparture: dateDeparture,
+		}
+		if err := t.Validate(); err != nil {
+			s.validationError(w, r, err)
+			return
+		}
+
+		a, q, err := s.quote(r.Context(), req.ApartmentID, dateArrival, dateDeparture)
+		if err != nil {
+			s.quoteError(w, r, err)
+			return
+		}
+		t.Apartment = a
+		t.Price = q.Total
+		if req.Currency != "" {
+			if err := s.setDisplayPrice(t, req.Currency); err != nil {
+				s.currencyError(w, r, err)
+				return
+			}
+		}
+		if err := s.store.Transact().Create(r.Context(), t); err != nil {
+			s.respondError(w, r, err)
+			return
+		}
+		s.metrics.bookingCreated(a.Hotel.ID, t.Price)
+		s.respond(w, r, http.StatusOK, nil)
+	}
+}
+
+func (s *server) handleTransactUpdate() http.HandlerFunc {
+	type request struct {
+		ApartmentID   *int    `json:"apartment_id"`
+		DateArrival   *string `json:"date_arrival"`
+		DateDeparture *string `json:"date_departure"`
+	}
+	type response struct {
+		Item *model.Transact `json:"item"`
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		vars := mux.Vars(r)
+		id, err := strconv.Atoi(vars["id"])
+		if err != nil {
+			s.error(w, r, http.StatusBadRequest, err)
+			return
+		}
+		req := &request{}
+		if err := json.NewDecoder(r.Body).Decode(req); err != nil {
+			s.error(w, r, http.StatusBadRequest, err)
+			return
+		}
+
+		t, err := s.store.Transact().Find(r.Context(), id)
+		if err != nil {
+			s.respondError(w, r, err)
+			return
+		}
+		if !ownsTransact(r, t) {
+			s.error(w, r, http.StatusForbidden, errForbidden)
+			return
+		}
+		if t.Status == model.TransactStatusCancelled {
+			s.respondError(w, r, store.ErrTransactCancelled)
+			return
+		}
+
+		if req.ApartmentID != nil && *req.ApartmentID != t.Apartment.ID {
+			a, err := s.store.Apartment().Find(r.Context(), *req.ApartmentID)
+			if err != nil {
+				s.respondError(w, r, err)
+				return
+			}
+			if a.Hotel.ID != t.Apartment.Hotel.ID {
+				s.error(w, r, http.StatusUnprocessableEntity, errOtherHotel)
+				return
+			}
+			t.Apartment = a
+		}
+
+		previousArrival := t.DateArrival
+		arrival := t.DateArrival.Format(dateLayout)
+		if req.DateArrival != nil {
+			arrival = *req.DateArrival
+		}
+		departure := t.DateDeparture.Format(dateLayout)
+		if req.DateDeparture != nil {
+			departure = *req.DateDeparture
+		}
+		t.DateArrival, t.DateDeparture, err = parseStayFields(arrival, departure)
+		if err != nil {
+			s.validationError(w, r, err)
+			return
+		}
+		if err := t.ValidateUpdate(previousArrival); err != nil {
+			s.validationError(w, r, err)
+			return
+		}
+
+		_, q, err := s.quote(r.Context(), t.Apartment.ID, t.DateArrival, t.DateDeparture)
+		if err != nil {
+			s.quoteError(w, r, err)
+			return
+		}
+		previousPrice := t.Price
+		t.Price = q.Total
+		if t.DisplayPrice != nil {
+			if err := s.setDisplayPrice(t, t.DisplayPrice.Currency); err != nil {
+				s.currencyError(w, r, err)
+				return
+			}
+		}
+
+		if err := s.store.Transact().Update(r.Context(), t); err != nil {
+			s.respondError(w, r, err)
+			return
+		}
+		s.metrics.bookingRepriced(t.Apartment.Hotel.ID, previousPrice, t.Price)
+
+		resp := &response{
+			Item: t,
+		}
+		s.respond(w, r, http.StatusOK, resp)
+	}
+}
+
+func (s *server) handleTransactHistoryGet() http.HandlerFunc {
+	type response struct {
+		Items []model.TransactVersion `json:"items"`
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		vars := mux.Vars(r)
+		id, err := strconv.Atoi(vars["id"])
+		if err != nil {
+			s.error(w, r, http.StatusBadRequest, err)
+			return
+		}
+
+		t, err := s.store.Transact().Find(r.Context(), id)
+		if err != nil {
+			s.respondError(w, r, err)
+			return
+		}
+		if !ownsTransact(r, t) {
+			s.error(w, r, http.StatusForbidden, errForbidden)
+			return
+		}
+
+		versions, err := s.store.Transact().History(r.Context(), id)
+		if err != nil {
+			s.respondError(w, r, err)
+			return
+		}
+
+		resp := &response{
+			Items: versions,
+		}
+		s.respond(w, r, http.StatusOK, resp)
+	}
+}
+
+func (s *server) handleTransactCancel() http.HandlerFunc {
+	type response struct {
+		Item *model.Transact `json:"item"`
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		vars := mux.Vars(r)
+		id, err := strconv.Atoi(vars["id"])
+		if err != nil {
+			s.error(w, r, http.StatusBadRequest, err)
+			return
+		}
+
+		t, err := s.store.Transact().Find(r.Context(), id)
+		if err != nil {
+			s.respondError(w, r, err)
+			return
+		}
+		if !ownsTransact(r, t) {
+			s.error(w, r, http.StatusForbidden, errForbidden)
+			return
+		}
+		if t.Status == model.TransactStatusCancelled {
+			s.respondError(w, r, store.ErrTransactCancelled)
+			return
+		}
+		now := time.Now()
+		if !now.Before(t.DateArrival) {
+			s.errorCode(w, r, http.StatusConflict, codeStayBegun, errStayBegun)
+			return
+		}
+
+		// The policy the stay was booked under, not the current one of
+		// the hotel.
+		refund := t.CancellationPolicy.Refund(t.Price, t.DateArrival, now)
+		t.CancelledAt = &now
+		t.Refund = &refund
+		if err := s.store.Transact().Cancel(r.Context(), t); err != nil {
+			s.respondError(w, r, err)
+			return
+		}
+		s.metrics.bookingCancelled(t.Apartment.Hotel.ID, refund)
+
+		resp := &response{
+			Item: t,
+		}
+		s.respond(w, r, http.StatusOK, resp)
+	}
+}
+
+func (s *server) handleTransactsGetByUserID() http.HandlerFunc {
+	type response struct {
+		Items      []model.Transact `json:"items"`
+		NextCursor string           `json:"next_cursor"`
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		vars := mux.Vars(r)
+		phoneNumber := model.NormalizePhoneNumber(vars["phoneNumber"])
+		if !isSelf(r, phoneNumber) {
+			s.error(w, r, http.StatusForbidden, errForbidden)
+			return
+		}
+		opts, err := listOptions(r)
+		if err != nil {
+			s.validationError(w, r, err)
+			return
+		}
+		transacts, next, err := s.store.Transact().FindTransactsByPhoneNumber(r.Context(), phoneNumber, opts)
+		if err != nil {
+			s.listError(w, r, err)
+			return
+		}
+
+		resp := &response{
+			Items:      transacts,
+			NextCursor: next,
+		}
+
+		s.respond(w, r, http.StatusOK, resp)
+	}
+}
+
+func (s *server) handleApartmentClassesGet() http.HandlerFunc {
+	type responce struct {
+		Items      []model.ApartmentClass `json:"items"`
+		NextCursor string                 `json:"next_cursor"`
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		opts, err := listOptions(r)
+		if err != nil {
+			s.validationError(w, r, err)
+			return
+		}
+		apartmentClasses, next, err := s.store.ApartmentClass().FindAll(r.Context(), opts)
+		if err != nil {
+			s.listError(w, r, err)
+			return
+		}
+		resp := &responce{
+			Items:      apartmentClasses,
+			NextCursor: next,
+		}
+		s.respond(w, r, http.StatusOK, resp)
+	}
+}
+
+func (s *server) handleExchangeRatesGet() http.HandlerFunc {
+	type response struct {
+		Item exchange.Rates `json:"item"`
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		resp := &response{
+			Item: s.rates.Rates(),
+		}
+		s.respond(w, r, http.StatusOK, resp)
+	}
+}
+
+func (s *server) handleExchangeRatesUpdate() http.HandlerFunc {
+	type response struct {
+		Item exchange.Rates `json:"item"`
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		req := exchange.Rates{}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			s.error(w, r, http.StatusBadRequest, err)
+			return
+		}
+		if err := s.rates.Set(req); err != nil {
+			s.error(w, r, http.StatusUnprocessableEntity, err)
+			return
+		}
+		resp := &response{
+			Item: s.rates.Rates(),
+		}
+		s.respond(w, r, http.StatusOK, resp)
+	}
+}
+
+func (s *server) handleHotelsGet() http.HandlerFunc {
+	type response struct { // структура с массивом отелей для отправки на сайт
+		Hotels     []model.Hotel `json:"hotels"`
+		NextCursor string        `json:"next_cursor"`
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		currency, err := displayCurrency(r)
+		if err != nil {
+			s.currencyError(w, r, err)
+			return
+		}
+		f, err := s.hotelFilter(r, currency)
+		if err != nil {
+			s.validationError(w, r, err)
+			return
+		}
+		opts, err := listOptions(r)
+		if err != nil {
+			s.validationError(w, r, err)
+			return
+		}
+		hotels, next, err := s.store.Hotel().FindAll(r.Context(), f, opts) // в отели получаем страницу отелей,
+		// в next - курсор следующей страницы, в ошибку - ошибку
+		if err != nil {
+			s.listError(w, r, err)
+			return // если есть ошибка, то логируем ее и выходим с функции
+		}
+		for i := range hotels {
+			if err := s.convertPrice(hotels[i].MinPrice, currency); err != nil {
+				s.currencyError(w, r, err)
+				return
+			}
+		}
+		resp := &response{
+			Hotels:     hotels, // если все ок, то добавляем отели в структуру, которая отправится на сайт
+			NextCursor: next,
+		}
+		s.respond(w, r, http.StatusOK, resp)
+	}
+}
+
+func (s *server) handleHotelsSearch() http.HandlerFunc {
+	type response struct {
+		Items      []model.HotelSearchResult `json:"items"`
+		NextCursor string                    `json:"next_cursor"`
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		text := strings.TrimSpace(r.URL.Query().Get("q"))
+		if text == "" {
+			s.error(w, r, http.StatusUnprocessableEntity, errEmptyQuery)
+			return
+		}
+		currency, err := displayCurrency(r)
+		if err != nil {
+			s.currencyError(w, r, err)
+			return
+		}
+		opts, err := listOptions(r)
+		if err != nil {
+			s.validationError(w, r, err)
+			return
+		}
+		if opts.Sort == "" {
+			opts.Desc = true
+		}
+
+		results, next, err := s.store.Hotel().Search(r.Context(), text, opts)
+		if err != nil {
+			s.listError(w, r, err)
+			return
+		}
+		for _, res := range results {
+			if err := s.convertPrice(res.Hotel.MinPrice, currency); err != nil {
+				s.currencyError(w, r, err)
+				return
+			}
+		}
+
+		resp := &response{
+			Items:      results,
+			NextCursor: next,
+		}
+		s.respond(w, r, http.StatusOK, resp)
+	}
+}
+
+func (s *server) handleHotelGet() http.HandlerFunc {
+	type responce struct {
+		Item *model.Hotel `json:"item"`
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		vars := mux.Vars(r)
+		id, err := strconv.Atoi(vars["id"])
+		if err != nil {
+			s.error(w, r, http.StatusBadRequest, err)
+			return
+		}
+		hotel, err := s.store.Hotel().Find(r.Context(), id)
+		if err != nil {
+			s.respondError(w, r, err)
+			return
+		}
+		currency, err := displayCurrency(r)
+		if err != nil {
+			s.currencyError(w, r, err)
+			return
+		}
+		if err := s.convertPrice(hotel.MinPrice, currency); err != nil {
+			s.currencyError(w, r, err)
+			return
+		}
+		resp := &responce{
+			Item: hotel,
+		}
+		s.respond(w, r, http.StatusOK, resp)
+	}
+}
+
+func (s *server) handleHotelCreate() http.HandlerFunc {
+	type request struct {
+		Name                       string `json:"name"`
+		StarsCount                 int    `json:"stars_count"`
+		Description                string `json:"description"`
+		Country                    string `json:"country"`
+		City                       string `json:"city"`
+		Street                     string `json:"street"`
+		House                      string `json:"house"`
+		HeaderImageAddress         string `json:"header_image_address"`
+		FreeCancellationDays       int    `json:"free_cancellation_days"`
+		CancellationPenaltyPercent int    `json:"cancellation_penalty_percent"`
+		Currency                   string `json:"currency"`
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		req := &request{}
+		if err := json.NewDecoder(r.Body).Decode(req); err != nil {
+			s.error(w, r, http.StatusBadRequest, err)
+			return
+		}
+		a := &model.Address{
+			Country: req.Country,
+			City:    req.City,
+			Street:  req.Street,
+			House:   req.House,
+		}
+		h := &model.Hotel{
+			Name:               req.Name,
+			Address:            a,
 			StarsCount:         req.StarsCount,
 			Description:        req.Description,
 			HeaderImageAddress: req.HeaderImageAddress,
+			CancellationPolicy: &model.CancellationPolicy{
+				FreeDays:       req.FreeCancellationDays,
+				PenaltyPercent: req.CancellationPenaltyPercent,
+			},
+			Currency: req.Currency,
 		}
-		if err := s.store.Hotel().Create(h); err != nil {
-			s.error(w, r, http.StatusUnprocessableEntity, err)
+		if h.Currency == "" {
+			h.Currency = model.DefaultCurrency
+		}
+		if err := h.Validate(); err != nil {
+			s.validationError(w, r, err)
+			return
+		}
+		err := s.store.WithTx(r.Context(), func(st store.Store) error {
+			if err := st.Address().Create(r.Context(), h.Address); err != nil {
+				return err
+			}
+			return st.Hotel().Create(r.Context(), h)
+		})
+		if err != nil {
+			s.respondError(w, r, err)
 			return
 		}
 		s.respond(w, r, http.StatusCreated, h)
@@ -421,36 +1772,41 @@ func (s *server) handleHotelCreate() http.HandlerFunc {
 
 func (s *server) handleHotelUpdate() http.HandlerFunc {
 	type request struct {
-		Name               string `json:"name"`
-		StarsCount         int    `json:"stars_count"`
-		Description        string `json:"description"`
-		Country            string `json:"country"`
-		City               string `json:"city"`
-		Street             string `json:"street"`
-		House              string `json:"house"`
-		HeaderImageAddress string `json:"header_image_address"`
+		Name                       string `json:"name"`
+		StarsCount                 int    `json:"stars_count"`
+		Description                string `json:"description"`
+		Country                    string `json:"country"`
+		City                       string `json:"city"`
+		Street                     string `json:"street"`
+		House                      string `json:"house"`
+		HeaderImageAddress         string `json:"header_image_address"`
+		FreeCancellationDays       int    `json:"free_cancellation_days"`
+		CancellationPenaltyPercent int    `json:"cancellation_penalty_percent"`
+		Currency                   string `json:"currency"`
 	}
 	return func(w http.ResponseWriter, r *http.Request) {
 		req := &request{}
 		vars := mux.Vars(r)
 		id, err := strconv.Atoi(vars["id"])
-		fmt.Println("id in server.go = ", id)
 		if err != nil {
-
 			s.error(w, r, http.StatusUnprocessableEntity, err)
 			return
 		}
+		if !currentUser(r).Manages(id) {
+			s.error(w, r, http.StatusForbidden, errForbidden)
+			return
+		}
 		if err := json.NewDecoder(r.Body).Decode(req); err != nil {
 			s.error(w, r, http.StatusBadRequest, err)
 			return
 		}
-		_, err = s.store.Hotel().Find(id)
+		current, err := s.store.Hotel().Find(r.Context(), id)
 		if err != nil {
-			s.error(w, r, http.StatusInternalServerError, err)
+			s.respondError(w, r, err)
 			return
 		}
-
 		a := &model.Address{
+			ID:      current.Address.ID,
 			Country: req.Country,
 			City:    req.City,
 			Street:  req.Street,
@@ -463,11 +1819,43 @@ func (s *server) handleHotelUpdate() http.HandlerFunc {
 			StarsCount:         req.StarsCount,
 			Description:        req.Description,
 			HeaderImageAddress: req.HeaderImageAddress,
+			CancellationPolicy: &model.CancellationPolicy{
+				FreeDays:       req.FreeCancellationDays,
+				PenaltyPercent: req.CancellationPenaltyPercent,
+			},
+			Currency: req.Currency,
+		}
+		if h.Currency == "" {
+			h.Currency = current.Currency
+		}
+		if err := h.Validate(); err != nil {
+			s.validationError(w, r, err)
+			return
+		}
+		err = s.store.WithTx(r.Context(), func(st store.Store) error {
+			// Prices of the apartments are stored in the currency of the
+			// hotel, so changing it would silently reprice them.
+			if h.Currency != current.Currency {
+				apartments, _, err := st.Apartment().FindByHotelID(r.Context(), id, &store.ListOptions{Limit: 1})
+				if err != nil {
+					return err
+				}
+				if len(apartments) > 0 {
+					return errCurrencyInUse
+				}
+			}
+			if err := st.Address().Update(r.Context(), h.Address); err != nil {
+				return err
+			}
+			return st.Hotel().Update(r.Context(), h)
+		})
+		if errors.Is(err, errCurrencyInUse) {
+			s.errorCode(w, r, http.StatusConflict, codeCurrencyInUse, err)
+			return
 		}
-		fmt.Println("че по чем")
-		err = s.store.Hotel().Update(h)
 		if err != nil {
-			fmt.Println("err = ", err)
+			s.respondError(w, r, err)
+			return
 		}
 		s.respond(w, r, http.StatusOK, nil)
 	}
@@ -484,12 +1872,55 @@ func (s *server) handleApartmentsCreate() http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		req := &request{}
 		if err := json.NewDecoder(r.Body).Decode(req); err != nil {
-			fmt.Println("err > ", err)
 			s.error(w, r, http.StatusBadRequest, err)
 			return
 		}
-		if err := s.store.Apartment().Create(req.BedCount, req.Price, req.ApartmentClassID, req.HotelID, req.Name); err != nil {
-			s.error(w, r, http.StatusUnprocessableEntity, err)
+		errs := validation.Errors{}
+		bedCount, err := strconv.Atoi(req.BedCount.String())
+		if err != nil {
+			errs["bed_count"] = errInvalidNumber
+		}
+		apartmentClassID, err := strconv.Atoi(req.ApartmentClassID.String())
+		if err != nil {
+			errs["apartment_class_id"] = errInvalidNumber
+		}
+		hotelID, err := strconv.Atoi(req.HotelID.String())
+		if err != nil {
+			errs["hotel_id"] = errInvalidNumber
+		}
+		if err := errs.Filter(); err != nil {
+			s.validationError(w, r, err)
+			return
+		}
+		if !currentUser(r).Manages(hotelID) {
+			s.error(w, r, http.StatusForbidden, errForbidden)
+			return
+		}
+
+		h, err := s.store.Hotel().Find(r.Context(), hotelID)
+		if err != nil {
+			s.respondError(w, r, err)
+			return
+		}
+		price, err := model.ParseMoney(req.Price.String(), h.Currency)
+		if err != nil {
+			s.validationError(w, r, validation.Errors{"price": err})
+			return
+		}
+
+		a := &model.Apartment{
+			Name:           req.Name,
+			Hotel:          h,
+			ApartmentClass: &model.ApartmentClass{ID: apartmentClassID},
+			BedCount:       bedCount,
+			Price:          price,
+		}
+		if err := a.Validate(); err != nil {
+			s.validationError(w, r, err)
+			return
+		}
+		if err := s.store.Apartment().Create(r.Context(), a); err != nil {
+			s.respondError(w, r, err)
 			return
 		}
 		s.respond(w, r, http.StatusCreated, nil)
@@ -500,34 +1931,593 @@ func (s *server) handleApartmentsByHotelIDGet() http.HandlerFunc {
 	type response struct {
 		Apartments       []model.Apartment      `json:"apartments"`
 		ApartmentsImages []model.ApartmentImage `json:"apartments_images"`
+		NextCursor       string                 `json:"next_cursor"`
 	}
 	return func(w http.ResponseWriter, r *http.Request) {
 		vars := mux.Vars(r)
 		id, err := strconv.Atoi(vars["id"])
+		if err != nil {
+			s.error(w, r, http.StatusBadRequest, err)
+			return
+		}
+
+		currency, err := displayCurrency(r)
+		if err != nil {
+			s.currencyError(w, r, err)
+			return
+		}
+
+		opts, err := listOptions(r)
+		if err != nil {
+			s.validationError(w, r, err)
+			return
+		}
 
-		apartments, err := s.store.Apartment().FindByHotelID(id)
+		var apartments []model.Apartment
+		var next string
+		query := r.URL.Query()
+		if query.Get("arrival") == "" && query.Get("departure") == "" {
+			apartments, next, err = s.store.Apartment().FindByHotelID(r.Context(), id, opts)
+		} else {
+			arrival, departure, stayErr := parseStay(query.Get("arrival"), query.Get("departure"))
+			if stayErr != nil {
+				s.validationError(w, r, stayErr)
+				return
+			}
+			apartments, next, err = s.store.Apartment().FindAvailableByHotelID(r.Context(), id, arrival, departure, opts)
+		}
 		if err != nil {
-			fmt.Println("err > ", err)
-			s.error(w, r, http.StatusInternalServerError, err)
+			s.listError(w, r, err)
 			return
 		}
 
-		apartmentsImages, err := s.store.ApartmentImage().GetImagesByHotelID(id)
+		for i := range apartments {
+			if err := s.convertPrice(&apartments[i].Price, currency); err != nil {
+				s.currencyError(w, r, err)
+				return
+			}
+		}
+
+		apartmentsImages, err := s.store.ApartmentImage().GetImagesByHotelID(r.Context(), id)
 		if err != nil {
-			fmt.Println("err > ", err)
-			s.error(w, r, http.StatusInternalServerError, err)
+			s.respondError(w, r, err)
 			return
 		}
 		resp := &response{
 			Apartments:       apartments,
 			ApartmentsImages: apartmentsImages,
+			NextCursor:       next,
+		}
+		s.respond(w, r, http.StatusOK, resp)
+	}
+}
+
+// quote prices a stay in the apartment from arrival to departure using its
+// rate plan.
+func (s *server) quote(ctx context.Context, apartmentID int, arrival, departure time.Time) (*model.Apartment, *pricing.Quote, error) {
+	a, err := s.store.Apartment().Find(ctx, apartmentID)
+	if err != nil {
+		return nil, nil, err
+	}
+	plan, err := s.store.RatePlan().Find(ctx, apartmentID)
+	if err != nil {
+		return nil, nil, err
+	}
+	q, err := s.pricing.Quote(a, plan, arrival, departure)
+	return a, q, err
+}
+
+func (s *server) quoteError(w http.ResponseWriter, r *http.Request, err error) {
+	if errors.Is(err, pricing.ErrMinStay) {
+		s.errorCode(w, r, http.StatusUnprocessableEntity, codeMinStay, err)
+		return
+	}
+	s.respondError(w, r, err)
+}
+
+// hotelFilter builds the hotel filter from the query parameters of r. Price
+// bounds are read in currency, or the default currency, and converted into
+// every currency with a known exchange rate so hotels pricing in other
+// currencies can match them too. Sorting by price compares the prices
+// converted into the same currency.
+func (s *server) hotelFilter(r *http.Request, currency string) (*store.HotelFilter, error) {
+	query := r.URL.Query()
+	f := &store.HotelFilter{
+		Country: query.Get("country"),
+		City:    query.Get("city"),
+	}
+
+	ints := map[string]*int{
+		"min_stars":          &f.MinStars,
+		"max_stars":          &f.MaxStars,
+		"bed_count":          &f.MinBedCount,
+		"apartment_class_id": &f.ApartmentClassID,
+	}
+	errs := validation.Errors{}
+	for key, dst := range ints {
+		if v := query.Get(key); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil {
+				errs[key] = errInvalidNumber
+				continue
+			}
+			*dst = n
+		}
+	}
+
+	if query.Get("arrival") != "" || query.Get("departure") != "" {
+		var err error
+		f.DateArrival, f.DateDeparture, err = parseStay(query.Get("arrival"), query.Get("departure"))
+		if stayErrs, ok := err.(validation.Errors); ok {
+			for key, stayErr := range stayErrs {
+				errs[key] = stayErr
+			}
+		}
+	}
+
+	if currency == "" {
+		currency = model.DefaultCurrency
+	}
+	rates := s.rates.Rates()
+	currencies := []string{currency}
+	if rates.Base != "" && rates.Base != currency {
+		currencies = append(currencies, rates.Base)
+	}
+	for c := range rates.Rates {
+		if c != currency {
+			currencies = append(currencies, c)
+		}
+	}
+
+	f.PriceRates = map[string]*big.Rat{}
+	for _, c := range currencies {
+		if rate, err := s.rates.MinorRate(c, currency); err == nil {
+			f.PriceRates[c] = rate
+		}
+	}
+
+	var minPrice, maxPrice *model.Money
+	if v := query.Get("min_price"); v != "" {
+		m, err := model.ParseMoney(v, currency)
+		if err != nil {
+			errs["min_price"] = err
+		} else {
+			minPrice = &m
+		}
+	}
+	if v := query.Get("max_price"); v != "" {
+		m, err := model.ParseMoney(v, currency)
+		if err != nil {
+			errs["max_price"] = err
+		} else {
+			maxPrice = &m
+		}
+	}
+	if err := errs.Filter(); err != nil {
+		return nil, err
+	}
+	if minPrice == nil && maxPrice == nil {
+		return f, nil
+	}
+
+	for _, c := range currencies {
+		pr := store.PriceRange{
+			Currency: c,
+		}
+		if minPrice != nil {
+			m, _, err := s.rates.Convert(*minPrice, c)
+			if err != nil {
+				continue
+			}
+			pr.Min = &m.Amount
+		}
+		if maxPrice != nil {
+			m, _, err := s.rates.Convert(*maxPrice, c)
+			if err != nil {
+				continue
+			}
+			pr.Max = &m.Amount
+		}
+		f.PriceRanges = append(f.PriceRanges, pr)
+	}
+	return f, nil
+}
+
+// listOptions reads the limit, cursor and sort query parameters of a list.
+// A sort key prefixed with a minus orders descending, e.g. sort=-price.
+func listOptions(r *http.Request) (*store.ListOptions, error) {
+	query := r.URL.Query()
+	opts := &store.ListOptions{
+		Cursor: query.Get("cursor"),
+		Sort:   strings.TrimPrefix(query.Get("sort"), "-"),
+		Desc:   strings.HasPrefix(query.Get("sort"), "-"),
+	}
+	if v := query.Get("limit"); v != "" {
+		limit, err := strconv.Atoi(v)
+		if err != nil || limit < 1 || limit > store.MaxLimit {
+			return nil, validation.Errors{"limit": errInvalidLimit}
+		}
+		opts.Limit = limit
+	}
+	return opts, nil
+}
+
+func (s *server) listError(w http.ResponseWriter, r *http.Request, err error) {
+	switch err {
+	case store.ErrInvalidCursor, store.ErrInvalidSort:
+		s.error(w, r, http.StatusBadRequest, err)
+	default:
+		s.respondError(w, r, err)
+	}
+}
+
+// displayCurrency returns the currency requested with the currency query
+// parameter, or an empty string when prices stay in the currencies of hotels.
+func displayCurrency(r *http.Request) (string, error) {
+	currency := r.URL.Query().Get("currency")
+	if currency != "" && !model.ValidCurrency(currency) {
+		return "", model.ErrInvalidCurrency
+	}
+	return currency, nil
+}
+
+// convertPrice converts m in place into currency. It does nothing when no
+// currency was requested or there is no price.
+func (s *server) convertPrice(m *model.Money, currency string) error {
+	if m == nil || currency == "" {
+		return nil
+	}
+	converted, _, err := s.rates.Convert(*m, currency)
+	if err != nil {
+		return err
+	}
+	*m = converted
+	return nil
+}
+
+// setDisplayPrice records the price of t as shown to the guest in currency
+// along with the exchange rate used.
+func (s *server) setDisplayPrice(t *model.Transact, currency string) error {
+	if !model.ValidCurrency(currency) {
+		return model.ErrInvalidCurrency
+	}
+	displayPrice, rate, err := s.rates.Convert(t.Price, currency)
+	if err != nil {
+		return err
+	}
+	t.DisplayPrice = &displayPrice
+	t.ExchangeRate = rate
+	return nil
+}
+
+func (s *server) currencyError(w http.ResponseWriter, r *http.Request, err error) {
+	switch err {
+	case model.ErrInvalidCurrency, exchange.ErrUnknownCurrency:
+		s.errorCode(w, r, http.StatusUnprocessableEntity, codeUnknownCurrency, err)
+	default:
+		s.respondError(w, r, err)
+	}
+}
+
+// parseStayFields parses the arrival and departure dates of a stay given in
+// a request body, reporting unparsable dates by field.
+func parseStayFields(arrival, departure string) (time.Time, time.Time, error) {
+	errs := validation.Errors{}
+	dateArrival, err := time.Parse(dateLayout, arrival)
+	if err != nil {
+		errs["date_arrival"] = errInvalidDate
+	}
+	dateDeparture, err := time.Parse(dateLayout, departure)
+	if err != nil {
+		errs["date_departure"] = errInvalidDate
+	}
+	return dateArrival, dateDeparture, errs.Filter()
+}
+
+// parseStay parses the arrival and departure dates of a stay given in query
+// parameters and checks that the guest leaves at least one night after
+// arriving. Problems are reported by parameter.
+func parseStay(arrival, departure string) (time.Time, time.Time, error) {
+	errs := validation.Errors{}
+	dateArrival, err := time.Parse(dateLayout, arrival)
+	if arrival == "" {
+		errs["arrival"] = errIncompleteStay
+	} else if err != nil {
+		errs["arrival"] = errInvalidDate
+	}
+	dateDeparture, err := time.Parse(dateLayout, departure)
+	if departure == "" {
+		errs["departure"] = errIncompleteStay
+	} else if err != nil {
+		errs["departure"] = errInvalidDate
+	}
+	if err := errs.Filter(); err != nil {
+		return time.Time{}, time.Time{}, err
+	}
+	if !dateDeparture.After(dateArrival) {
+		return time.Time{}, time.Time{}, validation.Errors{"departure": errInvalidStay}
+	}
+	return dateArrival, dateDeparture, nil
+}
+
+func (s *server) handleApartmentQuoteGet() http.HandlerFunc {
+	type response struct {
+		Item *pricing.Quote `json:"item"`
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		vars := mux.Vars(r)
+		id, err := strconv.Atoi(vars["id"])
+		if err != nil {
+			s.error(w, r, http.StatusBadRequest, err)
+			return
+		}
+		query := r.URL.Query()
+		arrival, departure, err := parseStay(query.Get("arrival"), query.Get("departure"))
+		if err != nil {
+			s.validationError(w, r, err)
+			return
+		}
+
+		currency, err := displayCurrency(r)
+		if err != nil {
+			s.currencyError(w, r, err)
+			return
+		}
+
+		_, q, err := s.quote(r.Context(), id, arrival, departure)
+		if err != nil {
+			s.quoteError(w, r, err)
+			return
+		}
+		if currency != "" {
+			q, err = q.In(currency, s.rates)
+			if err != nil {
+				s.currencyError(w, r, err)
+				return
+			}
+		}
+
+		resp := &response{
+			Item: q,
+		}
+		s.respond(w, r, http.StatusOK, resp)
+	}
+}
+
+func (s *server) handleRatePlanGet() http.HandlerFunc {
+	type response struct {
+		Item *model.RatePlan `json:"item"`
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		vars := mux.Vars(r)
+		id, err := strconv.Atoi(vars["id"])
+		if err != nil {
+			s.error(w, r, http.StatusBadRequest, err)
+			return
+		}
+
+		plan, err := s.store.RatePlan().Find(r.Context(), id)
+		if err != nil {
+			s.respondError(w, r, err)
+			return
+		}
+
+		resp := &response{
+			Item: plan,
+		}
+		s.respond(w, r, http.StatusOK, resp)
+	}
+}
+
+func (s *server) handleRatePlanUpdate() http.HandlerFunc {
+	type season struct {
+		DateFrom string      `json:"date_from"`
+		DateTo   string      `json:"date_to"`
+		Price    json.Number `json:"price"`
+	}
+	type request struct {
+		WeekendSurchargePercent int                  `json:"weekend_surcharge_percent"`
+		MinStay                 int                  `json:"min_stay"`
+		Seasons                 []season             `json:"seasons"`
+		StayDiscounts           []model.StayDiscount `json:"stay_discounts"`
+	}
+	type response struct {
+		Item *model.RatePlan `json:"item"`
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		vars := mux.Vars(r)
+		id, err := strconv.Atoi(vars["id"])
+		if err != nil {
+			s.error(w, r, http.StatusBadRequest, err)
+			return
+		}
+		req := &request{}
+		if err := json.NewDecoder(r.Body).Decode(req); err != nil {
+			s.error(w, r, http.StatusBadRequest, err)
+			return
+		}
+
+		a, err := s.store.Apartment().Find(r.Context(), id)
+		if err != nil {
+			s.respondError(w, r, err)
+			return
+		}
+		if !currentUser(r).Manages(a.Hotel.ID) {
+			s.error(w, r, http.StatusForbidden, errForbidden)
+			return
+		}
+
+		plan := &model.RatePlan{
+			ApartmentID:             id,
+			WeekendSurchargePercent: req.WeekendSurchargePercent,
+			MinStay:                 req.MinStay,
+			Seasons:                 []model.SeasonalRate{},
+			StayDiscounts:           req.StayDiscounts,
+		}
+		if plan.StayDiscounts == nil {
+			plan.StayDiscounts = []model.StayDiscount{}
+		}
+		for i, season := range req.Seasons {
+			errs := validation.Errors{}
+			dateFrom, err := time.Parse(dateLayout, season.DateFrom)
+			if err != nil {
+				errs["date_from"] = errInvalidDate
+			}
+			dateTo, err := time.Parse(dateLayout, season.DateTo)
+			if err != nil {
+				errs["date_to"] = errInvalidDate
+			}
+			price, err := model.ParseMoney(season.Price.String(), a.Price.Currency)
+			if err != nil {
+				errs["price"] = err
+			}
+			if err := errs.Filter(); err != nil {
+				s.validationError(w, r, validation.Errors{"seasons": validation.Errors{strconv.Itoa(i): err}})
+				return
+			}
+			plan.Seasons = append(plan.Seasons, model.SeasonalRate{
+				DateFrom: dateFrom,
+				DateTo:   dateTo,
+				Price:    price,
+			})
+		}
+		if err := plan.Validate(); err != nil {
+			s.validationError(w, r, err)
+			return
+		}
+
+		if err := s.store.RatePlan().Save(r.Context(), plan); err != nil {
+			s.respondError(w, r, err)
+			return
+		}
+
+		resp := &response{
+			Item: plan,
 		}
 		s.respond(w, r, http.StatusOK, resp)
 	}
 }
 
+// findHotels checks that all the hotels exist, reporting missing ones as an
+// error of the hotel_ids field.
+func (s *server) findHotels(ctx context.Context, ids []int) error {
+	for _, id := range ids {
+		if _, err := s.store.Hotel().Find(ctx, id); err != nil {
+			if err == store.ErrRecordNotFound {
+				return validation.Errors{"hotel_ids": fmt.Errorf("hotel %d not found", id)}
+			}
+			return err
+		}
+	}
+	return nil
+}
+
+// errorResponse is the body of every error response. Errors lists the
+// invalid fields of a request that failed validation.
+type errorResponse struct {
+	Error  errorBody         `json:"error"`
+	Errors validation.Errors `json:"errors,omitempty"`
+}
+
+type errorBody struct {
+	Code      string `json:"code"`
+	Message   string `json:"message"`
+	RequestID string `json:"request_id,omitempty"`
+}
+
+// statusCodes are the error codes of responses not given a code of their
+// own.
+var statusCodes = map[int]string{
+	http.StatusBadRequest:          "bad_request",
+	http.StatusUnauthorized:        "unauthenticated",
+	http.StatusForbidden:           "forbidden",
+	http.StatusNotFound:            "not_found",
+	http.StatusMethodNotAllowed:    "method_not_allowed",
+	http.StatusConflict:            "conflict",
+	http.StatusUnprocessableEntity: "validation_failed",
+	http.StatusTooManyRequests:     "rate_limited",
+	http.StatusInternalServerError: "internal",
+	http.StatusBadGateway:          "bad_gateway",
+	http.StatusServiceUnavailable:  "unavailable",
+	http.StatusGatewayTimeout:      "timeout",
+}
+
+// kindStatuses map the kinds of store errors to response statuses.
+var kindStatuses = map[store.Kind]int{
+	store.KindNotFound:    http.StatusNotFound,
+	store.KindConflict:    http.StatusConflict,
+	store.KindValidation:  http.StatusUnprocessableEntity,
+	store.KindUnavailable: http.StatusServiceUnavailable,
+	store.KindTimeout:     http.StatusGatewayTimeout,
+	store.KindCanceled:    http.StatusServiceUnavailable,
+}
+
+// respondError responds with the status and code of a store error, or with
+// 500 for any other error. A query that timed out gets 504, unless the whole
+// request ran out of time, which gets 503 like a cancelled one.
+func (s *server) respondError(w http.ResponseWriter, r *http.Request, err error) {
+	var e *store.Error
+	if !errors.As(err, &e) {
+		s.error(w, r, http.StatusInternalServerError, err)
+		return
+	}
+	if e.Kind == store.KindTimeout && r.Context().Err() == context.DeadlineExceeded {
+		s.errorCode(w, r, http.StatusServiceUnavailable, "request_timeout", err)
+		return
+	}
+	code, ok := kindStatuses[e.Kind]
+	if !ok {
+		code = http.StatusInternalServerError
+	}
+	s.errorCode(w, r, code, e.Code, err)
+}
+
 func (s *server) error(w http.ResponseWriter, r *http.Request, code int, err error) {
-	s.respond(w, r, code, map[string]string{"error": err.Error()})
+	errCode, ok := statusCodes[code]
+	if !ok {
+		errCode = "error"
+	}
+	s.errorCode(w, r, code, errCode, err)
+}
+
+// errorCode responds with the error. Messages of server errors are logged
+// instead of being shown, as they may tell about the internals.
+func (s *server) errorCode(w http.ResponseWriter, r *http.Request, code int, errCode string, err error) {
+	s.respond(w, r, code, &errorResponse{
+		Error: s.errorBody(r, code, errCode, err),
+	})
+}
+
+// validationError responds with 422 and the message of each invalid field.
+// Errors that aren't about the input are internal.
+func (s *server) validationError(w http.ResponseWriter, r *http.Request, err error) {
+	errs, ok := err.(validation.Errors)
+	if !ok {
+		s.respondError(w, r, err)
+		return
+	}
+	code := http.StatusUnprocessableEntity
+	s.respond(w, r, code, &errorResponse{
+		Error:  s.errorBody(r, code, statusCodes[code], errors.New("request is invalid")),
+		Errors: errs,
+	})
+}
+
+func (s *server) errorBody(r *http.Request, code int, errCode string, err error) errorBody {
+	requestID, _ := r.Context().Value(ctxKeyRequestID).(string)
+	message := err.Error()
+	if code >= http.StatusInternalServerError {
+		s.logger.WithFields(logrus.Fields{
+			"request_id": requestID,
+			"code":       errCode,
+		}).Error(err)
+		message = http.StatusText(code)
+	}
+	return errorBody{
+		Code:      errCode,
+		Message:   message,
+		RequestID: requestID,
+	}
 }
 
 func (s *server) respond(w http.ResponseWriter, r *http.Request, code int, data interface{}) {
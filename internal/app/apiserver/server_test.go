@@ -0,0 +1,1441 @@
+package apiserver
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/gorilla/sessions"
+	"github.com/zlyaptica/hotel_service_backend/internal/app/exchange"
+	"github.com/zlyaptica/hotel_service_backend/internal/app/model"
+	"github.com/zlyaptica/hotel_service_backend/internal/app/token"
+	"github.com/zlyaptica/hotel_service_backend/store"
+	"github.com/zlyaptica/hotel_service_backend/store/teststore"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// testSMSSender keeps the messages it was asked to send.
+type testSMSSender struct {
+	mu       sync.Mutex
+	messages map[string]string
+}
+
+func (t *testSMSSender) Send(phoneNumber, text string) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.messages[phoneNumber] = text
+	return nil
+}
+
+// testHealth reports the database state it is set to.
+type testHealth struct {
+	pingErr         error
+	applied, latest int
+}
+
+func (h *testHealth) Ping(ctx context.Context) error {
+	return h.pingErr
+}
+
+func (h *testHealth) SchemaVersion(ctx context.Context) (int, int, error) {
+	if h.pingErr != nil {
+		return 0, 0, h.pingErr
+	}
+	return h.applied, h.latest, nil
+}
+
+func newTestServer(t *testing.T) (*server, *teststore.Store, *testSMSSender) {
+	t.Helper()
+	st := teststore.New()
+	smsSender := &testSMSSender{messages: map[string]string{}}
+	s := newServer(st, sessions.NewCookieStore([]byte("secret")), exchange.NewTable(), smsSender, nil, nil, &CORSConfig{}, 0, &testHealth{applied: 4, latest: 4})
+	s.logger.SetOutput(io.Discard)
+	return s, st, smsSender
+}
+
+// newTokenTestServer returns a test server in the token auth mode.
+func newTokenTestServer(t *testing.T) (*server, *teststore.Store) {
+	t.Helper()
+	st := teststore.New()
+	tokens := &tokenAuth{
+		signer:     token.NewSigner([]byte("token secret"), time.Minute),
+		refreshTTL: time.Hour,
+	}
+	s := newServer(st, sessions.NewCookieStore([]byte("secret")), exchange.NewTable(), &testSMSSender{}, tokens, nil, &CORSConfig{}, 0, &testHealth{applied: 4, latest: 4})
+	s.logger.SetOutput(io.Discard)
+	return s, st
+}
+
+func testUser(t *testing.T, st *teststore.Store, phoneNumber, role string) *model.User {
+	t.Helper()
+	u := &model.User{
+		LName:       "Ivanov",
+		PhoneNumber: phoneNumber,
+		Role:        role,
+	}
+	if err := st.User().Create(context.Background(), u); err != nil {
+		t.Fatal(err)
+	}
+	return u
+}
+
+// testApartment creates a hotel with a single apartment priced at 1000 RUB a
+// night.
+func testApartment(t *testing.T, st *teststore.Store) *model.Apartment {
+	t.Helper()
+	h := &model.Hotel{
+		Name:               "Viking",
+		Address:            &model.Address{Country: "Russia", City: "Moscow", Street: "Tverskaya", House: "1"},
+		StarsCount:         4,
+		CancellationPolicy: &model.CancellationPolicy{FreeDays: 3, PenaltyPercent: 50},
+		Currency:           model.DefaultCurrency,
+	}
+	if err := st.Address().Create(context.Background(), h.Address); err != nil {
+		t.Fatal(err)
+	}
+	if err := st.Hotel().Create(context.Background(), h); err != nil {
+		t.Fatal(err)
+	}
+	ac := &model.ApartmentClass{Class: "standard"}
+	if err := st.ApartmentClass().(*teststore.ApartmentClassRepository).Create(context.Background(), ac); err != nil {
+		t.Fatal(err)
+	}
+	a := &model.Apartment{
+		Name:           "Double",
+		Hotel:          h,
+		ApartmentClass: ac,
+		BedCount:       2,
+		Price:          model.Money{Amount: 100000, Currency: model.DefaultCurrency},
+	}
+	if err := st.Apartment().Create(context.Background(), a); err != nil {
+		t.Fatal(err)
+	}
+	return a
+}
+
+// sessionCookie returns the cookie of a session of the user.
+func sessionCookie(t *testing.T, s *server, u *model.User) *http.Cookie {
+	t.Helper()
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	rec := httptest.NewRecorder()
+	session, _ := s.sessionStore.Get(req, sessionName)
+	session.Values["user_id"] = u.ID
+	if err := s.sessionStore.Save(req, rec, session); err != nil {
+		t.Fatal(err)
+	}
+	return rec.Result().Cookies()[0]
+}
+
+func serve(s *server, method, path string, body interface{}, cookie *http.Cookie) *httptest.ResponseRecorder {
+	b := &bytes.Buffer{}
+	if body != nil {
+		json.NewEncoder(b).Encode(body)
+	}
+	req := httptest.NewRequest(method, path, b)
+	if cookie != nil {
+		req.AddCookie(cookie)
+	}
+	rec := httptest.NewRecorder()
+	s.ServeHTTP(rec, req)
+	return rec
+}
+
+func errorCodeOf(t *testing.T, rec *httptest.ResponseRecorder) string {
+	t.Helper()
+	resp := &struct {
+		Error errorBody `json:"error"`
+	}{}
+	if err := json.NewDecoder(rec.Body).Decode(resp); err != nil {
+		t.Fatal(err)
+	}
+	return resp.Error.Code
+}
+
+func day(days int) string {
+	return time.Now().AddDate(0, 0, days).Format(dateLayout)
+}
+
+func TestServer_HandleUsersCreate(t *testing.T) {
+	s, _, _ := newTestServer(t)
+	testCases := []struct {
+		name         string
+		payload      interface{}
+		expectedCode int
+	}{
+		{
+			name: "valid",
+			payload: map[string]string{
+				"l_name":       "Ivanov",
+				"phone_number": "+79811234567",
+			},
+			expectedCode: http.StatusCreated,
+		},
+		{
+			name: "duplicate phone number",
+			payload: map[string]string{
+				"l_name":       "Petrov",
+				"phone_number": "+79811234567",
+			},
+			expectedCode: http.StatusConflict,
+		},
+		{
+			name: "duplicate in the national format",
+			payload: map[string]string{
+				"l_name":       "Petrov",
+				"phone_number": "8 981 123-45-67",
+			},
+			expectedCode: http.StatusConflict,
+		},
+		{
+			name: "invalid phone number",
+			payload: map[string]string{
+				"l_name":       "Ivanov",
+				"phone_number": "981-PHONE",
+			},
+			expectedCode: http.StatusUnprocessableEntity,
+		},
+		{
+			name:         "invalid payload",
+			payload:      "invalid",
+			expectedCode: http.StatusBadRequest,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			rec := serve(s, http.MethodPost, "/users", tc.payload, nil)
+			if rec.Code != tc.expectedCode {
+				t.Errorf("got %d, want %d: %s", rec.Code, tc.expectedCode, rec.Body)
+			}
+		})
+	}
+}
+
+func TestServer_AuthenticateUser(t *testing.T) {
+	s, st, _ := newTestServer(t)
+	u := testUser(t, st, "+79811234567", model.RoleGuest)
+
+	testCases := []struct {
+		name         string
+		cookie       *http.Cookie
+		expectedCode int
+	}{
+		{
+			name:         "authenticated",
+			cookie:       sessionCookie(t, s, u),
+			expectedCode: http.StatusOK,
+		},
+		{
+			name:         "not authenticated",
+			cookie:       nil,
+			expectedCode: http.StatusUnauthorized,
+		},
+		{
+			name:         "unknown user",
+			cookie:       sessionCookie(t, s, &model.User{ID: u.ID + 1}),
+			expectedCode: http.StatusUnauthorized,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			rec := serve(s, http.MethodGet, "/private/whoami", nil, tc.cookie)
+			if rec.Code != tc.expectedCode {
+				t.Errorf("got %d, want %d: %s", rec.Code, tc.expectedCode, rec.Body)
+			}
+		})
+	}
+}
+
+func TestServer_AuthenticateUser_TokenMode(t *testing.T) {
+	s, st := newTokenTestServer(t)
+	u := testUser(t, st, "+79811234567", model.RoleGuest)
+	pair, err := s.issueTokens(context.Background(), u)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expired, err := token.NewSigner([]byte("token secret"), time.Minute).Sign(u.ID, time.Now().Add(-time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	testCases := []struct {
+		name         string
+		token        string
+		cookie       *http.Cookie
+		expectedCode int
+	}{
+		{name: "access token", token: pair.AccessToken, expectedCode: http.StatusOK},
+		{name: "session cookie", cookie: sessionCookie(t, s, u), expectedCode: http.StatusOK},
+		{name: "expired token", token: expired, expectedCode: http.StatusUnauthorized},
+		{name: "invalid token with a session", token: "invalid", cookie: sessionCookie(t, s, u), expectedCode: http.StatusUnauthorized},
+		{name: "not authenticated", expectedCode: http.StatusUnauthorized},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/private/whoami", nil)
+			if tc.token != "" {
+				req.Header.Set("Authorization", "Bearer "+tc.token)
+			}
+			if tc.cookie != nil {
+				req.AddCookie(tc.cookie)
+			}
+			rec := httptest.NewRecorder()
+			s.ServeHTTP(rec, req)
+			if rec.Code != tc.expectedCode {
+				t.Errorf("got %d, want %d: %s", rec.Code, tc.expectedCode, rec.Body)
+			}
+		})
+	}
+}
+
+func TestServer_AuthenticateAPIKey(t *testing.T) {
+	s, st, _ := newTestServer(t)
+	cookie := sessionCookie(t, s, testUser(t, st, "+79817654321", model.RoleAdmin))
+	withKey := func(method, path, key string) int {
+		req := httptest.NewRequest(method, path, nil)
+		req.Header.Set("Authorization", "Bearer "+key)
+		rec := httptest.NewRecorder()
+		s.ServeHTTP(rec, req)
+		return rec.Code
+	}
+	decodeKey := func(rec *httptest.ResponseRecorder) *model.APIKey {
+		k := &model.APIKey{}
+		if err := json.NewDecoder(rec.Body).Decode(k); err != nil {
+			t.Fatal(err)
+		}
+		return k
+	}
+
+	rec := serve(s, http.MethodPost, "/apikeys", map[string]interface{}{"name": "channel manager", "role": model.RoleAdmin}, cookie)
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("create: got %d: %s", rec.Code, rec.Body)
+	}
+	k := decodeKey(rec)
+	if !strings.HasPrefix(k.Key, model.APIKeyPrefix) {
+		t.Fatalf("got key %q", k.Key)
+	}
+	if code := withKey(http.MethodGet, "/apikeys", k.Key); code != http.StatusOK {
+		t.Errorf("valid key: got %d, want %d", code, http.StatusOK)
+	}
+	if code := withKey(http.MethodGet, "/apikeys", model.APIKeyPrefix+"unknown"); code != http.StatusUnauthorized {
+		t.Errorf("unknown key: got %d, want %d", code, http.StatusUnauthorized)
+	}
+
+	rec = serve(s, http.MethodPost, fmt.Sprintf("/apikeys/%d/rotate", k.ID), nil, cookie)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("rotate: got %d: %s", rec.Code, rec.Body)
+	}
+	rotated := decodeKey(rec)
+	if code := withKey(http.MethodGet, "/apikeys", k.Key); code != http.StatusUnauthorized {
+		t.Errorf("rotated key: got %d, want %d", code, http.StatusUnauthorized)
+	}
+	if code := withKey(http.MethodGet, "/apikeys", rotated.Key); code != http.StatusOK {
+		t.Errorf("new key: got %d, want %d", code, http.StatusOK)
+	}
+
+	rec = serve(s, http.MethodDelete, fmt.Sprintf("/apikeys/%d", k.ID), nil, cookie)
+	if rec.Code >= http.StatusBadRequest {
+		t.Fatalf("revoke: got %d: %s", rec.Code, rec.Body)
+	}
+	if code := withKey(http.MethodGet, "/apikeys", rotated.Key); code != http.StatusUnauthorized {
+		t.Errorf("revoked key: got %d, want %d", code, http.StatusUnauthorized)
+	}
+}
+
+func TestServer_HandlePreflight(t *testing.T) {
+	s, _, _ := newTestServer(t)
+	s.cors = &CORSConfig{
+		Origins:        []CORSOrigin{{Origin: "http://localhost:3000", AllowCredentials: true}},
+		AllowedHeaders: []string{"Content-Type", "Authorization"},
+		MaxAge:         Duration{Duration: 10 * time.Minute},
+	}
+
+	testCases := []struct {
+		name         string
+		origin       string
+		path         string
+		method       string
+		expectedCode int
+	}{
+		{
+			name:         "existing route",
+			origin:       "http://localhost:3000",
+			path:         "/hotels",
+			method:       http.MethodGet,
+			expectedCode: http.StatusNoContent,
+		},
+		{
+			name:         "unknown route",
+			origin:       "http://localhost:3000",
+			path:         "/unknown",
+			method:       http.MethodGet,
+			expectedCode: http.StatusNotFound,
+		},
+		{
+			name:         "unknown method",
+			origin:       "http://localhost:3000",
+			path:         "/hotels",
+			method:       http.MethodDelete,
+			expectedCode: http.StatusNotFound,
+		},
+		{
+			name:         "other origin",
+			origin:       "http://localhost:4000",
+			path:         "/hotels",
+			method:       http.MethodGet,
+			expectedCode: http.StatusForbidden,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodOptions, tc.path, nil)
+			req.Header.Set("Origin", tc.origin)
+			req.Header.Set("Access-Control-Request-Method", tc.method)
+			rec := httptest.NewRecorder()
+			s.ServeHTTP(rec, req)
+			if rec.Code != tc.expectedCode {
+				t.Fatalf("got %d, want %d", rec.Code, tc.expectedCode)
+			}
+			if tc.expectedCode != http.StatusNoContent {
+				if origin := rec.Header().Get("Access-Control-Allow-Origin"); origin != "" {
+					t.Errorf("refused preflight allows origin %q", origin)
+				}
+				return
+			}
+			expectedHeaders := map[string]string{
+				"Access-Control-Allow-Origin":      tc.origin,
+				"Access-Control-Allow-Credentials": "true",
+				"Access-Control-Allow-Methods":     tc.method,
+				"Access-Control-Allow-Headers":     "Content-Type, Authorization",
+				"Access-Control-Max-Age":           "600",
+			}
+			for name, expected := range expectedHeaders {
+				if got := rec.Header().Get(name); got != expected {
+					t.Errorf("%s: got %q, want %q", name, got, expected)
+				}
+			}
+		})
+	}
+}
+
+func TestMatchOrigin(t *testing.T) {
+	testCases := []struct {
+		pattern string
+		origin  string
+		want    bool
+	}{
+		{pattern: "https://hotelservice.ru", origin: "https://hotelservice.ru", want: true},
+		{pattern: "https://hotelservice.ru", origin: "http://hotelservice.ru", want: false},
+		{pattern: "https://hotelservice.ru", origin: "https://hotelservice.ru:8443", want: false},
+		{pattern: "https://*.example.com", origin: "https://a.example.com", want: true},
+		{pattern: "https://*.example.com", origin: "https://a.b.example.com", want: true},
+		{pattern: "https://*.example.com", origin: "https://example.com", want: false},
+		{pattern: "https://*.example.com", origin: "https://.example.com", want: false},
+		{pattern: "https://*.example.com", origin: "https://a.example.com:8443", want: false},
+		{pattern: "https://*.example.com:8443", origin: "https://a.example.com:8443", want: true},
+		{pattern: "https://*.example.com:8443", origin: "https://a.example.com:9443", want: false},
+		{pattern: "https://*.example.com", origin: "https://evil.com:1.example.com", want: false},
+		{pattern: "https://*.example.com", origin: "https://evil.com/.example.com", want: false},
+		{pattern: "https://*.example.com", origin: "https://a.example.com.evil.com", want: false},
+		{pattern: "https://*.example.com", origin: "http://a.example.com", want: false},
+		{pattern: "https://*.example.com", origin: "", want: false},
+	}
+
+	for _, tc := range testCases {
+		if got := matchOrigin(tc.pattern, tc.origin); got != tc.want {
+			t.Errorf("matchOrigin(%q, %q): got %v, want %v", tc.pattern, tc.origin, got, tc.want)
+		}
+	}
+}
+
+func TestServer_HandleSessionCreate(t *testing.T) {
+	s, st, smsSender := newTestServer(t)
+	u := testUser(t, st, "+79811234567", model.RoleGuest)
+
+	rec := serve(s, http.MethodPost, "/sessions/otp", map[string]string{"phone_number": u.PhoneNumber}, nil)
+	if rec.Code != http.StatusAccepted {
+		t.Fatalf("otp: got %d: %s", rec.Code, rec.Body)
+	}
+	code := regexp.MustCompile(`\d{6}`).FindString(smsSender.messages[u.PhoneNumber])
+	if code == "" {
+		t.Fatalf("no code in %q", smsSender.messages[u.PhoneNumber])
+	}
+
+	rec = serve(s, http.MethodPost, "/sessions", map[string]string{"phone_number": u.PhoneNumber, "code": "wrong"}, nil)
+	if rec.Code != http.StatusUnauthorized {
+		t.Errorf("wrong code: got %d, want %d", rec.Code, http.StatusUnauthorized)
+	}
+
+	rec = serve(s, http.MethodPost, "/sessions", map[string]string{"phone_number": u.PhoneNumber, "code": code}, nil)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("login: got %d: %s", rec.Code, rec.Body)
+	}
+	cookies := rec.Result().Cookies()
+	if len(cookies) == 0 {
+		t.Fatal("no session cookie")
+	}
+
+	rec = serve(s, http.MethodGet, "/private/whoami", nil, cookies[0])
+	if rec.Code != http.StatusOK {
+		t.Errorf("whoami: got %d: %s", rec.Code, rec.Body)
+	}
+
+	rec = serve(s, http.MethodPost, "/sessions", map[string]string{"phone_number": u.PhoneNumber, "code": code}, nil)
+	if rec.Code != http.StatusUnauthorized {
+		t.Errorf("reused code: got %d, want %d", rec.Code, http.StatusUnauthorized)
+	}
+}
+
+func TestServer_HandleSessionCreate_NationalNumber(t *testing.T) {
+	s, st, smsSender := newTestServer(t)
+	u := testUser(t, st, "+79811234567", model.RoleGuest)
+	typed := "8 (981) 123-45-67"
+
+	rec := serve(s, http.MethodPost, "/sessions/otp", map[string]string{"phone_number": typed}, nil)
+	if rec.Code != http.StatusAccepted {
+		t.Fatalf("otp: got %d: %s", rec.Code, rec.Body)
+	}
+	code := regexp.MustCompile(`\d{6}`).FindString(smsSender.messages[u.PhoneNumber])
+	if code == "" {
+		t.Fatalf("no code sent to %s", u.PhoneNumber)
+	}
+
+	rec = serve(s, http.MethodPost, "/sessions", map[string]string{"phone_number": typed, "code": code}, nil)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("login: got %d: %s", rec.Code, rec.Body)
+	}
+}
+
+func TestServer_HandleSessionCreate_Limits(t *testing.T) {
+	s, st, smsSender := newTestServer(t)
+	u := testUser(t, st, "+79811234567", model.RoleGuest)
+	login := func(code string) int {
+		return serve(s, http.MethodPost, "/sessions", map[string]string{"phone_number": u.PhoneNumber, "code": code}, nil).Code
+	}
+
+	serve(s, http.MethodPost, "/sessions/otp", map[string]string{"phone_number": u.PhoneNumber}, nil)
+	sent := smsSender.messages[u.PhoneNumber]
+	smsSender.messages[u.PhoneNumber] = ""
+	rec := serve(s, http.MethodPost, "/sessions/otp", map[string]string{"phone_number": u.PhoneNumber}, nil)
+	if rec.Code != http.StatusAccepted || smsSender.messages[u.PhoneNumber] != "" {
+		t.Errorf("resent within the interval: got %d, message %q", rec.Code, smsSender.messages[u.PhoneNumber])
+	}
+	code := regexp.MustCompile(`\d{6}`).FindString(sent)
+
+	for i := 0; i < otpMaxAttempts; i++ {
+		if got := login("wrong"); got != http.StatusUnauthorized {
+			t.Fatalf("attempt %d: got %d, want %d", i, got, http.StatusUnauthorized)
+		}
+	}
+	if got := login(code); got != http.StatusTooManyRequests {
+		t.Errorf("code after too many attempts: got %d, want %d", got, http.StatusTooManyRequests)
+	}
+
+	// A new code doesn't reset the failures of the phone number.
+	now := time.Now()
+	if err := st.OTP().DeleteByPhoneNumber(context.Background(), u.PhoneNumber, now.Add(time.Second)); err != nil {
+		t.Fatal(err)
+	}
+	for _, o := range []*model.OTP{
+		{PhoneNumber: u.PhoneNumber, CodeHash: "old", Attempts: otpMaxFailures - 1, ExpiresAt: now, CreatedAt: now.Add(-10 * time.Minute)},
+		{PhoneNumber: u.PhoneNumber, CodeHash: hashOTPCode(u.PhoneNumber, "123456"), ExpiresAt: now.Add(otpTTL), CreatedAt: now.Add(-2 * time.Minute)},
+	} {
+		if err := st.OTP().Create(context.Background(), o); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if got := login("wrong"); got != http.StatusUnauthorized {
+		t.Fatalf("last failure: got %d, want %d", got, http.StatusUnauthorized)
+	}
+	if got := login("123456"); got != http.StatusTooManyRequests {
+		t.Errorf("new code after too many failures: got %d, want %d", got, http.StatusTooManyRequests)
+	}
+}
+
+func TestServer_ClientKey_TokenMode(t *testing.T) {
+	s, st := newTokenTestServer(t)
+	u := testUser(t, st, "+79811234567", model.RoleGuest)
+	pair, err := s.issueTokens(context.Background(), u)
+	if err != nil {
+		t.Fatal(err)
+	}
+	userKey := "user:" + strconv.Itoa(u.ID)
+
+	testCases := []struct {
+		name     string
+		token    string
+		cookie   *http.Cookie
+		expected string
+	}{
+		{name: "access token", token: pair.AccessToken, expected: userKey},
+		{name: "session cookie", cookie: sessionCookie(t, s, u), expected: userKey},
+		{name: "invalid token with a session", token: "invalid", cookie: sessionCookie(t, s, u), expected: "ip:192.0.2.1"},
+		{name: "not authenticated", expected: "ip:192.0.2.1"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			if tc.token != "" {
+				req.Header.Set("Authorization", "Bearer "+tc.token)
+			}
+			if tc.cookie != nil {
+				req.AddCookie(tc.cookie)
+			}
+			if got := s.clientKey(req); got != tc.expected {
+				t.Errorf("got %q, want %q", got, tc.expected)
+			}
+		})
+	}
+}
+
+func TestServer_HandleTokenRefresh(t *testing.T) {
+	s, st := newTokenTestServer(t)
+	u := testUser(t, st, "+79811234567", model.RoleGuest)
+	refresh := func(refreshToken string) (int, *tokenPair) {
+		rec := serve(s, http.MethodPost, "/tokens/refresh", map[string]string{"refresh_token": refreshToken}, nil)
+		pair := &tokenPair{}
+		if rec.Code == http.StatusOK {
+			if err := json.NewDecoder(rec.Body).Decode(pair); err != nil {
+				t.Fatal(err)
+			}
+		}
+		return rec.Code, pair
+	}
+
+	first, err := s.issueTokens(context.Background(), u)
+	if err != nil {
+		t.Fatal(err)
+	}
+	code, second := refresh(first.RefreshToken)
+	if code != http.StatusOK || second.RefreshToken == "" || second.RefreshToken == first.RefreshToken {
+		t.Fatalf("refresh: got %d, %+v", code, second)
+	}
+	code, third := refresh(second.RefreshToken)
+	if code != http.StatusOK {
+		t.Fatalf("second refresh: got %d", code)
+	}
+
+	// Presenting a used token revokes the tokens issued after it too.
+	if code, _ := refresh(first.RefreshToken); code != http.StatusUnauthorized {
+		t.Errorf("reused token: got %d, want %d", code, http.StatusUnauthorized)
+	}
+	if code, _ := refresh(third.RefreshToken); code != http.StatusUnauthorized {
+		t.Errorf("latest token after reuse: got %d, want %d", code, http.StatusUnauthorized)
+	}
+	if code, _ := refresh("unknown"); code != http.StatusUnauthorized {
+		t.Errorf("unknown token: got %d, want %d", code, http.StatusUnauthorized)
+	}
+}
+
+func TestServer_HandleSessionDelete_TokenMode(t *testing.T) {
+	s, st := newTokenTestServer(t)
+	u := testUser(t, st, "+79811234567", model.RoleGuest)
+	pair, err := s.issueTokens(context.Background(), u)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	testCases := []struct {
+		name          string
+		body          interface{}
+		cookie        *http.Cookie
+		clearsSession bool
+	}{
+		{name: "refresh token", body: map[string]string{"refresh_token": pair.RefreshToken}},
+		{name: "session cookie", cookie: sessionCookie(t, s, u), clearsSession: true},
+		{name: "both", body: map[string]string{"refresh_token": "unknown"}, cookie: sessionCookie(t, s, u), clearsSession: true},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			rec := serve(s, http.MethodDelete, "/sessions", tc.body, tc.cookie)
+			if rec.Code != http.StatusNoContent {
+				t.Fatalf("got %d, want %d: %s", rec.Code, http.StatusNoContent, rec.Body)
+			}
+			cleared := false
+			for _, c := range rec.Result().Cookies() {
+				cleared = cleared || c.Name == sessionName && c.MaxAge < 0
+			}
+			if cleared != tc.clearsSession {
+				t.Errorf("session cleared: got %t, want %t", cleared, tc.clearsSession)
+			}
+		})
+	}
+
+	rec := serve(s, http.MethodPost, "/tokens/refresh", map[string]string{"refresh_token": pair.RefreshToken}, nil)
+	if rec.Code != http.StatusUnauthorized {
+		t.Errorf("refresh after logout: got %d, want %d", rec.Code, http.StatusUnauthorized)
+	}
+}
+
+func TestServer_HandleHotelGet(t *testing.T) {
+	s, st, _ := newTestServer(t)
+	a := testApartment(t, st)
+
+	testCases := []struct {
+		name         string
+		path         string
+		expectedCode int
+	}{
+		{
+			name:         "existing",
+			path:         fmt.Sprintf("/hotels/%d", a.Hotel.ID),
+			expectedCode: http.StatusOK,
+		},
+		{
+			name:         "missing",
+			path:         fmt.Sprintf("/hotels/%d", a.Hotel.ID+1),
+			expectedCode: http.StatusNotFound,
+		},
+		{
+			name:         "unknown currency",
+			path:         fmt.Sprintf("/hotels/%d?currency=USD", a.Hotel.ID),
+			expectedCode: http.StatusUnprocessableEntity,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			rec := serve(s, http.MethodGet, tc.path, nil, nil)
+			if rec.Code != tc.expectedCode {
+				t.Errorf("got %d, want %d: %s", rec.Code, tc.expectedCode, rec.Body)
+			}
+		})
+	}
+}
+
+func TestServer_HandleApartmentsByHotelIDGet(t *testing.T) {
+	s, st, _ := newTestServer(t)
+	a := testApartment(t, st)
+
+	testCases := []struct {
+		name         string
+		path         string
+		expectedCode int
+	}{
+		{
+			name:         "existing",
+			path:         fmt.Sprintf("/hotel/%d/apartments", a.Hotel.ID),
+			expectedCode: http.StatusOK,
+		},
+		{
+			name:         "non-numeric id",
+			path:         "/hotel/abc/apartments",
+			expectedCode: http.StatusBadRequest,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			rec := serve(s, http.MethodGet, tc.path, nil, nil)
+			if rec.Code != tc.expectedCode {
+				t.Errorf("got %d, want %d: %s", rec.Code, tc.expectedCode, rec.Body)
+			}
+		})
+	}
+}
+
+func TestServer_HandleHotelsGet(t *testing.T) {
+	s, st, _ := newTestServer(t)
+	testApartment(t, st)
+	testApartment(t, st)
+
+	type response struct {
+		Hotels     []model.Hotel `json:"hotels"`
+		NextCursor string        `json:"next_cursor"`
+	}
+	seen := map[int]bool{}
+	path := "/hotels?limit=1"
+	for page := 0; page < 2; page++ {
+		rec := serve(s, http.MethodGet, path, nil, nil)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("page %d: got %d: %s", page, rec.Code, rec.Body)
+		}
+		resp := &response{}
+		if err := json.NewDecoder(rec.Body).Decode(resp); err != nil {
+			t.Fatal(err)
+		}
+		if len(resp.Hotels) != 1 || seen[resp.Hotels[0].ID] {
+			t.Fatalf("page %d: unexpected hotels %+v", page, resp.Hotels)
+		}
+		seen[resp.Hotels[0].ID] = true
+		if page == 0 && resp.NextCursor == "" || page == 1 && resp.NextCursor != "" {
+			t.Fatalf("page %d: unexpected next cursor %q", page, resp.NextCursor)
+		}
+		path = "/hotels?limit=1&cursor=" + resp.NextCursor
+	}
+
+	rec := serve(s, http.MethodGet, "/hotels?sort=unknown", nil, nil)
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("unknown sort: got %d, want %d", rec.Code, http.StatusBadRequest)
+	}
+}
+
+func TestServer_HandleHotelsGet_InvalidQuery(t *testing.T) {
+	s, _, _ := newTestServer(t)
+
+	testCases := []struct {
+		name     string
+		query    string
+		expected map[string]string
+	}{
+		{
+			name:     "numbers",
+			query:    "min_stars=x&bed_count=1.5",
+			expected: map[string]string{"min_stars": errInvalidNumber.Error(), "bed_count": errInvalidNumber.Error()},
+		},
+		{
+			name:     "prices",
+			query:    "min_price=cheap&max_price=1.001",
+			expected: map[string]string{"min_price": model.ErrInvalidAmount.Error(), "max_price": model.ErrInvalidAmount.Error()},
+		},
+		{
+			name:     "stay",
+			query:    "arrival=tomorrow",
+			expected: map[string]string{"arrival": errInvalidDate.Error(), "departure": errIncompleteStay.Error()},
+		},
+		{
+			name:     "limit",
+			query:    "limit=x",
+			expected: map[string]string{"limit": errInvalidLimit.Error()},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			rec := serve(s, http.MethodGet, "/hotels?"+tc.query, nil, nil)
+			if rec.Code != http.StatusUnprocessableEntity {
+				t.Fatalf("got %d, want %d: %s", rec.Code, http.StatusUnprocessableEntity, rec.Body)
+			}
+			resp := &struct {
+				Errors map[string]string `json:"errors"`
+			}{}
+			if err := json.NewDecoder(rec.Body).Decode(resp); err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(resp.Errors, tc.expected) {
+				t.Errorf("got errors %v, want %v", resp.Errors, tc.expected)
+			}
+		})
+	}
+}
+
+func TestServer_HandleHotelsGet_SortByPrice(t *testing.T) {
+	s, st, _ := newTestServer(t)
+	if err := s.rates.Set(exchange.Rates{Base: "RUB", Rates: map[string]string{"USD": "0.0125"}}); err != nil {
+		t.Fatal(err)
+	}
+	rub := testApartment(t, st)
+	// 13 USD are 1040 RUB, more than the 1000 RUB of the other hotel
+	// although the amount is smaller.
+	usd := testApartment(t, st)
+	usd.Hotel.Currency = "USD"
+	if err := st.Hotel().Update(context.Background(), usd.Hotel); err != nil {
+		t.Fatal(err)
+	}
+	cheap := &model.Apartment{
+		Name:           "Single",
+		Hotel:          usd.Hotel,
+		ApartmentClass: usd.ApartmentClass,
+		BedCount:       1,
+		Price:          model.Money{Amount: 1300, Currency: "USD"},
+	}
+	if err := st.Apartment().Create(context.Background(), cheap); err != nil {
+		t.Fatal(err)
+	}
+	empty := &model.Hotel{
+		Name:               "Empty",
+		Address:            &model.Address{Country: "Russia", City: "Moscow", Street: "Arbat", House: "1"},
+		StarsCount:         3,
+		CancellationPolicy: &model.CancellationPolicy{},
+		Currency:           model.DefaultCurrency,
+	}
+	if err := st.Address().Create(context.Background(), empty.Address); err != nil {
+		t.Fatal(err)
+	}
+	if err := st.Hotel().Create(context.Background(), empty); err != nil {
+		t.Fatal(err)
+	}
+
+	testCases := []struct {
+		sort string
+		want []int
+	}{
+		{sort: "price", want: []int{rub.Hotel.ID, usd.Hotel.ID, empty.ID}},
+		{sort: "-price", want: []int{usd.Hotel.ID, rub.Hotel.ID, empty.ID}},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.sort, func(t *testing.T) {
+			got := []int{}
+			path := "/hotels?limit=2&sort=" + tc.sort
+			for path != "" {
+				rec := serve(s, http.MethodGet, path, nil, nil)
+				if rec.Code != http.StatusOK {
+					t.Fatalf("got %d: %s", rec.Code, rec.Body)
+				}
+				resp := &struct {
+					Hotels     []model.Hotel `json:"hotels"`
+					NextCursor string        `json:"next_cursor"`
+				}{}
+				if err := json.NewDecoder(rec.Body).Decode(resp); err != nil {
+					t.Fatal(err)
+				}
+				for _, h := range resp.Hotels {
+					got = append(got, h.ID)
+				}
+				path = ""
+				if resp.NextCursor != "" {
+					path = "/hotels?limit=2&sort=" + tc.sort + "&cursor=" + resp.NextCursor
+				}
+			}
+			if fmt.Sprint(got) != fmt.Sprint(tc.want) {
+				t.Errorf("got %v, want %v", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestServer_HandleHotelsSearch(t *testing.T) {
+	s, st, _ := newTestServer(t)
+	a := testApartment(t, st)
+	a.Hotel.Description = `Viking <script>alert("x")</script> & spa`
+	if err := st.Hotel().Update(context.Background(), a.Hotel); err != nil {
+		t.Fatal(err)
+	}
+
+	rec := serve(s, http.MethodGet, "/search?q=viking", nil, nil)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("got %d: %s", rec.Code, rec.Body)
+	}
+	resp := &struct {
+		Items []model.HotelSearchResult `json:"items"`
+	}{}
+	if err := json.NewDecoder(rec.Body).Decode(resp); err != nil {
+		t.Fatal(err)
+	}
+	if len(resp.Items) != 1 {
+		t.Fatalf("got %d results, want 1", len(resp.Items))
+	}
+	want := `<mark>Viking</mark> &lt;script&gt;alert(&#34;x&#34;)&lt;/script&gt; &amp; spa`
+	if got := resp.Items[0].Snippet; got != want {
+		t.Errorf("got snippet %q, want %q", got, want)
+	}
+
+	rec = serve(s, http.MethodGet, "/search?q=", nil, nil)
+	if rec.Code != http.StatusUnprocessableEntity {
+		t.Errorf("empty query: got %d, want %d", rec.Code, http.StatusUnprocessableEntity)
+	}
+}
+
+func TestServer_HandleHotelCreate(t *testing.T) {
+	s, st, _ := newTestServer(t)
+	guest := testUser(t, st, "+79811234567", model.RoleGuest)
+	admin := testUser(t, st, "+79817654321", model.RoleAdmin)
+	valid := map[string]interface{}{
+		"name":        "Viking",
+		"stars_count": 4,
+		"country":     "Russia",
+		"city":        "Moscow",
+		"street":      "Tverskaya",
+		"house":       "1",
+	}
+
+	testCases := []struct {
+		name         string
+		user         *model.User
+		payload      interface{}
+		expectedCode int
+	}{
+		{
+			name:         "not authenticated",
+			payload:      valid,
+			expectedCode: http.StatusUnauthorized,
+		},
+		{
+			name:         "guest",
+			user:         guest,
+			payload:      valid,
+			expectedCode: http.StatusForbidden,
+		},
+		{
+			name:         "admin",
+			user:         admin,
+			payload:      valid,
+			expectedCode: http.StatusCreated,
+		},
+		{
+			name: "too many stars",
+			user: admin,
+			payload: map[string]interface{}{
+				"name":        "Viking",
+				"stars_count": 6,
+				"country":     "Russia",
+				"city":        "Moscow",
+				"street":      "Tverskaya",
+				"house":       "1",
+			},
+			expectedCode: http.StatusUnprocessableEntity,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			var cookie *http.Cookie
+			if tc.user != nil {
+				cookie = sessionCookie(t, s, tc.user)
+			}
+			rec := serve(s, http.MethodPost, "/hotels", tc.payload, cookie)
+			if rec.Code != tc.expectedCode {
+				t.Errorf("got %d, want %d: %s", rec.Code, tc.expectedCode, rec.Body)
+			}
+		})
+	}
+}
+
+func TestServer_HandleHotelUpdate(t *testing.T) {
+	s, st, _ := newTestServer(t)
+	a := testApartment(t, st)
+	cookie := sessionCookie(t, s, testUser(t, st, "+79817654321", model.RoleAdmin))
+
+	payload := map[string]interface{}{
+		"name":        "Viking Plaza",
+		"stars_count": 5,
+		"country":     "Russia",
+		"city":        "Saint Petersburg",
+		"street":      "Nevsky",
+		"house":       "2",
+	}
+	rec := serve(s, http.MethodPut, fmt.Sprintf("/hotels/%d", a.Hotel.ID), payload, cookie)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("got %d, want %d: %s", rec.Code, http.StatusOK, rec.Body)
+	}
+	h, err := st.Hotel().Find(context.Background(), a.Hotel.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if h.Name != "Viking Plaza" || h.Address.ID != a.Hotel.Address.ID || h.Address.City != "Saint Petersburg" {
+		t.Errorf("hotel was not saved with its address: %+v %+v", h, h.Address)
+	}
+
+	rec = serve(s, http.MethodPut, "/hotels/100", payload, cookie)
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("unknown hotel: got %d, want %d", rec.Code, http.StatusNotFound)
+	}
+
+	payload["currency"] = "USD"
+	rec = serve(s, http.MethodPut, fmt.Sprintf("/hotels/%d", a.Hotel.ID), payload, cookie)
+	if rec.Code != http.StatusConflict {
+		t.Fatalf("currency change: got %d, want %d: %s", rec.Code, http.StatusConflict, rec.Body)
+	}
+	if code := errorCodeOf(t, rec); code != codeCurrencyInUse {
+		t.Errorf("currency change: got error code %q, want %q", code, codeCurrencyInUse)
+	}
+}
+
+func TestServer_HandleTransactCreate(t *testing.T) {
+	s, st, _ := newTestServer(t)
+	a := testApartment(t, st)
+	cookie := sessionCookie(t, s, testUser(t, st, "+79811234567", model.RoleGuest))
+
+	testCases := []struct {
+		name         string
+		arrival      string
+		departure    string
+		expectedCode int
+		expectedErr  string
+	}{
+		{
+			name:         "valid",
+			arrival:      day(10),
+			departure:    day(15),
+			expectedCode: http.StatusOK,
+		},
+		{
+			name:         "overlapping",
+			arrival:      day(12),
+			departure:    day(17),
+			expectedCode: http.StatusConflict,
+			expectedErr:  "apartment_unavailable",
+		},
+		{
+			name:         "arriving on departure day",
+			arrival:      day(15),
+			departure:    day(17),
+			expectedCode: http.StatusOK,
+		},
+		{
+			name:         "in the past",
+			arrival:      day(-3),
+			departure:    day(-1),
+			expectedCode: http.StatusUnprocessableEntity,
+			expectedErr:  "validation_failed",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			payload := map[string]interface{}{
+				"apartment_id":   a.ID,
+				"date_arrival":   tc.arrival,
+				"date_departure": tc.departure,
+			}
+			rec := serve(s, http.MethodPost, "/transacts", payload, cookie)
+			if rec.Code != tc.expectedCode {
+				t.Fatalf("got %d, want %d: %s", rec.Code, tc.expectedCode, rec.Body)
+			}
+			if tc.expectedErr != "" {
+				if code := errorCodeOf(t, rec); code != tc.expectedErr {
+					t.Errorf("got error code %q, want %q", code, tc.expectedErr)
+				}
+			}
+		})
+	}
+}
+
+func TestServer_HandleTransactUpdate_StayBegun(t *testing.T) {
+	s, st, _ := newTestServer(t)
+	a := testApartment(t, st)
+	u := testUser(t, st, "+79811234567", model.RoleGuest)
+	cookie := sessionCookie(t, s, u)
+	arrival, _ := time.Parse(dateLayout, day(-1))
+	tr := &model.Transact{
+		Apartment:     a,
+		User:          u,
+		Price:         model.Money{Amount: 300000, Currency: model.DefaultCurrency},
+		DateArrival:   arrival,
+		DateDeparture: arrival.AddDate(0, 0, 3),
+	}
+	if err := st.Transact().Create(context.Background(), tr); err != nil {
+		t.Fatal(err)
+	}
+	path := fmt.Sprintf("/transacts/%d", tr.ID)
+
+	testCases := []struct {
+		name         string
+		payload      map[string]interface{}
+		expectedCode int
+	}{
+		{
+			name:         "extending the stay",
+			payload:      map[string]interface{}{"date_departure": day(4)},
+			expectedCode: http.StatusOK,
+		},
+		{
+			name:         "moving the arrival to the past",
+			payload:      map[string]interface{}{"date_arrival": day(-2)},
+			expectedCode: http.StatusUnprocessableEntity,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			rec := serve(s, http.MethodPatch, path, tc.payload, cookie)
+			if rec.Code != tc.expectedCode {
+				t.Fatalf("got %d, want %d: %s", rec.Code, tc.expectedCode, rec.Body)
+			}
+		})
+	}
+}
+
+func TestServer_HandleTransactCancel(t *testing.T) {
+	s, st, _ := newTestServer(t)
+	a := testApartment(t, st)
+	owner := testUser(t, st, "+79811234567", model.RoleGuest)
+	other := testUser(t, st, "+79817654321", model.RoleGuest)
+	arrival, _ := time.Parse(dateLayout, day(1))
+	tr := &model.Transact{
+		Apartment:     a,
+		User:          owner,
+		Price:         model.Money{Amount: 200000, Currency: model.DefaultCurrency},
+		DateArrival:   arrival,
+		DateDeparture: arrival.AddDate(0, 0, 2),
+	}
+	if err := st.Transact().Create(context.Background(), tr); err != nil {
+		t.Fatal(err)
+	}
+	// A policy tightened after booking doesn't apply to the stay.
+	a.Hotel.CancellationPolicy = &model.CancellationPolicy{FreeDays: 0, PenaltyPercent: 100}
+	if err := st.Hotel().Update(context.Background(), a.Hotel); err != nil {
+		t.Fatal(err)
+	}
+	path := fmt.Sprintf("/transacts/%d/cancel", tr.ID)
+
+	rec := serve(s, http.MethodPost, path, nil, sessionCookie(t, s, other))
+	if rec.Code != http.StatusForbidden {
+		t.Errorf("other guest: got %d, want %d", rec.Code, http.StatusForbidden)
+	}
+
+	rec = serve(s, http.MethodPost, path, nil, sessionCookie(t, s, owner))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("owner: got %d: %s", rec.Code, rec.Body)
+	}
+	resp := &struct {
+		Item struct {
+			Refund *model.Money `json:"refund"`
+		} `json:"item"`
+	}{}
+	if err := json.NewDecoder(rec.Body).Decode(resp); err != nil {
+		t.Fatal(err)
+	}
+	// The free cancellation period ended three days before arrival, so half
+	// of the price is kept.
+	if resp.Item.Refund == nil || resp.Item.Refund.Amount != 100000 {
+		t.Errorf("got refund %+v, want 1000.00", resp.Item.Refund)
+	}
+
+	rec = serve(s, http.MethodPost, path, nil, sessionCookie(t, s, owner))
+	if rec.Code != http.StatusConflict {
+		t.Errorf("cancelled twice: got %d, want %d", rec.Code, http.StatusConflict)
+	}
+
+	available, _, err := st.Apartment().FindAvailableByHotelID(context.Background(), a.Hotel.ID, tr.DateArrival, tr.DateDeparture, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(available) != 1 {
+		t.Errorf("cancelled stay still blocks the apartment")
+	}
+}
+
+func TestServer_HandleTransactCancel_StayBegun(t *testing.T) {
+	s, st, _ := newTestServer(t)
+	a := testApartment(t, st)
+	u := testUser(t, st, "+79811234567", model.RoleGuest)
+	cookie := sessionCookie(t, s, u)
+
+	testCases := []struct {
+		name    string
+		arrival int
+	}{
+		{name: "arriving today", arrival: 0},
+		{name: "checked out", arrival: -5},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			arrival, _ := time.Parse(dateLayout, day(tc.arrival))
+			tr := &model.Transact{
+				Apartment:     a,
+				User:          u,
+				Price:         model.Money{Amount: 200000, Currency: model.DefaultCurrency},
+				DateArrival:   arrival,
+				DateDeparture: arrival.AddDate(0, 0, 2),
+			}
+			if err := st.Transact().Create(context.Background(), tr); err != nil {
+				t.Fatal(err)
+			}
+			rec := serve(s, http.MethodPost, fmt.Sprintf("/transacts/%d/cancel", tr.ID), nil, cookie)
+			if rec.Code != http.StatusConflict {
+				t.Fatalf("got %d, want %d: %s", rec.Code, http.StatusConflict, rec.Body)
+			}
+			if code := errorCodeOf(t, rec); code != codeStayBegun {
+				t.Errorf("got error code %q, want %q", code, codeStayBegun)
+			}
+		})
+	}
+}
+
+func TestServer_HandleUsersDelete(t *testing.T) {
+	s, st, _ := newTestServer(t)
+	a := testApartment(t, st)
+	booked := testUser(t, st, "+79811234567", model.RoleGuest)
+	u := testUser(t, st, "+79817654321", model.RoleGuest)
+	arrival, _ := time.Parse(dateLayout, day(10))
+	tr := &model.Transact{
+		Apartment:     a,
+		User:          booked,
+		Price:         model.Money{Amount: 200000, Currency: model.DefaultCurrency},
+		DateArrival:   arrival,
+		DateDeparture: arrival.AddDate(0, 0, 2),
+	}
+	if err := st.Transact().Create(context.Background(), tr); err != nil {
+		t.Fatal(err)
+	}
+
+	rec := serve(s, http.MethodDelete, "/users/"+booked.PhoneNumber, nil, sessionCookie(t, s, booked))
+	if rec.Code != http.StatusConflict {
+		t.Fatalf("with bookings: got %d, want %d: %s", rec.Code, http.StatusConflict, rec.Body)
+	}
+	if code := errorCodeOf(t, rec); code != store.ErrUserHasBookings.Code {
+		t.Errorf("with bookings: got error code %q, want %q", code, store.ErrUserHasBookings.Code)
+	}
+
+	rec = serve(s, http.MethodDelete, "/users/"+u.PhoneNumber, nil, sessionCookie(t, s, u))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("without bookings: got %d, want %d: %s", rec.Code, http.StatusOK, rec.Body)
+	}
+	if _, err := st.User().Find(context.Background(), u.ID); err != store.ErrRecordNotFound {
+		t.Errorf("deleted user: got %v, want %v", err, store.ErrRecordNotFound)
+	}
+}
+
+func TestServer_RespondError_Timeout(t *testing.T) {
+	s, _, _ := newTestServer(t)
+	err := &store.Error{Kind: store.KindTimeout, Code: "query_timeout", Message: "query timed out", Err: context.DeadlineExceeded}
+
+	req := httptest.NewRequest(http.MethodGet, "/hotels", nil)
+	rec := httptest.NewRecorder()
+	s.respondError(rec, req, err)
+	if rec.Code != http.StatusGatewayTimeout || errorCodeOf(t, rec) != "query_timeout" {
+		t.Errorf("query timeout: got %d: %s", rec.Code, rec.Body)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), -time.Second)
+	defer cancel()
+	rec = httptest.NewRecorder()
+	s.respondError(rec, req.WithContext(ctx), err)
+	if rec.Code != http.StatusServiceUnavailable || errorCodeOf(t, rec) != "request_timeout" {
+		t.Errorf("request timeout: got %d: %s", rec.Code, rec.Body)
+	}
+}
+
+func TestServer_Probes(t *testing.T) {
+	s, _, _ := newTestServer(t)
+	health := s.health.(*testHealth)
+	logs := &bytes.Buffer{}
+	s.logger.SetOutput(logs)
+
+	type response struct {
+		Status        string            `json:"status"`
+		Checks        map[string]string `json:"checks"`
+		Commit        string            `json:"commit"`
+		SchemaVersion *int              `json:"schema_version"`
+	}
+	get := func(path string) (int, *response) {
+		rec := serve(s, http.MethodGet, path, nil, nil)
+		resp := &response{}
+		if err := json.NewDecoder(rec.Body).Decode(resp); err != nil {
+			t.Fatal(err)
+		}
+		return rec.Code, resp
+	}
+
+	if code, resp := get("/healthz"); code != http.StatusOK || resp.Status != "ok" {
+		t.Errorf("healthz: got %d %+v", code, resp)
+	}
+	if code, resp := get("/readyz"); code != http.StatusOK || resp.Status != "ready" {
+		t.Errorf("readyz: got %d %+v", code, resp)
+	}
+	if code, resp := get("/version"); code != http.StatusOK || resp.Commit == "" || resp.SchemaVersion == nil || *resp.SchemaVersion != 4 {
+		t.Errorf("version: got %d %+v", code, resp)
+	}
+	if logs.Len() != 0 {
+		t.Errorf("probes were logged: %s", logs)
+	}
+
+	health.applied = 3
+	if code, resp := get("/readyz"); code != http.StatusServiceUnavailable || resp.Checks["migrations"] != "schema version 3, want 4" {
+		t.Errorf("readyz behind: got %d %+v", code, resp)
+	}
+
+	health.pingErr = errors.New("connection refused")
+	if code, resp := get("/readyz"); code != http.StatusServiceUnavailable || resp.Checks["database"] != "unreachable" {
+		t.Errorf("readyz without database: got %d %+v", code, resp)
+	}
+	if code, resp := get("/version"); code != http.StatusOK || resp.SchemaVersion != nil {
+		t.Errorf("version without database: got %d %+v", code, resp)
+	}
+}
+
+func TestServer_Unrouted(t *testing.T) {
+	s, _, _ := newTestServer(t)
+	testCases := []struct {
+		name         string
+		method       string
+		path         string
+		expectedCode int
+		expectedErr  string
+	}{
+		{name: "unknown path", method: http.MethodGet, path: "/unknown", expectedCode: http.StatusNotFound, expectedErr: "not_found"},
+		{name: "unknown method", method: http.MethodDelete, path: "/hotels", expectedCode: http.StatusMethodNotAllowed, expectedErr: "method_not_allowed"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			rec := serve(s, tc.method, tc.path, nil, nil)
+			if rec.Code != tc.expectedCode {
+				t.Fatalf("got %d, want %d", rec.Code, tc.expectedCode)
+			}
+			if code := errorCodeOf(t, rec); code != tc.expectedErr {
+				t.Errorf("got error code %q, want %q", code, tc.expectedErr)
+			}
+		})
+	}
+}
+
+func TestServer_HandleMetrics(t *testing.T) {
+	s, st, _ := newTestServer(t)
+	a := testApartment(t, st)
+	cookie := sessionCookie(t, s, testUser(t, st, "+79811234567", model.RoleGuest))
+
+	payload := map[string]interface{}{
+		"apartment_id":   a.ID,
+		"date_arrival":   day(10),
+		"date_departure": day(12),
+	}
+	if rec := serve(s, http.MethodPost, "/transacts", payload, cookie); rec.Code != http.StatusOK {
+		t.Fatalf("booking: got %d: %s", rec.Code, rec.Body)
+	}
+	// One night longer, then two nights shorter.
+	for _, departure := range []string{day(13), day(11)} {
+		update := map[string]interface{}{"date_departure": departure}
+		if rec := serve(s, http.MethodPatch, "/transacts/1", update, cookie); rec.Code != http.StatusOK {
+			t.Fatalf("update: got %d: %s", rec.Code, rec.Body)
+		}
+	}
+	if rec := serve(s, http.MethodPost, "/transacts/1/cancel", nil, cookie); rec.Code != http.StatusOK {
+		t.Fatalf("cancellation: got %d: %s", rec.Code, rec.Body)
+	}
+	serve(s, http.MethodGet, "/unknown/1", nil, nil)
+	serve(s, http.MethodGet, "/unknown/2", nil, nil)
+
+	if rec := serve(s, http.MethodGet, "/metrics", nil, nil); rec.Code != http.StatusNotFound {
+		t.Errorf("metrics on the API: got %d, want %d", rec.Code, http.StatusNotFound)
+	}
+	req := httptest.NewRequest(http.MethodGet, "/metrics", nil)
+	rec := httptest.NewRecorder()
+	s.metricsHandler().ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("got %d", rec.Code)
+	}
+	if ct := rec.Header().Get("Content-Type"); !strings.HasPrefix(ct, "text/plain; version=0.0.4") {
+		t.Errorf("content type: got %q", ct)
+	}
+	body := rec.Body.String()
+	hotelID := strconv.Itoa(a.Hotel.ID)
+	for _, line := range []string{
+		"# TYPE hotelservice_http_requests_total counter",
+		`hotelservice_http_requests_total{method="POST",route="/transacts",status="200"} 1`,
+		`hotelservice_http_requests_total{method="POST",route="/transacts/{id}/cancel",status="200"} 1`,
+		`hotelservice_http_requests_total{method="GET",route="unmatched",status="404"} 3`,
+		`hotelservice_http_request_duration_seconds_bucket{method="POST",route="/transacts",le="+Inf"} 1`,
+		`hotelservice_http_request_duration_seconds_count{method="POST",route="/transacts"} 1`,
+		`hotelservice_bookings_created_total{hotel_id="` + hotelID + `"} 1`,
+		`hotelservice_bookings_cancelled_total{hotel_id="` + hotelID + `"} 1`,
+		// Refunded in full, so the revenue and the refunds cancel out.
+		`hotelservice_booking_revenue_total{hotel_id="` + hotelID + `",currency="RUB"} 3000`,
+		`hotelservice_booking_refunds_total{hotel_id="` + hotelID + `",currency="RUB"} 3000`,
+	} {
+		if !strings.Contains(body, line+"\n") {
+			t.Errorf("metrics lack %s:\n%s", line, body)
+		}
+	}
+	if strings.Contains(body, `route="/metrics"`) {
+		t.Error("metrics requests are counted")
+	}
+}
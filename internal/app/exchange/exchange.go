@@ -0,0 +1,174 @@
+// Package exchange converts money between currencies using a table of
+// exchange rates loaded locally, from a file or by an administrator.
+package exchange
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/zlyaptica/hotel_service_backend/internal/app/model"
+	"math/big"
+	"os"
+	"strings"
+	"sync"
+)
+
+var (
+	ErrUnknownCurrency = errors.New("no exchange rate for currency")
+	ErrInvalidRate     = errors.New("invalid exchange rate")
+)
+
+// Rates is the serialized form of a rate table: how many units of each
+// currency one unit of Base buys.
+type Rates struct {
+	Base  string            `json:"base"`
+	Rates map[string]string `json:"rates"`
+}
+
+// Table is a set of exchange rates safe for concurrent use. It may be
+// replaced at any time with Set.
+type Table struct {
+	mu    sync.RWMutex
+	base  string
+	rates map[string]*big.Rat
+}
+
+func NewTable() *Table {
+	return &Table{
+		rates: map[string]*big.Rat{},
+	}
+}
+
+// LoadFile reads a table from a JSON file in the Rates format.
+func LoadFile(path string) (*Table, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	rates := Rates{}
+	if err := json.Unmarshal(data, &rates); err != nil {
+		return nil, err
+	}
+	t := NewTable()
+	if err := t.Set(rates); err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+// Set replaces all rates of the table.
+func (t *Table) Set(rates Rates) error {
+	if !model.ValidCurrency(rates.Base) {
+		return model.ErrInvalidCurrency
+	}
+	parsed := map[string]*big.Rat{
+		rates.Base: big.NewRat(1, 1),
+	}
+	for currency, value := range rates.Rates {
+		if !model.ValidCurrency(currency) {
+			return model.ErrInvalidCurrency
+		}
+		rate, ok := new(big.Rat).SetString(value)
+		if !ok || rate.Sign() <= 0 {
+			return ErrInvalidRate
+		}
+		parsed[currency] = rate
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.base = rates.Base
+	t.rates = parsed
+	return nil
+}
+
+// Rates returns the current contents of the table.
+func (t *Table) Rates() Rates {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	rates := Rates{
+		Base:  t.base,
+		Rates: map[string]string{},
+	}
+	for currency, rate := range t.rates {
+		if currency != t.base {
+			rates.Rates[currency] = formatRate(rate)
+		}
+	}
+	return rates
+}
+
+// Rate returns how many units of to one unit of from buys.
+func (t *Table) Rate(from, to string) (*big.Rat, error) {
+	if from == to {
+		return big.NewRat(1, 1), nil
+	}
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	fromRate, ok := t.rates[from]
+	if !ok {
+		return nil, ErrUnknownCurrency
+	}
+	toRate, ok := t.rates[to]
+	if !ok {
+		return nil, ErrUnknownCurrency
+	}
+	return new(big.Rat).Quo(toRate, fromRate), nil
+}
+
+// MinorRate returns how many minor units of to one minor unit of from buys.
+func (t *Table) MinorRate(from, to string) (*big.Rat, error) {
+	rate, err := t.Rate(from, to)
+	if err != nil {
+		return nil, err
+	}
+	return minorRate(rate, from, to), nil
+}
+
+// Convert returns m in the currency to, rounded half away from zero to the
+// minor unit of to, together with the rate used.
+func (t *Table) Convert(m model.Money, to string) (model.Money, string, error) {
+	rate, err := t.Rate(m.Currency, to)
+	if err != nil {
+		return model.Money{}, "", err
+	}
+
+	amount := new(big.Rat).SetInt64(m.Amount)
+	amount.Mul(amount, minorRate(rate, m.Currency, to))
+	return model.Money{Amount: round(amount), Currency: to}, formatRate(rate), nil
+}
+
+// minorRate shifts the rate between major units of from and to to the one
+// between their minor units.
+func minorRate(rate *big.Rat, from, to string) *big.Rat {
+	shift := model.CurrencyExponent(to) - model.CurrencyExponent(from)
+	scale := new(big.Rat).SetInt(new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(abs(shift))), nil))
+	if shift >= 0 {
+		return scale.Mul(rate, scale)
+	}
+	return scale.Quo(rate, scale)
+}
+
+// round rounds r half away from zero to an integer.
+func round(r *big.Rat) int64 {
+	num := new(big.Int).Abs(r.Num())
+	q, rem := new(big.Int).QuoRem(num, r.Denom(), new(big.Int))
+	if rem.Mul(rem, big.NewInt(2)).Cmp(r.Denom()) >= 0 {
+		q.Add(q, big.NewInt(1))
+	}
+	if r.Sign() < 0 {
+		q.Neg(q)
+	}
+	return q.Int64()
+}
+
+func formatRate(r *big.Rat) string {
+	s := strings.TrimRight(r.FloatString(10), "0")
+	return strings.TrimSuffix(s, ".")
+}
+
+func abs(n int) int {
+	if n < 0 {
+		return -n
+	}
+	return n
+}
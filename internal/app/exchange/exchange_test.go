@@ -0,0 +1,124 @@
+package exchange
+
+import (
+	"errors"
+	"github.com/zlyaptica/hotel_service_backend/internal/app/model"
+	"math/big"
+	"testing"
+)
+
+func testTable(t *testing.T) *Table {
+	t.Helper()
+	table := NewTable()
+	err := table.Set(Rates{
+		Base: "RUB",
+		Rates: map[string]string{
+			"USD": "0.0125",
+			"JPY": "1.6",
+			"KWD": "0.00375",
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return table
+}
+
+func TestTable_Set(t *testing.T) {
+	testCases := []struct {
+		name    string
+		rates   Rates
+		wantErr error
+	}{
+		{name: "valid", rates: Rates{Base: "RUB", Rates: map[string]string{"USD": "0.0125", "EUR": "1/90"}}},
+		{name: "invalid base", rates: Rates{Base: "rub"}, wantErr: model.ErrInvalidCurrency},
+		{name: "invalid currency", rates: Rates{Base: "RUB", Rates: map[string]string{"US": "1"}}, wantErr: model.ErrInvalidCurrency},
+		{name: "not a number", rates: Rates{Base: "RUB", Rates: map[string]string{"USD": "x"}}, wantErr: ErrInvalidRate},
+		{name: "zero", rates: Rates{Base: "RUB", Rates: map[string]string{"USD": "0"}}, wantErr: ErrInvalidRate},
+		{name: "negative", rates: Rates{Base: "RUB", Rates: map[string]string{"USD": "-1"}}, wantErr: ErrInvalidRate},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if err := NewTable().Set(tc.rates); !errors.Is(err, tc.wantErr) {
+				t.Errorf("got %v, want %v", err, tc.wantErr)
+			}
+		})
+	}
+}
+
+func TestTable_Convert(t *testing.T) {
+	table := testTable(t)
+
+	testCases := []struct {
+		name     string
+		money    model.Money
+		to       string
+		want     int64
+		wantRate string
+		wantErr  error
+	}{
+		{name: "same currency", money: model.Money{Amount: 12345, Currency: "RUB"}, to: "RUB", want: 12345, wantRate: "1"},
+		{name: "from the base", money: model.Money{Amount: 800000, Currency: "RUB"}, to: "USD", want: 10000, wantRate: "0.0125"},
+		{name: "to the base", money: model.Money{Amount: 10000, Currency: "USD"}, to: "RUB", want: 800000, wantRate: "80"},
+		{name: "cross rate", money: model.Money{Amount: 100, Currency: "USD"}, to: "JPY", want: 128, wantRate: "128"},
+		// 1000 RUB buy 1600 JPY, whose exponent is 0.
+		{name: "to a currency without minor unit", money: model.Money{Amount: 100000, Currency: "RUB"}, to: "JPY", want: 1600, wantRate: "1.6"},
+		{name: "from a currency without minor unit", money: model.Money{Amount: 1600, Currency: "JPY"}, to: "RUB", want: 100000, wantRate: "0.625"},
+		// 1000 RUB buy 3.750 KWD, whose exponent is 3.
+		{name: "to a three digit minor unit", money: model.Money{Amount: 100000, Currency: "RUB"}, to: "KWD", want: 3750, wantRate: "0.00375"},
+		// 0.40 RUB buy 0.005 USD.
+		{name: "half rounds away from zero, not to even", money: model.Money{Amount: 40, Currency: "RUB"}, to: "USD", want: 1},
+		{name: "odd half", money: model.Money{Amount: 120, Currency: "RUB"}, to: "USD", want: 2},
+		{name: "negative half", money: model.Money{Amount: -40, Currency: "RUB"}, to: "USD", want: -1},
+		// 0.20 RUB buy 0.0025 USD.
+		{name: "below the half", money: model.Money{Amount: 20, Currency: "RUB"}, to: "USD", want: 0},
+		{name: "negative", money: model.Money{Amount: -800000, Currency: "RUB"}, to: "USD", want: -10000},
+		// 0.01 RUB buy 0.016 JPY.
+		{name: "below the minor unit", money: model.Money{Amount: 1, Currency: "RUB"}, to: "JPY", want: 0},
+		{name: "unknown source", money: model.Money{Amount: 100, Currency: "EUR"}, to: "RUB", wantErr: ErrUnknownCurrency},
+		{name: "unknown target", money: model.Money{Amount: 100, Currency: "RUB"}, to: "EUR", wantErr: ErrUnknownCurrency},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			got, rate, err := table.Convert(tc.money, tc.to)
+			if !errors.Is(err, tc.wantErr) {
+				t.Fatalf("got error %v, want %v", err, tc.wantErr)
+			}
+			if tc.wantErr != nil {
+				return
+			}
+			if got != (model.Money{Amount: tc.want, Currency: tc.to}) {
+				t.Errorf("got %v, want %d %s", got, tc.want, tc.to)
+			}
+			if tc.wantRate != "" && rate != tc.wantRate {
+				t.Errorf("rate: got %q, want %q", rate, tc.wantRate)
+			}
+		})
+	}
+}
+
+func TestRound(t *testing.T) {
+	testCases := []struct {
+		num, denom int64
+		want       int64
+	}{
+		{num: 0, denom: 1, want: 0},
+		{num: 7, denom: 2, want: 4},
+		{num: 5, denom: 2, want: 3},
+		{num: -5, denom: 2, want: -3},
+		{num: 49, denom: 100, want: 0},
+		{num: -49, denom: 100, want: 0},
+		{num: 51, denom: 100, want: 1},
+		{num: 2, denom: 3, want: 1},
+		{num: -2, denom: 3, want: -1},
+	}
+
+	for _, tc := range testCases {
+		r := big.NewRat(tc.num, tc.denom)
+		if got := round(r); got != tc.want {
+			t.Errorf("round(%s): got %d, want %d", r, got, tc.want)
+		}
+	}
+}
@@ -0,0 +1,98 @@
+package metrics
+
+import (
+	"strings"
+	"testing"
+)
+
+func write(t *testing.T, r *Registry) string {
+	t.Helper()
+	b := &strings.Builder{}
+	n, err := r.WriteTo(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != int64(b.Len()) {
+		t.Errorf("wrote %d bytes, counted %d", b.Len(), n)
+	}
+	return b.String()
+}
+
+func TestCounterVec(t *testing.T) {
+	r := NewRegistry()
+	c := r.Counter("requests_total", "Requests \\ by \"path\"\nand status.", "path", "status")
+	c.Inc("/b", "200")
+	c.Add(2.5, "/a", "200")
+	c.Inc(`say "hi"\now`+"\n", "500")
+
+	want := `# HELP requests_total Requests \\ by "path"\nand status.
+# TYPE requests_total counter
+requests_total{path="/a",status="200"} 2.5
+requests_total{path="/b",status="200"} 1
+requests_total{path="say \"hi\"\\now\n",status="500"} 1
+`
+	if got := write(t, r); got != want {
+		t.Errorf("got\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestCounterVec_Decrease(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Error("a negative value didn't panic")
+		}
+	}()
+	NewRegistry().Counter("requests_total", "Requests.").Add(-1)
+}
+
+func TestHistogramVec(t *testing.T) {
+	r := NewRegistry()
+	h := r.Histogram("duration_seconds", "Durations.", []float64{1, 0.5}, "route")
+	for _, v := range []float64{0.5, 0.75, 3} {
+		h.Observe(v, "/a")
+	}
+
+	// Buckets are sorted and cumulative, a value on a bound falls into its
+	// bucket and values above the last bound only into +Inf.
+	want := `# HELP duration_seconds Durations.
+# TYPE duration_seconds histogram
+duration_seconds_bucket{route="/a",le="0.5"} 1
+duration_seconds_bucket{route="/a",le="1"} 2
+duration_seconds_bucket{route="/a",le="+Inf"} 3
+duration_seconds_sum{route="/a"} 4.25
+duration_seconds_count{route="/a"} 3
+`
+	if got := write(t, r); got != want {
+		t.Errorf("got\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestRegistry_Funcs(t *testing.T) {
+	r := NewRegistry()
+	open := 3.0
+	r.GaugeFunc("open_connections", "Open connections.", func() float64 { return open })
+	r.CounterFunc("waits_total", "Waits.", func() float64 { return 7 })
+	open = 4
+
+	want := `# HELP open_connections Open connections.
+# TYPE open_connections gauge
+open_connections 4
+# HELP waits_total Waits.
+# TYPE waits_total counter
+waits_total 7
+`
+	if got := write(t, r); got != want {
+		t.Errorf("got\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestRegistry_Twice(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Error("registering a name twice didn't panic")
+		}
+	}()
+	r := NewRegistry()
+	r.Counter("requests_total", "Requests.")
+	r.Counter("requests_total", "Requests.")
+}
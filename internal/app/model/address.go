@@ -13,9 +13,9 @@ type Address struct {
 func (a *Address) Validate() error {
 	return validation.ValidateStruct(
 		a,
-		validation.Field(&a.Country, validation.Required, validation.Length(5, 40)),
-		validation.Field(&a.City, validation.Required, validation.Length(5, 40)),
-		validation.Field(&a.Street, validation.Required, validation.Length(5, 40)),
-		validation.Field(&a.House, validation.Required, validation.Length(1, 10)),
+		validation.Field(&a.Country, validation.Required, validation.RuneLength(2, 40)),
+		validation.Field(&a.City, validation.Required, validation.RuneLength(2, 40)),
+		validation.Field(&a.Street, validation.Required, validation.RuneLength(2, 60)),
+		validation.Field(&a.House, validation.Required, validation.RuneLength(1, 10)),
 	)
 }
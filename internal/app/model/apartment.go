@@ -7,16 +7,15 @@ type Apartment struct {
 	Name           string          `json:"name"`
 	Hotel          *Hotel          `json:"hotel"`
 	ApartmentClass *ApartmentClass `json:"apartment_class"`
-	IsFree         *bool           `json:"is_free"`
 	BedCount       int             `json:"bed_count"`
-	Price          int             `json:"price"`
+	Price          Money           `json:"price"`
 }
 
 func (a *Apartment) Validate() error {
 	return validation.ValidateStruct(
 		a,
-		validation.Field(&a.Name, validation.Required, validation.Length(10, 40)),
-		validation.Field(&a.BedCount, validation.Required, validation.Length(1, 4)),
-		validation.Field(&a.Price, validation.Required, validation.Length(1000, 9999999)),
+		validation.Field(&a.Name, validation.Required, validation.RuneLength(3, 40)),
+		validation.Field(&a.BedCount, validation.Required, validation.Min(1), validation.Max(10)),
+		validation.Field(&a.Price, positive),
 	)
 }
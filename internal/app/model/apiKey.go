@@ -0,0 +1,46 @@
+package model
+
+import (
+	validation "github.com/go-ozzo/ozzo-validation"
+	"time"
+)
+
+// APIKeyPrefix starts every API key, so keys can be told apart from other
+// bearer tokens.
+const APIKeyPrefix = "hs_"
+
+// APIKey lets partners and scripts call the API without a session. It acts
+// with its own role and hotels instead of those of a user. Only the hash of
+// the key is stored; Key is set just once, when the key is created or rotated.
+type APIKey struct {
+	ID         int        `json:"id"`
+	Name       string     `json:"name"`
+	Prefix     string     `json:"prefix"`
+	KeyHash    string     `json:"-"`
+	Key        string     `json:"key,omitempty"`
+	Role       string     `json:"role"`
+	HotelIDs   []int      `json:"hotel_ids,omitempty"`
+	CreatedAt  time.Time  `json:"created_at"`
+	LastUsedAt *time.Time `json:"last_used_at"`
+	RevokedAt  *time.Time `json:"revoked_at,omitempty"`
+}
+
+// User returns the user the key acts as.
+func (k *APIKey) User() *User {
+	return &User{
+		Role:     k.Role,
+		HotelIDs: k.HotelIDs,
+		APIKeyID: k.ID,
+	}
+}
+
+// Validate checks a new key. Keys are issued for hotel managers and
+// administrators only.
+func (k *APIKey) Validate() error {
+	return validation.ValidateStruct(
+		k,
+		validation.Field(&k.Name, validation.Required, validation.RuneLength(1, 100)),
+		validation.Field(&k.Role, validation.Required, validation.In(RoleHotelManager, RoleAdmin)),
+		validation.Field(&k.HotelIDs, managedHotels(k.Role)),
+	)
+}
@@ -0,0 +1,34 @@
+package model
+
+import (
+	validation "github.com/go-ozzo/ozzo-validation"
+	"time"
+)
+
+// CancellationPolicy describes what a guest gets back when cancelling a stay
+// in a hotel: the whole price up to FreeDays days before arrival, and the
+// price reduced by PenaltyPercent after that.
+type CancellationPolicy struct {
+	FreeDays       int `json:"free_days"`
+	PenaltyPercent int `json:"penalty_percent"`
+}
+
+// Refund returns the part of price returned to a guest who cancels at now a
+// stay beginning at arrival. Nothing is returned once the stay has begun.
+func (p *CancellationPolicy) Refund(price Money, arrival, now time.Time) Money {
+	if !now.Before(arrival) {
+		return Money{Currency: price.Currency}
+	}
+	if p == nil || !now.After(arrival.AddDate(0, 0, -p.FreeDays)) {
+		return price
+	}
+	return price.Sub(price.Percent(p.PenaltyPercent))
+}
+
+func (p *CancellationPolicy) Validate() error {
+	return validation.ValidateStruct(
+		p,
+		validation.Field(&p.FreeDays, validation.Min(0), validation.Max(365)),
+		validation.Field(&p.PenaltyPercent, validation.Min(0), validation.Max(100)),
+	)
+}
@@ -0,0 +1,36 @@
+package model
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCancellationPolicy_Refund(t *testing.T) {
+	arrival := time.Date(2030, 3, 10, 0, 0, 0, 0, time.UTC)
+	price := Money{Amount: 200000, Currency: "RUB"}
+	policy := &CancellationPolicy{FreeDays: 3, PenaltyPercent: 25}
+
+	testCases := []struct {
+		name   string
+		policy *CancellationPolicy
+		now    time.Time
+		want   int64
+	}{
+		{name: "free period", policy: policy, now: arrival.AddDate(0, 0, -5), want: 200000},
+		{name: "last free moment", policy: policy, now: arrival.AddDate(0, 0, -3), want: 200000},
+		{name: "with the penalty", policy: policy, now: arrival.AddDate(0, 0, -1), want: 150000},
+		{name: "no policy", now: arrival.Add(-time.Hour), want: 200000},
+		{name: "on arrival", policy: policy, now: arrival, want: 0},
+		{name: "after departure", policy: policy, now: arrival.AddDate(0, 0, 5), want: 0},
+		{name: "begun without a policy", now: arrival.Add(time.Hour), want: 0},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			got := tc.policy.Refund(price, arrival, tc.now)
+			if got != (Money{Amount: tc.want, Currency: "RUB"}) {
+				t.Errorf("got %v, want %d", got, tc.want)
+			}
+		})
+	}
+}
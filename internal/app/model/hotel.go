@@ -1,10 +1,27 @@
 package model
 
+import validation "github.com/go-ozzo/ozzo-validation"
+
 type Hotel struct {
-	ID                 int      `json:"id"`
-	Name               string   `json:"name"`
-	Address            *Address `json:"address"`
-	StarsCount         int      `json:"stars_count"`
-	Description        string   `json:"description"`
-	HeaderImageAddress string   `json:"header_image_address"`
+	ID                 int                 `json:"id"`
+	Name               string              `json:"name"`
+	Address            *Address            `json:"address"`
+	StarsCount         int                 `json:"stars_count"`
+	Description        string              `json:"description"`
+	HeaderImageAddress string              `json:"header_image_address"`
+	CancellationPolicy *CancellationPolicy `json:"cancellation_policy"`
+	Currency           string              `json:"currency"`
+	MinPrice           *Money              `json:"min_price,omitempty"`
+}
+
+func (h *Hotel) Validate() error {
+	return validation.ValidateStruct(
+		h,
+		validation.Field(&h.Name, validation.Required, validation.RuneLength(2, 100)),
+		validation.Field(&h.Address, validation.Required),
+		validation.Field(&h.StarsCount, validation.Required, validation.Min(1), validation.Max(5)),
+		validation.Field(&h.Description, validation.RuneLength(0, 2000)),
+		validation.Field(&h.CancellationPolicy),
+		validation.Field(&h.Currency, currency),
+	)
 }
@@ -0,0 +1,12 @@
+package model
+
+// HotelSearchResult is a hotel found by full-text search. Name and Snippet
+// are the hotel name and a fragment of its description with the matching
+// words wrapped in <mark> tags. The rest of the text is HTML-escaped, so
+// they can be inserted into a page as is.
+type HotelSearchResult struct {
+	Hotel   *Hotel  `json:"hotel"`
+	Rank    float64 `json:"rank"`
+	Name    string  `json:"name"`
+	Snippet string  `json:"snippet"`
+}
@@ -0,0 +1,159 @@
+package model
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// DefaultCurrency is the base currency of hotels that don't set their own.
+const DefaultCurrency = "RUB"
+
+var (
+	ErrInvalidCurrency = errors.New("invalid currency code")
+	ErrInvalidAmount   = errors.New("invalid amount")
+
+	currencyCode = regexp.MustCompile(`^[A-Z]{3}$`)
+
+	// currencyExponents lists the ISO 4217 currencies whose minor unit isn't
+	// a hundredth of the major one.
+	currencyExponents = map[string]int{
+		"BHD": 3,
+		"CLP": 0,
+		"IQD": 3,
+		"ISK": 0,
+		"JOD": 3,
+		"JPY": 0,
+		"KRW": 0,
+		"KWD": 3,
+		"LYD": 3,
+		"OMR": 3,
+		"TND": 3,
+		"UGX": 0,
+		"VND": 0,
+	}
+)
+
+// Money is an amount in the minor units of an ISO 4217 currency (kopecks,
+// cents), so prices are never rounded through floating point. In JSON the
+// amount is a decimal string in major units, e.g. {"amount": "1234.50",
+// "currency": "RUB"}.
+type Money struct {
+	Amount   int64
+	Currency string
+}
+
+// ValidCurrency reports whether code looks like an ISO 4217 currency code.
+func ValidCurrency(code string) bool {
+	return currencyCode.MatchString(code)
+}
+
+// CurrencyExponent returns the number of digits after the decimal point in
+// amounts of the currency.
+func CurrencyExponent(currency string) int {
+	if e, ok := currencyExponents[currency]; ok {
+		return e
+	}
+	return 2
+}
+
+// ParseMoney parses a decimal amount in major units of the currency.
+func ParseMoney(amount, currency string) (Money, error) {
+	if !ValidCurrency(currency) {
+		return Money{}, ErrInvalidCurrency
+	}
+	exp := CurrencyExponent(currency)
+
+	amount = strings.TrimSpace(amount)
+	negative := strings.HasPrefix(amount, "-")
+	amount = strings.TrimPrefix(amount, "-")
+	whole, frac := amount, ""
+	if i := strings.IndexByte(amount, '.'); i >= 0 {
+		whole, frac = amount[:i], amount[i+1:]
+	}
+	if whole == "" || len(frac) > exp || strings.ContainsAny(whole+frac, "+-") {
+		return Money{}, ErrInvalidAmount
+	}
+	digits := whole + frac + strings.Repeat("0", exp-len(frac))
+	minor, err := strconv.ParseInt(digits, 10, 64)
+	if err != nil {
+		return Money{}, ErrInvalidAmount
+	}
+	if negative {
+		minor = -minor
+	}
+	return Money{Amount: minor, Currency: currency}, nil
+}
+
+// Add returns m + o. Both amounts must be in the same currency.
+func (m Money) Add(o Money) Money {
+	return Money{Amount: m.Amount + o.Amount, Currency: m.Currency}
+}
+
+// Sub returns m - o. Both amounts must be in the same currency.
+func (m Money) Sub(o Money) Money {
+	return Money{Amount: m.Amount - o.Amount, Currency: m.Currency}
+}
+
+// Percent returns p percent of m rounded half away from zero to the minor
+// unit.
+func (m Money) Percent(p int) Money {
+	v := m.Amount * int64(p)
+	if v >= 0 {
+		v = (v + 50) / 100
+	} else {
+		v = (v - 50) / 100
+	}
+	return Money{Amount: v, Currency: m.Currency}
+}
+
+// Decimal returns the amount as a decimal string in major units.
+func (m Money) Decimal() string {
+	exp := CurrencyExponent(m.Currency)
+	amount := m.Amount
+	sign := ""
+	if amount < 0 {
+		sign, amount = "-", -amount
+	}
+	s := strconv.FormatInt(amount, 10)
+	if exp == 0 {
+		return sign + s
+	}
+	if len(s) <= exp {
+		s = strings.Repeat("0", exp-len(s)+1) + s
+	}
+	return sign + s[:len(s)-exp] + "." + s[len(s)-exp:]
+}
+
+func (m Money) String() string {
+	return fmt.Sprintf("%s %s", m.Decimal(), m.Currency)
+}
+
+func (m Money) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		Amount   string `json:"amount"`
+		Currency string `json:"currency"`
+	}{
+		Amount:   m.Decimal(),
+		Currency: m.Currency,
+	})
+}
+
+func (m *Money) UnmarshalJSON(data []byte) error {
+	v := struct {
+		Amount   json.Number `json:"amount"`
+		Currency string      `json:"currency"`
+	}{}
+	if err := json.Unmarshal(data, &v); err != nil {
+		return err
+	}
+	parsed, err := ParseMoney(v.Amount.String(), v.Currency)
+	if err != nil {
+		return err
+	}
+	*m = parsed
+	return nil
+}
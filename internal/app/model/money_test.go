@@ -0,0 +1,123 @@
+package model
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestParseMoney(t *testing.T) {
+	testCases := []struct {
+		name     string
+		amount   string
+		currency string
+		want     int64
+		wantErr  error
+	}{
+		{name: "whole", amount: "1234", currency: "RUB", want: 123400},
+		{name: "fraction", amount: "1234.5", currency: "RUB", want: 123450},
+		{name: "all minor digits", amount: "0.05", currency: "RUB", want: 5},
+		{name: "trailing point", amount: "12.", currency: "RUB", want: 1200},
+		{name: "surrounding spaces", amount: " 12.30 ", currency: "RUB", want: 1230},
+		{name: "negative", amount: "-0.5", currency: "RUB", want: -50},
+		{name: "no minor unit", amount: "1500", currency: "JPY", want: 1500},
+		{name: "three digit minor unit", amount: "1.234", currency: "KWD", want: 1234},
+		{name: "too many digits", amount: "1.234", currency: "RUB", wantErr: ErrInvalidAmount},
+		{name: "fraction of a yen", amount: "1.5", currency: "JPY", wantErr: ErrInvalidAmount},
+		{name: "no whole part", amount: ".5", currency: "RUB", wantErr: ErrInvalidAmount},
+		{name: "double sign", amount: "--1", currency: "RUB", wantErr: ErrInvalidAmount},
+		{name: "sign in the fraction", amount: "1.-5", currency: "RUB", wantErr: ErrInvalidAmount},
+		{name: "not a number", amount: "1e3", currency: "RUB", wantErr: ErrInvalidAmount},
+		{name: "overflow", amount: "92233720368547758.08", currency: "RUB", wantErr: ErrInvalidAmount},
+		{name: "lowercase currency", amount: "1", currency: "rub", wantErr: ErrInvalidCurrency},
+		{name: "empty currency", amount: "1", currency: "", wantErr: ErrInvalidCurrency},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			m, err := ParseMoney(tc.amount, tc.currency)
+			if !errors.Is(err, tc.wantErr) {
+				t.Fatalf("got error %v, want %v", err, tc.wantErr)
+			}
+			if tc.wantErr == nil && m != (Money{Amount: tc.want, Currency: tc.currency}) {
+				t.Errorf("got %v, want %d %s", m, tc.want, tc.currency)
+			}
+		})
+	}
+}
+
+func TestMoney_Decimal(t *testing.T) {
+	testCases := []struct {
+		money Money
+		want  string
+	}{
+		{money: Money{Amount: 123450, Currency: "RUB"}, want: "1234.50"},
+		{money: Money{Amount: 5, Currency: "RUB"}, want: "0.05"},
+		{money: Money{Amount: 0, Currency: "RUB"}, want: "0.00"},
+		{money: Money{Amount: -5, Currency: "RUB"}, want: "-0.05"},
+		{money: Money{Amount: -123450, Currency: "RUB"}, want: "-1234.50"},
+		{money: Money{Amount: 1500, Currency: "JPY"}, want: "1500"},
+		{money: Money{Amount: -1500, Currency: "JPY"}, want: "-1500"},
+		{money: Money{Amount: 1234, Currency: "KWD"}, want: "1.234"},
+		{money: Money{Amount: 7, Currency: "KWD"}, want: "0.007"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.want+" "+tc.money.Currency, func(t *testing.T) {
+			if got := tc.money.Decimal(); got != tc.want {
+				t.Errorf("got %q, want %q", got, tc.want)
+			}
+			parsed, err := ParseMoney(tc.want, tc.money.Currency)
+			if err != nil || parsed != tc.money {
+				t.Errorf("round trip: got %v, %v", parsed, err)
+			}
+		})
+	}
+}
+
+func TestMoney_Percent(t *testing.T) {
+	testCases := []struct {
+		name    string
+		amount  int64
+		percent int
+		want    int64
+	}{
+		{name: "exact", amount: 100000, percent: 10, want: 10000},
+		{name: "rounded down", amount: 333, percent: 10, want: 33},
+		{name: "rounded up", amount: 337, percent: 10, want: 34},
+		{name: "half rounds away from zero, not to even", amount: 25, percent: 10, want: 3},
+		{name: "odd half", amount: 35, percent: 10, want: 4},
+		{name: "negative half", amount: -25, percent: 10, want: -3},
+		{name: "negative", amount: -333, percent: 10, want: -33},
+		{name: "zero", amount: 100000, percent: 0, want: 0},
+		{name: "over a hundred", amount: 100, percent: 150, want: 150},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			got := Money{Amount: tc.amount, Currency: "RUB"}.Percent(tc.percent)
+			if got != (Money{Amount: tc.want, Currency: "RUB"}) {
+				t.Errorf("got %v, want %d", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestMoney_JSON(t *testing.T) {
+	m := Money{}
+	if err := m.UnmarshalJSON([]byte(`{"amount": 12.5, "currency": "USD"}`)); err != nil {
+		t.Fatal(err)
+	}
+	if m != (Money{Amount: 1250, Currency: "USD"}) {
+		t.Errorf("got %v", m)
+	}
+	data, err := m.MarshalJSON()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != `{"amount":"12.50","currency":"USD"}` {
+		t.Errorf("got %s", data)
+	}
+	if err := m.UnmarshalJSON([]byte(`{"amount": "12.505", "currency": "USD"}`)); !errors.Is(err, ErrInvalidAmount) {
+		t.Errorf("got error %v, want %v", err, ErrInvalidAmount)
+	}
+}
@@ -0,0 +1,14 @@
+package model
+
+import "time"
+
+// OTP is a one-time login code sent to a phone number. Only the hash of the
+// code is stored.
+type OTP struct {
+	ID          int
+	PhoneNumber string
+	CodeHash    string
+	Attempts    int
+	ExpiresAt   time.Time
+	CreatedAt   time.Time
+}
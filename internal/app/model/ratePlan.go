@@ -0,0 +1,57 @@
+package model
+
+import (
+	validation "github.com/go-ozzo/ozzo-validation"
+	"time"
+)
+
+// RatePlan holds the pricing rules of an apartment on top of its base price.
+// The zero value prices every night at the base price.
+type RatePlan struct {
+	ApartmentID             int            `json:"apartment_id"`
+	WeekendSurchargePercent int            `json:"weekend_surcharge_percent"`
+	MinStay                 int            `json:"min_stay"`
+	Seasons                 []SeasonalRate `json:"seasons"`
+	StayDiscounts           []StayDiscount `json:"stay_discounts"`
+}
+
+// SeasonalRate replaces the base price for the nights from DateFrom to DateTo
+// inclusive.
+type SeasonalRate struct {
+	DateFrom time.Time `json:"date_from"`
+	DateTo   time.Time `json:"date_to"`
+	Price    Money     `json:"price"`
+}
+
+// StayDiscount takes Percent off stays of at least MinNights nights.
+type StayDiscount struct {
+	MinNights int `json:"min_nights"`
+	Percent   int `json:"percent"`
+}
+
+func (p *RatePlan) Validate() error {
+	return validation.ValidateStruct(
+		p,
+		validation.Field(&p.WeekendSurchargePercent, validation.Min(0), validation.Max(100)),
+		validation.Field(&p.MinStay, validation.Min(0)),
+		validation.Field(&p.Seasons),
+		validation.Field(&p.StayDiscounts),
+	)
+}
+
+func (s SeasonalRate) Validate() error {
+	return validation.ValidateStruct(
+		&s,
+		validation.Field(&s.DateFrom, validation.Required),
+		validation.Field(&s.DateTo, validation.Required, notBefore(s.DateFrom, errBeforeStart)),
+		validation.Field(&s.Price, positive),
+	)
+}
+
+func (d StayDiscount) Validate() error {
+	return validation.ValidateStruct(
+		&d,
+		validation.Field(&d.MinNights, validation.Required, validation.Min(1)),
+		validation.Field(&d.Percent, validation.Required, validation.Min(1), validation.Max(100)),
+	)
+}
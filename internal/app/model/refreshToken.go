@@ -0,0 +1,14 @@
+package model
+
+import "time"
+
+// RefreshToken is exchanged for a new access token. Each token is used once:
+// refreshing revokes it and issues a new one. Only the hash is stored.
+type RefreshToken struct {
+	ID        int
+	UserID    int
+	TokenHash string
+	ExpiresAt time.Time
+	CreatedAt time.Time
+	RevokedAt *time.Time
+}
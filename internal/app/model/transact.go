@@ -1,13 +1,54 @@
 package model
 
-import "time"
+import (
+	validation "github.com/go-ozzo/ozzo-validation"
+	"time"
+)
+
+const (
+	TransactStatusActive    = "active"
+	TransactStatusCancelled = "cancelled"
+)
 
 type Transact struct {
 	ID            int        `json:"id"`
 	OperationDate time.Time  `json:"operation_date"`
 	Apartment     *Apartment `json:"apartment"`
 	User          *User      `json:"user"`
-	Price         int        `json:"price"`
+	Price         Money      `json:"price"`
 	DateArrival   time.Time  `json:"date_arrival"`
 	DateDeparture time.Time  `json:"date_departure"`
+	Status        string     `json:"status"`
+	CancelledAt   *time.Time `json:"cancelled_at,omitempty"`
+	Refund        *Money     `json:"refund,omitempty"`
+	DisplayPrice  *Money     `json:"display_price,omitempty"`
+	ExchangeRate  string     `json:"exchange_rate,omitempty"`
+	// CancellationPolicy is the policy of the hotel when the stay was
+	// booked. Later changes of the hotel policy don't apply to the stay.
+	CancellationPolicy *CancellationPolicy `json:"cancellation_policy,omitempty"`
+}
+
+// Validate checks a new stay: it must not begin in the past and must last
+// at least one night.
+func (t *Transact) Validate() error {
+	return t.validate(true)
+}
+
+// ValidateUpdate checks a changed stay that began on arrival before the
+// change. A stay already begun keeps its arrival in the past, so the arrival
+// is only checked against today when it moves.
+func (t *Transact) ValidateUpdate(arrival time.Time) error {
+	return t.validate(!t.DateArrival.Equal(arrival))
+}
+
+func (t *Transact) validate(checkPast bool) error {
+	arrivalRules := []validation.Rule{validation.Required}
+	if checkPast {
+		arrivalRules = append(arrivalRules, notBefore(today(), errPastDate))
+	}
+	return validation.ValidateStruct(
+		t,
+		validation.Field(&t.DateArrival, arrivalRules...),
+		validation.Field(&t.DateDeparture, validation.Required, notBefore(t.DateArrival.AddDate(0, 0, 1), errNotAfter)),
+	)
 }
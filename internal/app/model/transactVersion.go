@@ -0,0 +1,15 @@
+package model
+
+import "time"
+
+// TransactVersion is a previous state of a transact, saved when the guest
+// changed the dates or the apartment of the booking.
+type TransactVersion struct {
+	ID            int       `json:"id"`
+	TransactID    int       `json:"transact_id"`
+	ApartmentID   int       `json:"apartment_id"`
+	Price         Money     `json:"price"`
+	DateArrival   time.Time `json:"date_arrival"`
+	DateDeparture time.Time `json:"date_departure"`
+	ChangedAt     time.Time `json:"changed_at"`
+}
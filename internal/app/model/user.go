@@ -2,17 +2,59 @@ package model
 
 import validation "github.com/go-ozzo/ozzo-validation"
 
+// Roles of users. Guests book apartments, hotel managers edit the hotels
+// listed in User.HotelIDs and administrators may do everything.
+const (
+	RoleGuest        = "guest"
+	RoleHotelManager = "hotel_manager"
+	RoleAdmin        = "admin"
+)
+
 type User struct {
 	ID          int    `json:"id"`
 	LName       string `json:"l_name"`
 	FName       string `json:"f_name"`
 	PhoneNumber string `json:"phone_number"`
+	Role        string `json:"role"`
+	HotelIDs    []int  `json:"hotel_ids,omitempty"`
+	// APIKeyID is set when the request is authenticated with an API key.
+	APIKeyID int `json:"api_key_id,omitempty"`
 }
 
 func (g *User) Validate() error {
 	return validation.ValidateStruct(
 		g,
-		validation.Field(&g.PhoneNumber, validation.Required, validation.Length(11, 14)),
-		validation.Field(&g.LName, validation.Required, validation.Length(3, 20)),
+		validation.Field(&g.PhoneNumber, validation.Required, phoneNumber),
+		validation.Field(&g.LName, validation.Required, validation.RuneLength(2, 40)),
+		validation.Field(&g.FName, validation.RuneLength(0, 40)),
+	)
+}
+
+// ValidateRole checks the role of the user and the hotels they manage.
+func (g *User) ValidateRole() error {
+	return validation.ValidateStruct(
+		g,
+		validation.Field(&g.Role, validation.Required, validation.In(RoleGuest, RoleHotelManager, RoleAdmin)),
+		validation.Field(&g.HotelIDs, managedHotels(g.Role)),
 	)
 }
+
+func (g *User) IsAdmin() bool {
+	return g.Role == RoleAdmin
+}
+
+// Manages reports whether the user may edit the hotel and its apartments.
+func (g *User) Manages(hotelID int) bool {
+	if g.IsAdmin() {
+		return true
+	}
+	if g.Role != RoleHotelManager {
+		return false
+	}
+	for _, id := range g.HotelIDs {
+		if id == hotelID {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,97 @@
+package model
+
+import (
+	"errors"
+	validation "github.com/go-ozzo/ozzo-validation"
+	"regexp"
+	"strings"
+	"time"
+)
+
+var (
+	errPhoneNumber  = errors.New("must be a phone number in the E.164 format, like +79811234567")
+	errNotPositive  = errors.New("must be greater than zero")
+	errPastDate     = errors.New("must not be in the past")
+	errNotAfter     = errors.New("must be after the arrival date")
+	errBeforeStart  = errors.New("must not be before date_from")
+	errNotManagable = errors.New("only hotel managers manage hotels")
+)
+
+var (
+	phoneNumberPattern = regexp.MustCompile(`^\+[1-9][0-9]{7,14}$`)
+	nationalRUPattern  = regexp.MustCompile(`^8[0-9]{10}$`)
+	phoneSeparators    = strings.NewReplacer(" ", "", "-", "", "(", "", ")", "", ".", "")
+)
+
+// rule adapts a function to validation.Rule.
+type rule func(value interface{}) error
+
+func (f rule) Validate(value interface{}) error {
+	return f(value)
+}
+
+// phoneNumber checks that a phone number is written in the E.164 format.
+var phoneNumber = validation.Match(phoneNumberPattern).Error(errPhoneNumber.Error())
+
+// ValidatePhoneNumber checks a phone number given on its own, outside of a
+// user.
+func ValidatePhoneNumber(phone string) error {
+	return validation.Validate(phone, validation.Required, phoneNumber)
+}
+
+// NormalizePhoneNumber brings a phone number to the E.164 format the users
+// are stored with: separators are dropped, the Russian trunk prefix 8 is
+// replaced with +7 and a missing + is added. Numbers were stored as typed
+// before, so it is applied to every number given to the API.
+func NormalizePhoneNumber(phone string) string {
+	phone = phoneSeparators.Replace(strings.TrimSpace(phone))
+	switch {
+	case phone == "" || strings.HasPrefix(phone, "+"):
+		return phone
+	case nationalRUPattern.MatchString(phone):
+		return "+7" + phone[1:]
+	}
+	return "+" + phone
+}
+
+// positive checks that money is more than zero.
+var positive = rule(func(value interface{}) error {
+	if m, ok := value.(Money); ok && m.Amount <= 0 {
+		return errNotPositive
+	}
+	return nil
+})
+
+// currency checks that a currency code is known.
+var currency = rule(func(value interface{}) error {
+	if c, _ := value.(string); !ValidCurrency(c) {
+		return ErrInvalidCurrency
+	}
+	return nil
+})
+
+// notBefore checks that a date is not before min.
+func notBefore(min time.Time, err error) validation.Rule {
+	return rule(func(value interface{}) error {
+		if t, ok := value.(time.Time); ok && t.Before(min) {
+			return err
+		}
+		return nil
+	})
+}
+
+// today returns the current date in UTC, the time zone stay dates are
+// parsed in.
+func today() time.Time {
+	return time.Now().UTC().Truncate(24 * time.Hour)
+}
+
+// managedHotels checks that only hotel managers are given hotels.
+func managedHotels(role string) validation.Rule {
+	return rule(func(value interface{}) error {
+		if ids, _ := value.([]int); len(ids) > 0 && role != RoleHotelManager {
+			return errNotManagable
+		}
+		return nil
+	})
+}
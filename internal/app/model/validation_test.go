@@ -0,0 +1,27 @@
+package model
+
+import "testing"
+
+func TestNormalizePhoneNumber(t *testing.T) {
+	testCases := []struct {
+		name  string
+		phone string
+		want  string
+	}{
+		{name: "e164", phone: "+79811234567", want: "+79811234567"},
+		{name: "russian trunk prefix", phone: "89811234567", want: "+79811234567"},
+		{name: "missing plus", phone: "79811234567", want: "+79811234567"},
+		{name: "separators", phone: " +7 (981) 123-45.67 ", want: "+79811234567"},
+		{name: "trunk prefix with separators", phone: "8 981 123-45-67", want: "+79811234567"},
+		{name: "other country", phone: "4915112345678", want: "+4915112345678"},
+		{name: "empty", phone: "", want: ""},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := NormalizePhoneNumber(tc.phone); got != tc.want {
+				t.Errorf("got %q, want %q", got, tc.want)
+			}
+		})
+	}
+}
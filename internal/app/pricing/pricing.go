@@ -0,0 +1,155 @@
+// Package pricing computes the price of a stay from the base price of an
+// apartment and the rules of its rate plan.
+package pricing
+
+import (
+	"errors"
+	"github.com/zlyaptica/hotel_service_backend/internal/app/model"
+	"time"
+)
+
+var (
+	ErrMinStay = errors.New("stay is shorter than the minimum stay")
+)
+
+// Night is the price of a single night of a stay.
+type Night struct {
+	Date      time.Time   `json:"date"`
+	Rate      model.Money `json:"rate"`
+	Surcharge model.Money `json:"surcharge"`
+	Price     model.Money `json:"price"`
+}
+
+// Quote is the per-night breakdown of the price of a stay.
+type Quote struct {
+	ApartmentID   int          `json:"apartment_id"`
+	DateArrival   time.Time    `json:"date_arrival"`
+	DateDeparture time.Time    `json:"date_departure"`
+	Currency      string       `json:"currency"`
+	Nights        []Night      `json:"nights"`
+	Subtotal      model.Money  `json:"subtotal"`
+	Discount      model.Money  `json:"discount"`
+	Total         model.Money  `json:"total"`
+	OriginalTotal *model.Money `json:"original_total,omitempty"`
+	ExchangeRate  string       `json:"exchange_rate,omitempty"`
+}
+
+// Converter converts money into another currency, returning the rate used.
+type Converter interface {
+	Convert(m model.Money, to string) (model.Money, string, error)
+}
+
+// In returns a copy of the quote with every amount converted into currency.
+// The total in the original currency is kept in OriginalTotal.
+func (q *Quote) In(currency string, c Converter) (*Quote, error) {
+	if currency == q.Currency {
+		return q, nil
+	}
+	converted := *q
+	converted.Currency = currency
+	converted.Nights = make([]Night, len(q.Nights))
+	originalTotal := q.Total
+	converted.OriginalTotal = &originalTotal
+
+	var err error
+	convert := func(m model.Money) model.Money {
+		if err != nil {
+			return m
+		}
+		var res model.Money
+		res, converted.ExchangeRate, err = c.Convert(m, currency)
+		return res
+	}
+	for i, n := range q.Nights {
+		converted.Nights[i] = Night{
+			Date:      n.Date,
+			Rate:      convert(n.Rate),
+			Surcharge: convert(n.Surcharge),
+			Price:     convert(n.Price),
+		}
+	}
+	converted.Subtotal = convert(q.Subtotal)
+	converted.Discount = convert(q.Discount)
+	converted.Total = convert(q.Total)
+	if err != nil {
+		return nil, err
+	}
+	return &converted, nil
+}
+
+// NightsTotal returns the sum of the prices of all nights of the stay.
+func (q *Quote) NightsTotal() model.Money {
+	total := model.Money{Currency: q.Currency}
+	for _, n := range q.Nights {
+		total = total.Add(n.Price)
+	}
+	return total
+}
+
+// Rule adjusts a quote according to a rate plan.
+type Rule interface {
+	Apply(plan *model.RatePlan, q *Quote) error
+}
+
+// RuleFunc adapts an ordinary function to the Rule interface.
+type RuleFunc func(plan *model.RatePlan, q *Quote) error
+
+func (f RuleFunc) Apply(plan *model.RatePlan, q *Quote) error {
+	return f(plan, q)
+}
+
+// Engine prices stays by applying its rules in order.
+type Engine struct {
+	rules []Rule
+}
+
+func NewEngine(rules ...Rule) *Engine {
+	return &Engine{
+		rules: rules,
+	}
+}
+
+// NewDefaultEngine returns an engine with the minimum stay, seasonal price,
+// weekend surcharge and length-of-stay discount rules.
+func NewDefaultEngine() *Engine {
+	return NewEngine(
+		RuleFunc(MinStay),
+		RuleFunc(Seasons),
+		RuleFunc(WeekendSurcharge),
+		RuleFunc(StayDiscount),
+	)
+}
+
+// Quote prices a stay in the apartment from arrival to departure. Every night
+// starts at the base price of the apartment before the rules are applied.
+func (e *Engine) Quote(a *model.Apartment, plan *model.RatePlan, arrival, departure time.Time) (*Quote, error) {
+	q := &Quote{
+		ApartmentID:   a.ID,
+		DateArrival:   arrival,
+		DateDeparture: departure,
+		Currency:      a.Price.Currency,
+		Nights:        []Night{},
+		Discount:      model.Money{Currency: a.Price.Currency},
+	}
+	for d := arrival; d.Before(departure); d = d.AddDate(0, 0, 1) {
+		q.Nights = append(q.Nights, Night{
+			Date:      d,
+			Rate:      a.Price,
+			Surcharge: model.Money{Currency: a.Price.Currency},
+			Price:     a.Price,
+		})
+	}
+
+	if plan == nil {
+		plan = &model.RatePlan{}
+	}
+	for _, rule := range e.rules {
+		if err := rule.Apply(plan, q); err != nil {
+			return nil, err
+		}
+	}
+
+	q.Subtotal = q.NightsTotal()
+	q.Total = q.Subtotal.Sub(q.Discount)
+	return q, nil
+}
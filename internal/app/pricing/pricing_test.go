@@ -0,0 +1,292 @@
+package pricing
+
+import (
+	"errors"
+	"github.com/zlyaptica/hotel_service_backend/internal/app/model"
+	"testing"
+	"time"
+)
+
+// monday is the first night of the stays in the tests.
+var monday = time.Date(2030, 3, 4, 0, 0, 0, 0, time.UTC)
+
+func rub(amount int64) model.Money {
+	return model.Money{Amount: amount, Currency: "RUB"}
+}
+
+// testQuote returns a quote of nights nights from monday at price each.
+func testQuote(nights int, price int64) *Quote {
+	q := &Quote{
+		Currency: "RUB",
+		Discount: rub(0),
+	}
+	for i := 0; i < nights; i++ {
+		q.Nights = append(q.Nights, Night{
+			Date:      monday.AddDate(0, 0, i),
+			Rate:      rub(price),
+			Surcharge: rub(0),
+			Price:     rub(price),
+		})
+	}
+	return q
+}
+
+func prices(q *Quote) []int64 {
+	p := make([]int64, len(q.Nights))
+	for i, n := range q.Nights {
+		p[i] = n.Price.Amount
+	}
+	return p
+}
+
+func equal(a, b []int64) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func TestMinStay(t *testing.T) {
+	testCases := []struct {
+		name    string
+		minStay int
+		nights  int
+		wantErr bool
+	}{
+		{name: "no minimum", minStay: 0, nights: 1},
+		{name: "exactly the minimum", minStay: 3, nights: 3},
+		{name: "longer", minStay: 3, nights: 4},
+		{name: "shorter", minStay: 3, nights: 2, wantErr: true},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			err := MinStay(&model.RatePlan{MinStay: tc.minStay}, testQuote(tc.nights, 100000))
+			if tc.wantErr != errors.Is(err, ErrMinStay) {
+				t.Errorf("got %v, want error %v", err, tc.wantErr)
+			}
+		})
+	}
+}
+
+func TestSeasons(t *testing.T) {
+	testCases := []struct {
+		name    string
+		seasons []model.SeasonalRate
+		want    []int64
+	}{
+		{
+			name: "no seasons",
+			want: []int64{100000, 100000, 100000},
+		},
+		{
+			name: "bounds are inclusive",
+			seasons: []model.SeasonalRate{
+				{DateFrom: monday.AddDate(0, 0, 1), DateTo: monday.AddDate(0, 0, 2), Price: rub(150000)},
+			},
+			want: []int64{100000, 150000, 150000},
+		},
+		{
+			name: "first overlapping season wins",
+			seasons: []model.SeasonalRate{
+				{DateFrom: monday, DateTo: monday, Price: rub(120000)},
+				{DateFrom: monday, DateTo: monday.AddDate(0, 0, 1), Price: rub(180000)},
+			},
+			want: []int64{120000, 180000, 100000},
+		},
+		{
+			name: "season outside of the stay",
+			seasons: []model.SeasonalRate{
+				{DateFrom: monday.AddDate(0, 1, 0), DateTo: monday.AddDate(0, 2, 0), Price: rub(150000)},
+			},
+			want: []int64{100000, 100000, 100000},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			q := testQuote(3, 100000)
+			if err := Seasons(&model.RatePlan{Seasons: tc.seasons}, q); err != nil {
+				t.Fatal(err)
+			}
+			if got := prices(q); !equal(got, tc.want) {
+				t.Errorf("got %v, want %v", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestWeekendSurcharge(t *testing.T) {
+	testCases := []struct {
+		name    string
+		percent int
+		price   int64
+		want    []int64
+	}{
+		{
+			name:    "no surcharge",
+			percent: 0,
+			price:   100000,
+			want:    []int64{100000, 100000, 100000, 100000, 100000, 100000, 100000},
+		},
+		{
+			name:    "friday and saturday",
+			percent: 20,
+			price:   100000,
+			want:    []int64{100000, 100000, 100000, 100000, 120000, 120000, 100000},
+		},
+		{
+			name:    "rounded half away from zero",
+			percent: 15,
+			price:   333,
+			want:    []int64{333, 333, 333, 333, 383, 383, 333},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			q := testQuote(7, tc.price)
+			if err := WeekendSurcharge(&model.RatePlan{WeekendSurchargePercent: tc.percent}, q); err != nil {
+				t.Fatal(err)
+			}
+			if got := prices(q); !equal(got, tc.want) {
+				t.Errorf("got %v, want %v", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestStayDiscount(t *testing.T) {
+	discounts := []model.StayDiscount{
+		{MinNights: 7, Percent: 10},
+		{MinNights: 3, Percent: 5},
+		{MinNights: 14, Percent: 20},
+	}
+	testCases := []struct {
+		name   string
+		nights int
+		want   int64
+	}{
+		{name: "too short", nights: 2, want: 0},
+		{name: "shortest discount", nights: 3, want: 15000},
+		{name: "longest qualifying discount", nights: 10, want: 100000},
+		{name: "longest discount", nights: 14, want: 280000},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			q := testQuote(tc.nights, 100000)
+			if err := StayDiscount(&model.RatePlan{StayDiscounts: discounts}, q); err != nil {
+				t.Fatal(err)
+			}
+			if q.Discount != rub(tc.want) {
+				t.Errorf("got %v, want %v", q.Discount, rub(tc.want))
+			}
+		})
+	}
+}
+
+func TestEngine_Quote(t *testing.T) {
+	a := &model.Apartment{ID: 1, Price: rub(100000)}
+	plan := &model.RatePlan{
+		WeekendSurchargePercent: 10,
+		MinStay:                 2,
+		Seasons: []model.SeasonalRate{
+			{DateFrom: monday.AddDate(0, 0, 3), DateTo: monday.AddDate(0, 0, 4), Price: rub(200000)},
+		},
+		StayDiscounts: []model.StayDiscount{
+			{MinNights: 5, Percent: 10},
+		},
+	}
+
+	testCases := []struct {
+		name       string
+		plan       *model.RatePlan
+		nights     int
+		wantPrices []int64
+		wantTotal  int64
+		wantErr    error
+	}{
+		{
+			name:       "without a plan",
+			nights:     2,
+			wantPrices: []int64{100000, 100000},
+			wantTotal:  200000,
+		},
+		{
+			// Thursday is in the season, Friday is in the season and gets
+			// the surcharge on the seasonal rate, Saturday only the
+			// surcharge.
+			name:       "all rules",
+			plan:       plan,
+			nights:     6,
+			wantPrices: []int64{100000, 100000, 100000, 200000, 220000, 110000},
+			wantTotal:  747000,
+		},
+		{
+			name:    "too short",
+			plan:    plan,
+			nights:  1,
+			wantErr: ErrMinStay,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			q, err := NewDefaultEngine().Quote(a, tc.plan, monday, monday.AddDate(0, 0, tc.nights))
+			if !errors.Is(err, tc.wantErr) {
+				t.Fatalf("got error %v, want %v", err, tc.wantErr)
+			}
+			if tc.wantErr != nil {
+				return
+			}
+			if got := prices(q); !equal(got, tc.wantPrices) {
+				t.Errorf("nights: got %v, want %v", got, tc.wantPrices)
+			}
+			if q.Subtotal != q.NightsTotal() {
+				t.Errorf("subtotal %v is not the sum of the nights %v", q.Subtotal, q.NightsTotal())
+			}
+			if q.Total != rub(tc.wantTotal) {
+				t.Errorf("total: got %v, want %v", q.Total, rub(tc.wantTotal))
+			}
+		})
+	}
+}
+
+// rateConverter converts at a fixed rate of 1/100.
+type rateConverter struct{}
+
+func (rateConverter) Convert(m model.Money, to string) (model.Money, string, error) {
+	return model.Money{Amount: m.Amount / 100, Currency: to}, "0.01", nil
+}
+
+func TestQuote_In(t *testing.T) {
+	q, err := NewDefaultEngine().Quote(&model.Apartment{Price: rub(100000)}, nil, monday, monday.AddDate(0, 0, 2))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	same, err := q.In("RUB", rateConverter{})
+	if err != nil || same != q {
+		t.Errorf("same currency: got %v, %v", same, err)
+	}
+
+	converted, err := q.In("USD", rateConverter{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if converted.Total != (model.Money{Amount: 2000, Currency: "USD"}) || converted.Nights[0].Price.Currency != "USD" {
+		t.Errorf("got %+v", converted)
+	}
+	if converted.OriginalTotal == nil || *converted.OriginalTotal != q.Total || converted.ExchangeRate != "0.01" {
+		t.Errorf("original total: got %v, rate %q", converted.OriginalTotal, converted.ExchangeRate)
+	}
+	if q.Currency != "RUB" || q.Nights[0].Price.Currency != "RUB" {
+		t.Error("the original quote was changed")
+	}
+}
@@ -0,0 +1,56 @@
+package pricing
+
+import (
+	"fmt"
+	"github.com/zlyaptica/hotel_service_backend/internal/app/model"
+	"time"
+)
+
+// MinStay rejects stays shorter than the minimum stay of the plan.
+func MinStay(plan *model.RatePlan, q *Quote) error {
+	if len(q.Nights) < plan.MinStay {
+		return fmt.Errorf("%w of %d nights", ErrMinStay, plan.MinStay)
+	}
+	return nil
+}
+
+// Seasons sets the rate of every night falling into a season to the seasonal
+// price. When seasons overlap the first one listed wins.
+func Seasons(plan *model.RatePlan, q *Quote) error {
+	for i := range q.Nights {
+		n := &q.Nights[i]
+		for _, season := range plan.Seasons {
+			if !n.Date.Before(season.DateFrom) && !n.Date.After(season.DateTo) {
+				n.Rate = season.Price
+				break
+			}
+		}
+		n.Price = n.Rate.Add(n.Surcharge)
+	}
+	return nil
+}
+
+// WeekendSurcharge adds the weekend surcharge to Friday and Saturday nights.
+func WeekendSurcharge(plan *model.RatePlan, q *Quote) error {
+	for i := range q.Nights {
+		n := &q.Nights[i]
+		if wd := n.Date.Weekday(); wd == time.Friday || wd == time.Saturday {
+			n.Surcharge = n.Rate.Percent(plan.WeekendSurchargePercent)
+		}
+		n.Price = n.Rate.Add(n.Surcharge)
+	}
+	return nil
+}
+
+// StayDiscount takes the discount for the longest length of stay the quote
+// qualifies for off the whole stay.
+func StayDiscount(plan *model.RatePlan, q *Quote) error {
+	best := model.StayDiscount{}
+	for _, d := range plan.StayDiscounts {
+		if len(q.Nights) >= d.MinNights && d.MinNights >= best.MinNights {
+			best = d
+		}
+	}
+	q.Discount = q.NightsTotal().Percent(best.Percent)
+	return nil
+}
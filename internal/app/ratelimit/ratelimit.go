@@ -0,0 +1,85 @@
+// Package ratelimit limits how often clients may call the API using a token
+// bucket per client.
+package ratelimit
+
+import (
+	"math"
+	"sync"
+	"time"
+)
+
+// Limiter lets every client make up to burst requests at once and then one
+// more request each time the bucket refills.
+type Limiter struct {
+	mu        sync.Mutex
+	burst     float64
+	rate      float64 // tokens per second
+	buckets   map[string]*bucket
+	lastPrune time.Time
+}
+
+type bucket struct {
+	tokens  float64
+	updated time.Time
+}
+
+// New returns a limiter allowing requests per period to each client.
+func New(requests int, per time.Duration) *Limiter {
+	return &Limiter{
+		burst:   float64(requests),
+		rate:    float64(requests) / per.Seconds(),
+		buckets: make(map[string]*bucket),
+	}
+}
+
+// Limit returns the number of requests a client may make at once.
+func (l *Limiter) Limit() int {
+	return int(l.burst)
+}
+
+// Allow takes a token from the bucket of the client. It returns whether the
+// request is allowed, how many requests are left and, when it is not
+// allowed, how long to wait for the next token.
+func (l *Limiter) Allow(client string, now time.Time) (bool, int, time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.prune(now)
+
+	b, ok := l.buckets[client]
+	if !ok {
+		b = &bucket{
+			tokens:  l.burst,
+			updated: now,
+		}
+		l.buckets[client] = b
+	}
+	b.tokens = l.refill(b, now)
+	b.updated = now
+
+	if b.tokens < 1 {
+		wait := time.Duration((1 - b.tokens) / l.rate * float64(time.Second))
+		return false, 0, wait
+	}
+	b.tokens--
+	return true, int(math.Floor(b.tokens)), 0
+}
+
+func (l *Limiter) refill(b *bucket, now time.Time) float64 {
+	return math.Min(l.burst, b.tokens+now.Sub(b.updated).Seconds()*l.rate)
+}
+
+// prune forgets clients whose buckets are full again, since a new bucket
+// would be the same. It runs at most once per refill of a whole bucket.
+func (l *Limiter) prune(now time.Time) {
+	interval := time.Duration(l.burst / l.rate * float64(time.Second))
+	if now.Sub(l.lastPrune) < interval {
+		return
+	}
+	l.lastPrune = now
+	for client, b := range l.buckets {
+		if l.refill(b, now) >= l.burst {
+			delete(l.buckets, client)
+		}
+	}
+}
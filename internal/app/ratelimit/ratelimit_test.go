@@ -0,0 +1,52 @@
+package ratelimit
+
+import (
+	"testing"
+	"time"
+)
+
+func TestLimiter_Allow(t *testing.T) {
+	start := time.Date(2030, 1, 1, 12, 0, 0, 0, time.UTC)
+	l := New(3, time.Minute)
+
+	testCases := []struct {
+		name          string
+		client        string
+		at            time.Duration
+		wantAllowed   bool
+		wantRemaining int
+		wantWait      time.Duration
+	}{
+		{name: "first", client: "a", at: 0, wantAllowed: true, wantRemaining: 2},
+		{name: "second", client: "a", at: 0, wantAllowed: true, wantRemaining: 1},
+		{name: "burst used up", client: "a", at: 0, wantAllowed: true, wantRemaining: 0},
+		{name: "denied", client: "a", at: 0, wantWait: 20 * time.Second},
+		{name: "other client", client: "b", at: 0, wantAllowed: true, wantRemaining: 2},
+		{name: "half a token later", client: "a", at: 10 * time.Second, wantWait: 10 * time.Second},
+		{name: "refilled token", client: "a", at: 20 * time.Second, wantAllowed: true, wantRemaining: 0},
+		{name: "full bucket after the period", client: "a", at: 2 * time.Minute, wantAllowed: true, wantRemaining: 2},
+	}
+
+	for _, tc := range testCases {
+		allowed, remaining, wait := l.Allow(tc.client, start.Add(tc.at))
+		if allowed != tc.wantAllowed || remaining != tc.wantRemaining || wait != tc.wantWait {
+			t.Errorf("%s: got %v, %d, %v, want %v, %d, %v", tc.name, allowed, remaining, wait, tc.wantAllowed, tc.wantRemaining, tc.wantWait)
+		}
+	}
+}
+
+func TestLimiter_Prune(t *testing.T) {
+	start := time.Date(2030, 1, 1, 12, 0, 0, 0, time.UTC)
+	l := New(2, time.Minute)
+	l.Allow("a", start)
+	l.Allow("b", start.Add(50*time.Second))
+
+	// a is full again after a minute, b isn't.
+	l.Allow("c", start.Add(time.Minute))
+	if _, ok := l.buckets["a"]; ok {
+		t.Error("full bucket was kept")
+	}
+	if _, ok := l.buckets["b"]; !ok {
+		t.Error("bucket in use was pruned")
+	}
+}
@@ -0,0 +1,61 @@
+// Package sms delivers text messages, such as one-time login codes, to
+// phone numbers.
+package sms
+
+import (
+	"fmt"
+	"github.com/sirupsen/logrus"
+	"os"
+	"sync"
+	"time"
+)
+
+// Sender delivers a text message to a phone number.
+type Sender interface {
+	Send(phoneNumber, text string) error
+}
+
+// LogSender writes messages to the log instead of sending them. It is meant
+// for local development.
+type LogSender struct {
+	logger *logrus.Logger
+}
+
+func NewLogSender(logger *logrus.Logger) *LogSender {
+	return &LogSender{
+		logger: logger,
+	}
+}
+
+func (s *LogSender) Send(phoneNumber, text string) error {
+	s.logger.WithField("phone_number", phoneNumber).Infof("sms: %s", text)
+	return nil
+}
+
+// FileSender appends messages to a file instead of sending them, so tests
+// and local scripts can read the codes back.
+type FileSender struct {
+	mu   sync.Mutex
+	path string
+}
+
+func NewFileSender(path string) *FileSender {
+	return &FileSender{
+		path: path,
+	}
+}
+
+func (s *FileSender) Send(phoneNumber, text string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	f, err := os.OpenFile(s.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	if _, err := fmt.Fprintf(f, "%s\t%s\t%s\n", time.Now().Format(time.RFC3339), phoneNumber, text); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
@@ -0,0 +1,94 @@
+// Package token issues and verifies short-lived signed access tokens in the
+// JWT format, signed with HMAC-SHA256.
+package token
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	ErrInvalidToken = errors.New("invalid token")
+	ErrExpiredToken = errors.New("token expired")
+)
+
+// header is the only JWT header tokens are signed with.
+var header = base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"HS256","typ":"JWT"}`))
+
+type claims struct {
+	Subject   string `json:"sub"`
+	IssuedAt  int64  `json:"iat"`
+	ExpiresAt int64  `json:"exp"`
+}
+
+// Signer signs access tokens of users, each valid for ttl.
+type Signer struct {
+	key []byte
+	ttl time.Duration
+}
+
+func NewSigner(key []byte, ttl time.Duration) *Signer {
+	return &Signer{
+		key: key,
+		ttl: ttl,
+	}
+}
+
+// TTL returns how long issued tokens are valid.
+func (s *Signer) TTL() time.Duration {
+	return s.ttl
+}
+
+// Sign returns an access token of the user issued at now.
+func (s *Signer) Sign(userID int, now time.Time) (string, error) {
+	payload, err := json.Marshal(&claims{
+		Subject:   strconv.Itoa(userID),
+		IssuedAt:  now.Unix(),
+		ExpiresAt: now.Add(s.ttl).Unix(),
+	})
+	if err != nil {
+		return "", err
+	}
+	unsigned := header + "." + base64.RawURLEncoding.EncodeToString(payload)
+	return unsigned + "." + s.signature(unsigned), nil
+}
+
+// Verify checks the token at now and returns the user it was issued to.
+func (s *Signer) Verify(token string, now time.Time) (int, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 || parts[0] != header {
+		return 0, ErrInvalidToken
+	}
+	if !hmac.Equal([]byte(parts[2]), []byte(s.signature(parts[0]+"."+parts[1]))) {
+		return 0, ErrInvalidToken
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return 0, ErrInvalidToken
+	}
+	c := &claims{}
+	if err := json.Unmarshal(payload, c); err != nil {
+		return 0, ErrInvalidToken
+	}
+	if now.Unix() >= c.ExpiresAt {
+		return 0, ErrExpiredToken
+	}
+	userID, err := strconv.Atoi(c.Subject)
+	if err != nil {
+		return 0, ErrInvalidToken
+	}
+	return userID, nil
+}
+
+func (s *Signer) signature(unsigned string) string {
+	mac := hmac.New(sha256.New, s.key)
+	mac.Write([]byte(unsigned))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
@@ -0,0 +1,58 @@
+package token
+
+import (
+	"encoding/base64"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSigner_Verify(t *testing.T) {
+	now := time.Date(2030, 1, 1, 12, 0, 0, 0, time.UTC)
+	s := NewSigner([]byte("secret"), 15*time.Minute)
+	token, err := s.Sign(42, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	parts := strings.Split(token, ".")
+	forged := base64.RawURLEncoding.EncodeToString([]byte(`{"sub":"1","iat":1893499200,"exp":1893500100}`))
+
+	testCases := []struct {
+		name    string
+		token   string
+		now     time.Time
+		want    int
+		wantErr error
+	}{
+		{name: "valid", token: token, now: now, want: 42},
+		{name: "just before expiry", token: token, now: now.Add(15*time.Minute - time.Second), want: 42},
+		{name: "expired", token: token, now: now.Add(15 * time.Minute), wantErr: ErrExpiredToken},
+		{name: "other key", token: mustSign(t, NewSigner([]byte("other"), time.Hour), now), now: now, wantErr: ErrInvalidToken},
+		{name: "tampered payload", token: parts[0] + "." + forged + "." + parts[2], now: now, wantErr: ErrInvalidToken},
+		{name: "tampered signature", token: parts[0] + "." + parts[1] + "." + parts[2][1:], now: now, wantErr: ErrInvalidToken},
+		{name: "other algorithm", token: base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"none","typ":"JWT"}`)) + "." + parts[1] + ".", now: now, wantErr: ErrInvalidToken},
+		{name: "missing signature", token: parts[0] + "." + parts[1], now: now, wantErr: ErrInvalidToken},
+		{name: "empty", token: "", now: now, wantErr: ErrInvalidToken},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			userID, err := s.Verify(tc.token, tc.now)
+			if err != tc.wantErr {
+				t.Fatalf("got error %v, want %v", err, tc.wantErr)
+			}
+			if userID != tc.want {
+				t.Errorf("got user %d, want %d", userID, tc.want)
+			}
+		})
+	}
+}
+
+func mustSign(t *testing.T, s *Signer, now time.Time) string {
+	t.Helper()
+	token, err := s.Sign(42, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return token
+}
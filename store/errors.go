@@ -2,6 +2,75 @@ package store
 
 import "errors"
 
+// Kind tells what went wrong in a store, so callers can react without
+// knowing the database behind it.
+type Kind int
+
+const (
+	KindInternal Kind = iota
+	// KindNotFound means the record doesn't exist.
+	KindNotFound
+	// KindConflict means the change contradicts the current state of the
+	// records, like booking taken dates.
+	KindConflict
+	// KindValidation means the record is not acceptable, like one
+	// referring to a missing record.
+	KindValidation
+	// KindUnavailable means the store can't be reached for now.
+	KindUnavailable
+	// KindTimeout means the store didn't answer before the deadline of the
+	// context.
+	KindTimeout
+	// KindCanceled means the context was cancelled before the store
+	// answered, like when the client went away.
+	KindCanceled
+)
+
+// Error is an error of a store. Code is a machine-readable name of the
+// error and Err is the cause, if any.
+type Error struct {
+	Kind    Kind
+	Code    string
+	Message string
+	Err     error
+}
+
+func (e *Error) Error() string {
+	return e.Message
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// KindOf returns the kind of err, KindInternal for errors not of a store.
+func KindOf(err error) Kind {
+	var e *Error
+	if errors.As(err, &e) {
+		return e.Kind
+	}
+	return KindInternal
+}
+
 var (
-	ErrRecordNotFound = errors.New("record not found")
+	ErrRecordNotFound = &Error{
+		Kind:    KindNotFound,
+		Code:    "not_found",
+		Message: "record not found",
+	}
+	ErrApartmentUnavailable = &Error{
+		Kind:    KindConflict,
+		Code:    "apartment_unavailable",
+		Message: "apartment is already booked for these dates",
+	}
+	ErrTransactCancelled = &Error{
+		Kind:    KindConflict,
+		Code:    "transact_cancelled",
+		Message: "transact is cancelled",
+	}
+	ErrUserHasBookings = &Error{
+		Kind:    KindConflict,
+		Code:    "user_has_bookings",
+		Message: "user has bookings",
+	}
 )
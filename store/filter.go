@@ -0,0 +1,37 @@
+package store
+
+import (
+	"math/big"
+	"time"
+)
+
+// HotelFilter narrows down the hotels returned by HotelRepository.FindAll.
+// Zero fields don't filter. The apartment conditions (bed count, class,
+// price and dates) must all hold for the same apartment of a hotel.
+type HotelFilter struct {
+	Country          string
+	City             string
+	MinStars         int
+	MaxStars         int
+	MinBedCount      int
+	ApartmentClassID int
+	// PriceRanges bound the nightly price of an apartment. Since every
+	// hotel prices in its own currency, there is one range per currency and
+	// a hotel matches the range of its currency only.
+	PriceRanges []PriceRange
+	// PriceRates order hotels by price in a single currency: the minimum
+	// price of a hotel, in minor units of its currency, is multiplied by the
+	// rate of that currency. Hotels pricing in a currency without a rate, and
+	// hotels without apartments, come last.
+	PriceRates    map[string]*big.Rat
+	DateArrival   time.Time
+	DateDeparture time.Time
+}
+
+// PriceRange bounds amounts in the minor units of Currency. A nil bound
+// doesn't limit.
+type PriceRange struct {
+	Currency string
+	Min      *int64
+	Max      *int64
+}
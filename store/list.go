@@ -0,0 +1,24 @@
+package store
+
+import "errors"
+
+const (
+	DefaultLimit = 20
+	MaxLimit     = 100
+)
+
+var (
+	ErrInvalidCursor = errors.New("invalid cursor")
+	ErrInvalidSort   = errors.New("invalid sort")
+)
+
+// ListOptions selects a page of a list. Cursor is the next cursor returned
+// with the previous page and is empty for the first page. Sort names one of
+// the sort keys of the list, the empty string being the default order of
+// the repository.
+type ListOptions struct {
+	Limit  int
+	Cursor string
+	Sort   string
+	Desc   bool
+}
@@ -1,43 +1,86 @@
 package store
 
 import (
-	"encoding/json"
+	"context"
 	"github.com/zlyaptica/hotel_service_backend/internal/app/model"
+	"time"
 )
 
-type AddressRepository interface{}
+type AddressRepository interface {
+	Create(ctx context.Context, a *model.Address) error
+	Update(ctx context.Context, a *model.Address) error
+}
 
 type ApartmentClassRepository interface { // типа сделал
-	FindAll() ([]model.ApartmentClass, error)
+	FindAll(ctx context.Context, opts *ListOptions) ([]model.ApartmentClass, string, error)
 }
 
 type ApartmentRepository interface {
-	Create(bedCount, price, apartmentClassID, hotelID json.Number, name string) error
-	GetPriceApartment(id int) (int, error)
-	FillRoom(id int) error
-	FindByHotelID(id int) ([]model.Apartment, error)
+	Create(ctx context.Context, a *model.Apartment) error
+	Find(ctx context.Context, id int) (*model.Apartment, error)
+	FindByHotelID(ctx context.Context, id int, opts *ListOptions) ([]model.Apartment, string, error)
+	FindAvailableByHotelID(ctx context.Context, id int, arrival, departure time.Time, opts *ListOptions) ([]model.Apartment, string, error)
 }
 
 type UserRepository interface {
-	Create(user *model.User) error
-	Delete(phoneNumber string) error
-	//Find(id int) (*model.User, error)
-	//FindByPhone(string) (*model.User, error)
+	Create(ctx context.Context, user *model.User) error
+	// Delete deletes the user with the phone number, failing with
+	// ErrUserHasBookings while any booking refers to them.
+	Delete(ctx context.Context, phoneNumber string) error
+	Find(ctx context.Context, id int) (*model.User, error)
+	FindByPhone(ctx context.Context, phoneNumber string) (*model.User, error)
+	SetRole(ctx context.Context, u *model.User) error
 }
 
 type HotelRepository interface {
-	Create(hotel *model.Hotel) error
-	Update(hotel *model.Hotel) error
-	FindAll() ([]model.Hotel, error)
-	Find(id int) (*model.Hotel, error)
+	Create(ctx context.Context, hotel *model.Hotel) error
+	Update(ctx context.Context, hotel *model.Hotel) error
+	FindAll(ctx context.Context, f *HotelFilter, opts *ListOptions) ([]model.Hotel, string, error)
+	Search(ctx context.Context, text string, opts *ListOptions) ([]model.HotelSearchResult, string, error)
+	Find(ctx context.Context, id int) (*model.Hotel, error)
 }
 
 type ApartmentImageRepository interface {
-	GetImagesByHotelID(id int) ([]model.ApartmentImage, error)
+	GetImagesByHotelID(ctx context.Context, id int) ([]model.ApartmentImage, error)
 }
 
 type TransactRepository interface {
-	Create(t *model.Transact) error
-	CreateTransact(t *model.Transact) error
-	FindTransactsByPhoneNumber(phoneNumber string) ([]model.Transact, error)
+	// Create books the stay under the current cancellation policy of the
+	// hotel, which is set on t and kept with the stay.
+	Create(ctx context.Context, t *model.Transact) error
+	Find(ctx context.Context, id int) (*model.Transact, error)
+	Cancel(ctx context.Context, t *model.Transact) error
+	Update(ctx context.Context, t *model.Transact) error
+	History(ctx context.Context, id int) ([]model.TransactVersion, error)
+	FindTransactsByPhoneNumber(ctx context.Context, phoneNumber string, opts *ListOptions) ([]model.Transact, string, error)
+}
+
+type RatePlanRepository interface {
+	Find(ctx context.Context, apartmentID int) (*model.RatePlan, error)
+	Save(ctx context.Context, p *model.RatePlan) error
+}
+
+type OTPRepository interface {
+	Create(ctx context.Context, o *model.OTP) error
+	FindLatest(ctx context.Context, phoneNumber string) (*model.OTP, error)
+	IncrementAttempts(ctx context.Context, id, max int) error
+	CountAttempts(ctx context.Context, phoneNumber string, since time.Time) (int, error)
+	DeleteByPhoneNumber(ctx context.Context, phoneNumber string, before time.Time) error
+}
+
+type APIKeyRepository interface {
+	Create(ctx context.Context, k *model.APIKey) error
+	Find(ctx context.Context, id int) (*model.APIKey, error)
+	FindByHash(ctx context.Context, hash string) (*model.APIKey, error)
+	FindAll(ctx context.Context) ([]model.APIKey, error)
+	Rotate(ctx context.Context, k *model.APIKey) error
+	Revoke(ctx context.Context, id int, at time.Time) error
+	Touch(ctx context.Context, id int, at time.Time) error
+}
+
+type RefreshTokenRepository interface {
+	Create(ctx context.Context, t *model.RefreshToken) error
+	FindByHash(ctx context.Context, hash string) (*model.RefreshToken, error)
+	Revoke(ctx context.Context, id int, at time.Time) error
+	RevokeByUserID(ctx context.Context, userID int, at time.Time) error
 }
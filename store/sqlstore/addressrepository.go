@@ -1,5 +1,51 @@
 package sqlstore
 
+import (
+	"context"
+	"github.com/zlyaptica/hotel_service_backend/internal/app/model"
+	"github.com/zlyaptica/hotel_service_backend/store"
+)
+
 type AddressRepository struct {
 	store *Store
 }
+
+func (r *AddressRepository) Create(ctx context.Context, a *model.Address) error {
+	ctx, cancel := r.store.withTimeout(ctx)
+	defer cancel()
+
+	q := `INSERT INTO address (country, city, street, house) VALUES ($1, $2, $3, $4) RETURNING id`
+	return storeError(ctx, r.store.conn().QueryRowContext(ctx,
+		q,
+		a.Country,
+		a.City,
+		a.Street,
+		a.House,
+	).Scan(&a.ID))
+}
+
+func (r *AddressRepository) Update(ctx context.Context, a *model.Address) error {
+	ctx, cancel := r.store.withTimeout(ctx)
+	defer cancel()
+
+	q := `UPDATE address SET (country, city, street, house) = ($1, $2, $3, $4) WHERE id = $5`
+	result, err := r.store.conn().ExecContext(ctx,
+		q,
+		a.Country,
+		a.City,
+		a.Street,
+		a.House,
+		a.ID,
+	)
+	if err != nil {
+		return storeError(ctx, err)
+	}
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return storeError(ctx, err)
+	}
+	if rows != 1 {
+		return store.ErrRecordNotFound
+	}
+	return nil
+}
@@ -1,7 +1,7 @@
 package sqlstore
 
 import (
-	"database/sql"
+	"context"
 	"github.com/zlyaptica/hotel_service_backend/internal/app/model"
 	"github.com/zlyaptica/hotel_service_backend/store"
 )
@@ -10,30 +10,48 @@ type ApartmentClassRepository struct {
 	store *Store
 }
 
-func (r ApartmentClassRepository) FindAll() ([]model.ApartmentClass, error) {
-	apartmentClasses := []model.ApartmentClass{}
-	q := `SELECT id, class FROM apartment_classes`
-	rows, err := r.store.db.Query(q)
-	defer rows.Close()
+var apartmentClassSortKeys = map[string]sortKey{
+	"id":    {"id", "bigint"},
+	"class": {"class", "text"},
+}
 
+func (r ApartmentClassRepository) FindAll(ctx context.Context, opts *store.ListOptions) ([]model.ApartmentClass, string, error) {
+	ctx, cancel := r.store.withTimeout(ctx)
+	defer cancel()
+
+	p, err := newPage(opts, apartmentClassSortKeys, "id", "id")
 	if err != nil {
-		if err == sql.ErrNoRows {
-			return nil, store.ErrRecordNotFound
-		}
-		return nil, err
+		return nil, "", storeError(ctx, err)
 	}
 
+	apartmentClasses := []model.ApartmentClass{}
+	b := &queryBuilder{}
+	q := `SELECT id, class, ` + p.column() + ` FROM apartment_classes WHERE ` + p.cond(b) + ` ` + p.orderBy(b)
+	rows, err := r.store.conn().QueryContext(ctx, q, b.args...)
+	if err != nil {
+		return nil, "", storeError(ctx, err)
+	}
+	defer rows.Close()
+
+	values, ids := []string{}, []int{}
 	for rows.Next() {
+		var value string
 		ac := model.ApartmentClass{}
 		err := rows.Scan(
 			&ac.ID,
 			&ac.Class,
+			&value,
 		)
 		if err != nil {
-			return nil, err
+			return nil, "", storeError(ctx, err)
 		}
 
 		apartmentClasses = append(apartmentClasses, ac)
+		values, ids = append(values, value), append(ids, ac.ID)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, "", storeError(ctx, err)
 	}
-	return apartmentClasses, nil
+	n, next := p.next(values, ids)
+	return apartmentClasses[:n], next, nil
 }
@@ -1,27 +1,25 @@
 package sqlstore
 
 import (
-	"database/sql"
+	"context"
 	"github.com/zlyaptica/hotel_service_backend/internal/app/model"
-	"github.com/zlyaptica/hotel_service_backend/store"
 )
 
 type ApartmentImageRepository struct {
 	store *Store
 }
 
-func (r ApartmentImageRepository) GetImagesByHotelID(id int) ([]model.ApartmentImage, error) {
+func (r ApartmentImageRepository) GetImagesByHotelID(ctx context.Context, id int) ([]model.ApartmentImage, error) {
+	ctx, cancel := r.store.withTimeout(ctx)
+	defer cancel()
+
 	images := []model.ApartmentImage{}
 	q := `SELECT id, hotel_id, address FROM apartment_images WHERE hotel_id = $1`
-	rows, err := r.store.db.Query(q, id)
-	defer rows.Close()
-
+	rows, err := r.store.conn().QueryContext(ctx, q, id)
 	if err != nil {
-		if err == sql.ErrNoRows {
-			return nil, store.ErrRecordNotFound
-		}
-		return nil, err
+		return nil, storeError(ctx, err)
 	}
+	defer rows.Close()
 
 	for rows.Next() {
 		a := &model.Apartment{}
@@ -34,9 +32,9 @@ func (r ApartmentImageRepository) GetImagesByHotelID(id int) ([]model.ApartmentI
 			&i.Address,
 		)
 		if err != nil {
-			return nil, err
+			return nil, storeError(ctx, err)
 		}
 		images = append(images, i)
 	}
-	return images, nil
+	return images, rows.Err()
 }
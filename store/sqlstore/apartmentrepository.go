@@ -1,48 +1,49 @@
 package sqlstore
 
 import (
+	"context"
 	"database/sql"
-	"encoding/json"
 	"github.com/zlyaptica/hotel_service_backend/internal/app/model"
 	"github.com/zlyaptica/hotel_service_backend/store"
+	"time"
 )
 
 type ApartmentRepository struct {
 	store *Store
 }
 
-func (r ApartmentRepository) Create(bedCount, price, apartmentClassID, hotelID json.Number, name string) error {
-	a := &model.Apartment{}
-	q := `INSERT INTO apartments (hotel_id, is_free, bed_count, price, apartment_class_id, name) VALUES ($1, $2, $3, $4, $5, $6) RETURNING id`
+func (r ApartmentRepository) Create(ctx context.Context, a *model.Apartment) error {
+	ctx, cancel := r.store.withTimeout(ctx)
+	defer cancel()
 
-	return r.store.db.QueryRow(
+	q := `INSERT INTO apartments (hotel_id, bed_count, price, apartment_class_id, name) VALUES ($1, $2, $3, $4, $5) RETURNING id`
+
+	return storeError(ctx, r.store.conn().QueryRowContext(ctx,
 		q,
-		hotelID,
-		true,
-		bedCount,
-		price,
-		apartmentClassID,
-		name,
-	).Scan(&a.ID)
+		a.Hotel.ID,
+		a.BedCount,
+		a.Price.Amount,
+		a.ApartmentClass.ID,
+		a.Name,
+	).Scan(&a.ID))
 }
 
-func (r ApartmentRepository) FindAll() ([]model.Apartment, error) {
+func (r ApartmentRepository) FindAll(ctx context.Context) ([]model.Apartment, error) {
+	ctx, cancel := r.store.withTimeout(ctx)
+	defer cancel()
+
 	apartments := []model.Apartment{}
-	q := `SELECT a.id, h.id, adr.id, ac.id, a.is_free, a.bed_count, a.price, ac.class, h.name, 
+	q := `SELECT a.id, h.id, adr.id, ac.id, a.bed_count, a.price, h.currency, ac.class, h.name, 
                  h.stars_count, adr.country, adr.city, adr.street, adr.house
 		  FROM apartments a
           INNER JOIN hotels h ON a.hotel_id = h.id
           INNER JOIN address adr ON h.address_id = adr.id
           INNER JOIN apartment_classes ac ON a.apartment_class_id = ac.id`
-	rows, err := r.store.db.Query(q)
-	defer rows.Close()
-
+	rows, err := r.store.conn().QueryContext(ctx, q)
 	if err != nil {
-		if err == sql.ErrNoRows {
-			return nil, store.ErrRecordNotFound
-		}
-		return nil, err
+		return nil, storeError(ctx, err)
 	}
+	defer rows.Close()
 
 	for rows.Next() {
 		ac := &model.ApartmentClass{}
@@ -59,9 +60,9 @@ func (r ApartmentRepository) FindAll() ([]model.Apartment, error) {
 			&a.Hotel.ID,
 			&a.Hotel.Address.ID,
 			&a.ApartmentClass.ID,
-			&a.IsFree,
 			&a.BedCount,
-			&a.Price,
+			&a.Price.Amount,
+			&a.Price.Currency,
 			&a.ApartmentClass.Class,
 			&a.Hotel.Name,
 			&a.Hotel.StarsCount,
@@ -72,52 +73,104 @@ func (r ApartmentRepository) FindAll() ([]model.Apartment, error) {
 		)
 
 		if err != nil {
-			return nil, err
+			return nil, storeError(ctx, err)
 		}
 		apartments = append(apartments, a)
 	}
-	return apartments, nil
+	return apartments, rows.Err()
 }
 
-func (r ApartmentRepository) GetPriceApartment(id int) (int, error) {
-	var price int
-	q := `SELECT price FROM apartments WHERE id = $1`
-	if err := r.store.db.QueryRow(q, id).Scan(
-		&price,
+func (r ApartmentRepository) Find(ctx context.Context, id int) (*model.Apartment, error) {
+	ctx, cancel := r.store.withTimeout(ctx)
+	defer cancel()
+
+	ac := &model.ApartmentClass{}
+	h := &model.Hotel{}
+	a := &model.Apartment{
+		Hotel:          h,
+		ApartmentClass: ac,
+	}
+	q := `SELECT a.id, a.hotel_id, a.bed_count, a.price, h.currency, ac.id, ac.class, a.name FROM apartments a
+			INNER JOIN apartment_classes ac on ac.id = a.apartment_class_id
+			INNER JOIN hotels h on h.id = a.hotel_id
+			WHERE a.id = $1`
+	if err := r.store.conn().QueryRowContext(ctx, q, id).Scan(
+		&a.ID,
+		&a.Hotel.ID,
+		&a.BedCount,
+		&a.Price.Amount,
+		&a.Price.Currency,
+		&a.ApartmentClass.ID,
+		&a.ApartmentClass.Class,
+		&a.Name,
 	); err != nil {
-		return 0, err
+		if err == sql.ErrNoRows {
+			return nil, store.ErrRecordNotFound
+		}
+		return nil, storeError(ctx, err)
 	}
-	return price, nil
+	return a, nil
+}
+
+var apartmentSortKeys = map[string]sortKey{
+	"id":        {"a.id", "bigint"},
+	"price":     {"a.price", "bigint"},
+	"bed_count": {"a.bed_count", "bigint"},
+	"name":      {"a.name", "text"},
 }
 
-func (r ApartmentRepository) FillRoom(id int) error {
-	q := `UPDATE apartments SET is_free = false WHERE id = $1`
-	_, err := r.store.db.Exec(q, id)
+func (r ApartmentRepository) FindByHotelID(ctx context.Context, id int, opts *store.ListOptions) ([]model.Apartment, string, error) {
+	ctx, cancel := r.store.withTimeout(ctx)
+	defer cancel()
+
+	b := &queryBuilder{}
+	return r.find(ctx, b, b.cond("a.hotel_id = %s", id), opts)
+}
+
+// FindAvailableByHotelID returns the apartments of the hotel that have no active
+// stay overlapping the [arrival, departure) range. Stays are half-open, so a guest
+// may arrive on the day the previous one departs.
+func (r ApartmentRepository) FindAvailableByHotelID(ctx context.Context, id int, arrival, departure time.Time, opts *store.ListOptions) ([]model.Apartment, string, error) {
+	ctx, cancel := r.store.withTimeout(ctx)
+	defer cancel()
+
+	b := &queryBuilder{}
+	cond := b.cond(
+		`a.hotel_id = %s AND NOT EXISTS (
+			SELECT 1 FROM transact t
+			WHERE t.apartment_id = a.id AND t.status = %s AND t.date_arrival < %s AND t.date_departure > %s
+		)`,
+		id,
+		model.TransactStatusActive,
+		departure,
+		arrival,
+	)
+	return r.find(ctx, b, cond, opts)
+}
+
+// find returns a page of the apartments matching cond, whose arguments are
+// already added to b.
+func (r ApartmentRepository) find(ctx context.Context, b *queryBuilder, cond string, opts *store.ListOptions) ([]model.Apartment, string, error) {
+	p, err := newPage(opts, apartmentSortKeys, "id", "a.id")
 	if err != nil {
-		if err == sql.ErrNoRows {
-			return store.ErrRecordNotFound
-		}
-		return err
+		return nil, "", storeError(ctx, err)
 	}
-	return nil
-}
 
-func (r ApartmentRepository) FindByHotelID(id int) ([]model.Apartment, error) {
 	apartments := []model.Apartment{}
-	q := `SELECT a.id, a.hotel_id, a.is_free, a.bed_count, a.price, ac.class, a.name FROM apartments a
+	q := `SELECT a.id, a.hotel_id, a.bed_count, a.price, h.currency, ac.class, a.name, ` + p.column() + ` FROM apartments a
 			INNER JOIN apartment_classes ac on ac.id = a.apartment_class_id
-			WHERE hotel_id = $1 AND is_free = true`
-	rows, err := r.store.db.Query(q, id)
-	defer rows.Close()
-
+			INNER JOIN hotels h on h.id = a.hotel_id
+			WHERE ` + cond + ` AND ` + p.cond(b) + `
+			` + p.orderBy(b)
+	rows, err := r.store.conn().QueryContext(ctx, q, b.args...)
 	if err != nil {
-		if err == sql.ErrNoRows {
-			return nil, store.ErrRecordNotFound
-		}
-		return nil, err
+		return nil, "", storeError(ctx, err)
 	}
+	defer rows.Close()
 
+	values, ids := []string{}, []int{}
 	for rows.Next() {
+		var value string
 		ac := &model.ApartmentClass{}
 		h := &model.Hotel{}
 		a := model.Apartment{
@@ -127,16 +180,22 @@ func (r ApartmentRepository) FindByHotelID(id int) ([]model.Apartment, error) {
 		err := rows.Scan(
 			&a.ID,
 			&a.Hotel.ID,
-			&a.IsFree,
 			&a.BedCount,
-			&a.Price,
+			&a.Price.Amount,
+			&a.Price.Currency,
 			&a.ApartmentClass.Class,
 			&a.Name,
+			&value,
 		)
 		if err != nil {
-			return nil, err
+			return nil, "", storeError(ctx, err)
 		}
 		apartments = append(apartments, a)
+		values, ids = append(values, value), append(ids, a.ID)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, "", storeError(ctx, err)
 	}
-	return apartments, nil
+	n, next := p.next(values, ids)
+	return apartments[:n], next, nil
 }
@@ -0,0 +1,171 @@
+package sqlstore
+
+import (
+	"context"
+	"database/sql"
+	"github.com/zlyaptica/hotel_service_backend/internal/app/model"
+	"github.com/zlyaptica/hotel_service_backend/store"
+	"time"
+)
+
+type APIKeyRepository struct {
+	store *Store
+}
+
+func (r *APIKeyRepository) Create(ctx context.Context, k *model.APIKey) error {
+	ctx, cancel := r.store.withTimeout(ctx)
+	defer cancel()
+
+	return r.store.inTx(ctx, func(tx *sql.Tx) error {
+		q := `INSERT INTO api_keys (name, prefix, key_hash, role, created_at) VALUES ($1, $2, $3, $4, $5) RETURNING id`
+		if err := tx.QueryRowContext(ctx,
+			q,
+			k.Name,
+			k.Prefix,
+			k.KeyHash,
+			k.Role,
+			k.CreatedAt,
+		).Scan(&k.ID); err != nil {
+			return storeError(ctx, err)
+		}
+		for _, id := range k.HotelIDs {
+			if _, err := tx.ExecContext(ctx, `INSERT INTO api_key_hotels (api_key_id, hotel_id) VALUES ($1, $2)`, k.ID, id); err != nil {
+				return storeError(ctx, err)
+			}
+		}
+		return nil
+	})
+}
+
+const apiKeyColumns = `id, name, prefix, key_hash, role, created_at, last_used_at, revoked_at`
+
+func (r *APIKeyRepository) Find(ctx context.Context, id int) (*model.APIKey, error) {
+	ctx, cancel := r.store.withTimeout(ctx)
+	defer cancel()
+
+	return r.find(ctx, `SELECT `+apiKeyColumns+` FROM api_keys WHERE id = $1`, id)
+}
+
+// FindByHash returns the key with the hash, revoked or not.
+func (r *APIKeyRepository) FindByHash(ctx context.Context, hash string) (*model.APIKey, error) {
+	ctx, cancel := r.store.withTimeout(ctx)
+	defer cancel()
+
+	return r.find(ctx, `SELECT `+apiKeyColumns+` FROM api_keys WHERE key_hash = $1`, hash)
+}
+
+func (r *APIKeyRepository) find(ctx context.Context, q string, arg interface{}) (*model.APIKey, error) {
+	k := &model.APIKey{}
+	if err := scanAPIKey(r.store.conn().QueryRowContext(ctx, q, arg), k); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, store.ErrRecordNotFound
+		}
+		return nil, storeError(ctx, err)
+	}
+	if err := r.loadHotelIDs(ctx, k); err != nil {
+		return nil, storeError(ctx, err)
+	}
+	return k, nil
+}
+
+func (r *APIKeyRepository) FindAll(ctx context.Context) ([]model.APIKey, error) {
+	ctx, cancel := r.store.withTimeout(ctx)
+	defer cancel()
+
+	keys := []model.APIKey{}
+	rows, err := r.store.conn().QueryContext(ctx, `SELECT `+apiKeyColumns+` FROM api_keys ORDER BY id`)
+	if err != nil {
+		return nil, storeError(ctx, err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		k := model.APIKey{}
+		if err := scanAPIKey(rows, &k); err != nil {
+			return nil, storeError(ctx, err)
+		}
+		keys = append(keys, k)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, storeError(ctx, err)
+	}
+	for i := range keys {
+		if err := r.loadHotelIDs(ctx, &keys[i]); err != nil {
+			return nil, storeError(ctx, err)
+		}
+	}
+	return keys, nil
+}
+
+// Rotate replaces the secret of an active key with k.Prefix and k.KeyHash.
+func (r *APIKeyRepository) Rotate(ctx context.Context, k *model.APIKey) error {
+	ctx, cancel := r.store.withTimeout(ctx)
+	defer cancel()
+
+	q := `UPDATE api_keys SET (prefix, key_hash) = ($1, $2) WHERE id = $3 AND revoked_at IS NULL`
+	return r.exec(ctx, q, k.Prefix, k.KeyHash, k.ID)
+}
+
+// Revoke disables an active key for good.
+func (r *APIKeyRepository) Revoke(ctx context.Context, id int, at time.Time) error {
+	ctx, cancel := r.store.withTimeout(ctx)
+	defer cancel()
+
+	return r.exec(ctx, `UPDATE api_keys SET revoked_at = $1 WHERE id = $2 AND revoked_at IS NULL`, at, id)
+}
+
+// Touch records that the key was used at the given time.
+func (r *APIKeyRepository) Touch(ctx context.Context, id int, at time.Time) error {
+	ctx, cancel := r.store.withTimeout(ctx)
+	defer cancel()
+
+	_, err := r.store.conn().ExecContext(ctx, `UPDATE api_keys SET last_used_at = $1 WHERE id = $2`, at, id)
+	return storeError(ctx, err)
+}
+
+// exec runs an update of a single key and returns store.ErrRecordNotFound
+// when no active key matched.
+func (r *APIKeyRepository) exec(ctx context.Context, q string, args ...interface{}) error {
+	result, err := r.store.conn().ExecContext(ctx, q, args...)
+	if err != nil {
+		return storeError(ctx, err)
+	}
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return storeError(ctx, err)
+	}
+	if rows != 1 {
+		return store.ErrRecordNotFound
+	}
+	return nil
+}
+
+func (r *APIKeyRepository) loadHotelIDs(ctx context.Context, k *model.APIKey) error {
+	rows, err := r.store.conn().QueryContext(ctx, `SELECT hotel_id FROM api_key_hotels WHERE api_key_id = $1 ORDER BY hotel_id`, k.ID)
+	if err != nil {
+		return storeError(ctx, err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			return storeError(ctx, err)
+		}
+		k.HotelIDs = append(k.HotelIDs, id)
+	}
+	return rows.Err()
+}
+
+func scanAPIKey(row interface{ Scan(...interface{}) error }, k *model.APIKey) error {
+	return row.Scan(
+		&k.ID,
+		&k.Name,
+		&k.Prefix,
+		&k.KeyHash,
+		&k.Role,
+		&k.CreatedAt,
+		&k.LastUsedAt,
+		&k.RevokedAt,
+	)
+}
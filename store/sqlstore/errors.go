@@ -0,0 +1,71 @@
+package sqlstore
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"github.com/lib/pq"
+	"github.com/zlyaptica/hotel_service_backend/store"
+	"net"
+)
+
+// storeError translates PostgreSQL errors into store errors, so no SQL
+// details reach the clients. Queries stopped by the deadline of ctx or by
+// the statement timeout become timeouts, queries whose ctx was cancelled,
+// like by a client gone away, become cancellations. Other errors are
+// returned as they are.
+func storeError(ctx context.Context, err error) error {
+	if err == nil {
+		return nil
+	}
+	var storeErr *store.Error
+	if errors.As(err, &storeErr) {
+		return err
+	}
+	if errors.Is(err, context.DeadlineExceeded) {
+		return errTimeout(err)
+	}
+	if errors.Is(err, context.Canceled) {
+		return errCanceled(err)
+	}
+
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) {
+		if pqErr.Code.Name() == "query_canceled" {
+			// Cancelled by lib/pq when ctx is done, or by the server on
+			// the statement timeout.
+			if ctx.Err() == context.Canceled {
+				return errCanceled(err)
+			}
+			return errTimeout(err)
+		}
+		switch pqErr.Code.Class() {
+		case "23": // integrity constraint violation
+			switch pqErr.Code.Name() {
+			case "unique_violation":
+				return &store.Error{Kind: store.KindConflict, Code: "already_exists", Message: "record already exists", Err: err}
+			case "foreign_key_violation":
+				return &store.Error{Kind: store.KindValidation, Code: "invalid_reference", Message: "referenced record does not exist", Err: err}
+			default:
+				return &store.Error{Kind: store.KindValidation, Code: "constraint_violation", Message: "record violates a constraint", Err: err}
+			}
+		case "08", "53", "57": // connection exception, insufficient resources, operator intervention
+			return &store.Error{Kind: store.KindUnavailable, Code: "unavailable", Message: "database is unavailable", Err: err}
+		}
+		return err
+	}
+
+	var netErr net.Error
+	if errors.Is(err, driver.ErrBadConn) || errors.As(err, &netErr) {
+		return &store.Error{Kind: store.KindUnavailable, Code: "unavailable", Message: "database is unavailable", Err: err}
+	}
+	return err
+}
+
+func errTimeout(err error) error {
+	return &store.Error{Kind: store.KindTimeout, Code: "query_timeout", Message: "query timed out", Err: err}
+}
+
+func errCanceled(err error) error {
+	return &store.Error{Kind: store.KindCanceled, Code: "query_canceled", Message: "query was cancelled", Err: err}
+}
@@ -0,0 +1,39 @@
+package sqlstore
+
+import (
+	"context"
+	"fmt"
+	"github.com/lib/pq"
+	"github.com/zlyaptica/hotel_service_backend/store"
+	"testing"
+	"time"
+)
+
+func TestStoreError_Context(t *testing.T) {
+	expired, cancel := context.WithTimeout(context.Background(), -time.Second)
+	defer cancel()
+	cancelled, cancel := context.WithCancel(context.Background())
+	cancel()
+	queryCanceled := &pq.Error{Code: "57014"}
+
+	testCases := []struct {
+		name string
+		ctx  context.Context
+		err  error
+		want store.Kind
+	}{
+		{name: "deadline", ctx: expired, err: fmt.Errorf("query: %w", context.DeadlineExceeded), want: store.KindTimeout},
+		{name: "cancelled", ctx: cancelled, err: fmt.Errorf("query: %w", context.Canceled), want: store.KindCanceled},
+		{name: "cancelled by lib/pq on the deadline", ctx: expired, err: queryCanceled, want: store.KindTimeout},
+		{name: "cancelled by lib/pq on cancel", ctx: cancelled, err: queryCanceled, want: store.KindCanceled},
+		{name: "statement timeout", ctx: context.Background(), err: queryCanceled, want: store.KindTimeout},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := store.KindOf(storeError(tc.ctx, tc.err)); got != tc.want {
+				t.Errorf("got kind %d, want %d", got, tc.want)
+			}
+		})
+	}
+}
@@ -1,84 +1,146 @@
 package sqlstore
 
 import (
+	"context"
 	"database/sql"
 	"github.com/zlyaptica/hotel_service_backend/internal/app/model"
 	"github.com/zlyaptica/hotel_service_backend/store"
+	"math/big"
+	"sort"
+	"strings"
 )
 
 type HotelRepository struct {
 	store *Store
 }
 
-func (r HotelRepository) Create(hotel *model.Hotel) error {
-	q := `INSERT INTO address (country, city, street, house) VALUES ($1, $2, $3, $4) RETURNING id`
-	var addressID int
-	_ = r.store.db.QueryRow(q,
-		hotel.Address.Country,
-		hotel.Address.City,
-		hotel.Address.Street,
-		hotel.Address.House,
-	).Scan(&addressID)
-	q = `INSERT INTO hotels (name, address_id, stars_count, description, header_image_address) 
-		 VALUES ($1, $2, $3, $4, $5) RETURNING id`
-	return r.store.db.QueryRow(
+// Create inserts the hotel located at the already created hotel.Address.
+func (r HotelRepository) Create(ctx context.Context, hotel *model.Hotel) error {
+	ctx, cancel := r.store.withTimeout(ctx)
+	defer cancel()
+
+	q := `INSERT INTO hotels (name, address_id, stars_count, description, header_image_address,
+		 free_cancellation_days, cancellation_penalty_percent, currency) 
+		 VALUES ($1, $2, $3, $4, $5, $6, $7, $8) RETURNING id`
+	return storeError(ctx, r.store.conn().QueryRowContext(ctx,
 		q,
 		hotel.Name,
-		addressID,
+		hotel.Address.ID,
 		hotel.StarsCount,
 		hotel.Description,
 		hotel.HeaderImageAddress,
-	).Scan(&hotel.ID)
+		hotel.CancellationPolicy.FreeDays,
+		hotel.CancellationPolicy.PenaltyPercent,
+		hotel.Currency,
+	).Scan(&hotel.ID))
 }
 
-func (r HotelRepository) Update(hotel *model.Hotel) error {
-	q := `SELECT address_id FROM hotels WHERE id = $1`
-	var addressID int
-	_ = r.store.db.QueryRow(q, hotel.ID).Scan(&addressID)
-
-	q = `UPDATE address SET (country, city, street, house) = ($1, $2, $3, $4) WHERE id = $5`
-	_, err := r.store.db.Query(
-		q,
-		hotel.Address.Country,
-		hotel.Address.City,
-		hotel.Address.Street,
-		hotel.Address.House,
-		addressID,
-	)
+// Update saves the hotel. Its address is saved by AddressRepository.Update.
+func (r HotelRepository) Update(ctx context.Context, hotel *model.Hotel) error {
+	ctx, cancel := r.store.withTimeout(ctx)
+	defer cancel()
 
-	q = `UPDATE hotels SET (name, stars_count, description, header_image_address) = ($1, $2, $3, $4) WHERE id = $5`
-	_, err = r.store.db.Query(
+	q := `UPDATE hotels SET (name, stars_count, description, header_image_address,
+		 free_cancellation_days, cancellation_penalty_percent, currency) = ($1, $2, $3, $4, $5, $6, $7) WHERE id = $8`
+	result, err := r.store.conn().ExecContext(ctx,
 		q,
 		hotel.Name,
 		hotel.StarsCount,
 		hotel.Description,
 		hotel.HeaderImageAddress,
+		hotel.CancellationPolicy.FreeDays,
+		hotel.CancellationPolicy.PenaltyPercent,
+		hotel.Currency,
 		hotel.ID,
 	)
-	return err
+	if err != nil {
+		return storeError(ctx, err)
+	}
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return storeError(ctx, err)
+	}
+	if rows != 1 {
+		return store.ErrRecordNotFound
+	}
+	return nil
+}
+
+var hotelSortKeys = map[string]sortKey{
+	"id":    {"h.id", "bigint"},
+	"name":  {"h.name", "text"},
+	"stars": {"h.stars_count", "bigint"},
 }
 
-func (r HotelRepository) FindAll() ([]model.Hotel, error) {
+// hotelPriceKey orders hotels by their minimum price converted with the
+// rates of f. Hotels without a price get a value past all prices in the
+// direction of the order: NaN sorts above every number in PostgreSQL and
+// prices are positive.
+func hotelPriceKey(b *queryBuilder, f *store.HotelFilter, desc bool) sortKey {
+	rate := "NULL::numeric"
+	if f != nil && len(f.PriceRates) != 0 {
+		cases := []string{}
+		for _, currency := range sortedCurrencies(f.PriceRates) {
+			cases = append(cases, b.cond("WHEN %s THEN %s::numeric", currency, f.PriceRates[currency].FloatString(12)))
+		}
+		rate = "CASE h.currency " + strings.Join(cases, " ") + " END"
+	}
+	missing := "'NaN'"
+	if desc {
+		missing = "-1"
+	}
+	return sortKey{"COALESCE((SELECT MIN(price) FROM apartments WHERE hotel_id = h.id) * " + rate + ", " + missing + ")", "numeric"}
+}
+
+func sortedCurrencies(rates map[string]*big.Rat) []string {
+	currencies := make([]string, 0, len(rates))
+	for c := range rates {
+		currencies = append(currencies, c)
+	}
+	sort.Strings(currencies)
+	return currencies
+}
+
+func (r HotelRepository) FindAll(ctx context.Context, f *store.HotelFilter, opts *store.ListOptions) ([]model.Hotel, string, error) {
+	ctx, cancel := r.store.withTimeout(ctx)
+	defer cancel()
+
 	hotels := []model.Hotel{} // массив структур
 
-	q := `SELECT h.id, a.id, h.name, h.description, h.header_image_address, h.stars_count, a.country, a.city, a.street, a.house 
+	b := &queryBuilder{}
+	keys := hotelSortKeys
+	if opts != nil && opts.Sort == "price" {
+		// The rates are arguments, so the key is only built when used.
+		keys = map[string]sortKey{
+			"price": hotelPriceKey(b, f, opts.Desc),
+		}
+	}
+	p, err := newPage(opts, keys, "id", "h.id")
+	if err != nil {
+		return nil, "", storeError(ctx, err)
+	}
+	q := `SELECT h.id, a.id, h.name, h.description, h.header_image_address, h.stars_count, a.country, a.city, a.street, a.house,
+		  h.free_cancellation_days, h.cancellation_penalty_percent, h.currency,
+		  (SELECT MIN(price) FROM apartments WHERE hotel_id = h.id), ` + p.column() + `
 		  FROM hotels h
-		  INNER JOIN address a on h.address_id = a.id`
-	rows, err := r.store.db.Query(q) // in rows заносим строки с помощью пакета database/sql, в ерр ошибку
-	defer rows.Close()               // закрываем бд(закроется при выходе из функции)
-
+		  INNER JOIN address a on h.address_id = a.id
+		  WHERE ` + hotelConditions(b, f) + ` AND ` + p.cond(b) + `
+		  ` + p.orderBy(b)
+	rows, err := r.store.conn().QueryContext(ctx, q, b.args...) // in rows заносим строки с помощью пакета database/sql, в ерр ошибку
 	if err != nil {
-		if err == sql.ErrNoRows {
-			return nil, store.ErrRecordNotFound // если ничего не найдено, то возвращаем ничего для массива и
-			// ошибку о том, что ничего не найдено
-		}
-		return nil, err // в ином случае возвращаем ничего и полученную ошибку
+		return nil, "", storeError(ctx, err) // в ином случае возвращаем ничего и полученную ошибку
 	}
+	defer rows.Close() // закрываем бд(закроется при выходе из функции)
+
+	values, ids := []string{}, []int{}
 
 	for rows.Next() { // для каждого элемета массива:
+		var minPrice sql.NullInt64
+		var value string
 		a := &model.Address{} // а присваиваем ссылку на структуру с моделью адреса
 		h := model.Hotel{
-			Address: a, // в качестве адреса берем ссылку на адрес
+			Address:            a, // в качестве адреса берем ссылку на адрес
+			CancellationPolicy: &model.CancellationPolicy{},
 		}
 		err := rows.Scan( // из запросы сканируем выбранные поля в структуру
 			&h.ID,
@@ -91,25 +153,43 @@ func (r HotelRepository) FindAll() ([]model.Hotel, error) {
 			&h.Address.City,
 			&h.Address.Street,
 			&h.Address.House,
+			&h.CancellationPolicy.FreeDays,
+			&h.CancellationPolicy.PenaltyPercent,
+			&h.Currency,
+			&minPrice,
+			&value,
 		)
 		if err != nil {
-			return nil, err
+			return nil, "", storeError(ctx, err)
 		} // если есть ошибка, то возвращаем пустой слайс отелей и ошибку
+		h.MinPrice = nullMoney(minPrice, h.Currency)
 		hotels = append(hotels, h) // если все ок, то добавляем отель в слайс
+		values, ids = append(values, value), append(ids, h.ID)
 	}
-	return hotels, nil // возвращаем слайс отелей и ноль для ошибки
+	if err := rows.Err(); err != nil {
+		return nil, "", storeError(ctx, err)
+	}
+	n, next := p.next(values, ids)
+	return hotels[:n], next, nil // возвращаем страницу отелей и курсор следующей
 }
 
-func (r HotelRepository) Find(id int) (*model.Hotel, error) {
+func (r HotelRepository) Find(ctx context.Context, id int) (*model.Hotel, error) {
+	ctx, cancel := r.store.withTimeout(ctx)
+	defer cancel()
+
+	var minPrice sql.NullInt64
 	a := &model.Address{}
 	h := &model.Hotel{
-		Address: a,
+		Address:            a,
+		CancellationPolicy: &model.CancellationPolicy{},
 	}
-	q := `SELECT h.id, a.id, h.name, h.description, h.header_image_address, h.stars_count, a.country, a.city, a.street, a.house 
+	q := `SELECT h.id, a.id, h.name, h.description, h.header_image_address, h.stars_count, a.country, a.city, a.street, a.house,
+		  h.free_cancellation_days, h.cancellation_penalty_percent, h.currency,
+		  (SELECT MIN(price) FROM apartments WHERE hotel_id = h.id)
 		  FROM hotels h
 		  INNER JOIN address a on h.address_id = a.id
 		  WHERE h.id = $1`
-	if err := r.store.db.QueryRow(
+	if err := r.store.conn().QueryRowContext(ctx,
 		q,
 		id,
 	).Scan(
@@ -123,11 +203,188 @@ func (r HotelRepository) Find(id int) (*model.Hotel, error) {
 		&h.Address.City,
 		&h.Address.Street,
 		&h.Address.House,
+		&h.CancellationPolicy.FreeDays,
+		&h.CancellationPolicy.PenaltyPercent,
+		&h.Currency,
+		&minPrice,
 	); err != nil {
 		if err == sql.ErrNoRows {
 			return nil, store.ErrRecordNotFound
 		}
-		return nil, err
+		return nil, storeError(ctx, err)
 	}
+	h.MinPrice = nullMoney(minPrice, h.Currency)
 	return h, nil
 }
+
+// hotelDocument is the text search document of a hotel h with address a.
+// Names and descriptions are written in Russian or English, so they are
+// stemmed with both configurations, while addresses are matched as is.
+const hotelDocument = `(
+	setweight(to_tsvector('russian', h.name), 'A') || setweight(to_tsvector('english', h.name), 'A') ||
+	setweight(to_tsvector('russian', h.description), 'B') || setweight(to_tsvector('english', h.description), 'B') ||
+	setweight(to_tsvector('simple', concat_ws(' ', a.country, a.city, a.street)), 'C')
+)`
+
+// headline returns the expression highlighting the query in the text column,
+// parsed as Russian when it contains Cyrillic letters and as English
+// otherwise. The text is HTML-escaped first, so only the highlighting tags
+// are markup.
+func headline(column, query, options string) string {
+	escaped := escapeHTML(column)
+	return `CASE WHEN ` + column + ` ~ '[А-Яа-яЁё]'
+		THEN ts_headline('russian', ` + escaped + `, ` + query + `, '` + options + `')
+		ELSE ts_headline('english', ` + escaped + `, ` + query + `, '` + options + `') END`
+}
+
+// escapeHTML returns the expression escaping the text column like
+// html.EscapeString.
+func escapeHTML(column string) string {
+	return `replace(replace(replace(replace(replace(` + column + `,
+		'&', '&amp;'), '<', '&lt;'), '>', '&gt;'), '"', '&#34;'), '''', '&#39;')`
+}
+
+// Search ranks the hotels matching the text by relevance. Without a sort in
+// opts the best matches come first.
+func (r HotelRepository) Search(ctx context.Context, text string, opts *store.ListOptions) ([]model.HotelSearchResult, string, error) {
+	ctx, cancel := r.store.withTimeout(ctx)
+	defer cancel()
+
+	b := &queryBuilder{}
+	query := b.cond(
+		`(websearch_to_tsquery('russian', %s) || websearch_to_tsquery('english', %[1]s) || websearch_to_tsquery('simple', %[1]s))`,
+		text,
+	)
+	keys := map[string]sortKey{
+		"rank": {"ts_rank(" + hotelDocument + ", " + query + ")", "real"},
+	}
+	p, err := newPage(opts, keys, "rank", "h.id")
+	if err != nil {
+		return nil, "", storeError(ctx, err)
+	}
+
+	results := []model.HotelSearchResult{}
+	q := `SELECT h.id, a.id, h.name, h.description, h.header_image_address, h.stars_count, a.country, a.city, a.street, a.house,
+		  h.free_cancellation_days, h.cancellation_penalty_percent, h.currency,
+		  (SELECT MIN(price) FROM apartments WHERE hotel_id = h.id),
+		  ` + keys["rank"].expr + `,
+		  ` + headline("h.name", query, "StartSel=<mark>, StopSel=</mark>, HighlightAll=true") + `,
+		  ` + headline("h.description", query, "StartSel=<mark>, StopSel=</mark>, MaxWords=35, MinWords=15") + `,
+		  ` + p.column() + `
+		  FROM hotels h
+		  INNER JOIN address a on h.address_id = a.id
+		  WHERE ` + hotelDocument + ` @@ ` + query + ` AND ` + p.cond(b) + `
+		  ` + p.orderBy(b)
+	rows, err := r.store.conn().QueryContext(ctx, q, b.args...)
+	if err != nil {
+		return nil, "", storeError(ctx, err)
+	}
+	defer rows.Close()
+
+	values, ids := []string{}, []int{}
+	for rows.Next() {
+		var minPrice sql.NullInt64
+		var value string
+		h := &model.Hotel{
+			Address:            &model.Address{},
+			CancellationPolicy: &model.CancellationPolicy{},
+		}
+		res := model.HotelSearchResult{
+			Hotel: h,
+		}
+		err := rows.Scan(
+			&h.ID,
+			&h.Address.ID,
+			&h.Name,
+			&h.Description,
+			&h.HeaderImageAddress,
+			&h.StarsCount,
+			&h.Address.Country,
+			&h.Address.City,
+			&h.Address.Street,
+			&h.Address.House,
+			&h.CancellationPolicy.FreeDays,
+			&h.CancellationPolicy.PenaltyPercent,
+			&h.Currency,
+			&minPrice,
+			&res.Rank,
+			&res.Name,
+			&res.Snippet,
+			&value,
+		)
+		if err != nil {
+			return nil, "", storeError(ctx, err)
+		}
+		h.MinPrice = nullMoney(minPrice, h.Currency)
+		results = append(results, res)
+		values, ids = append(values, value), append(ids, h.ID)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, "", storeError(ctx, err)
+	}
+	n, next := p.next(values, ids)
+	return results[:n], next, nil
+}
+
+// hotelConditions returns the WHERE condition selecting the hotels h with
+// address a that match the filter.
+func hotelConditions(b *queryBuilder, f *store.HotelFilter) string {
+	if f == nil {
+		return and(nil)
+	}
+
+	conds := []string{}
+	if f.Country != "" {
+		conds = append(conds, b.cond("lower(a.country) = lower(%s)", f.Country))
+	}
+	if f.City != "" {
+		conds = append(conds, b.cond("lower(a.city) = lower(%s)", f.City))
+	}
+	if f.MinStars != 0 {
+		conds = append(conds, b.cond("h.stars_count >= %s", f.MinStars))
+	}
+	if f.MaxStars != 0 {
+		conds = append(conds, b.cond("h.stars_count <= %s", f.MaxStars))
+	}
+
+	apartmentConds := []string{}
+	if f.MinBedCount != 0 {
+		apartmentConds = append(apartmentConds, b.cond("ap.bed_count >= %s", f.MinBedCount))
+	}
+	if f.ApartmentClassID != 0 {
+		apartmentConds = append(apartmentConds, b.cond("ap.apartment_class_id = %s", f.ApartmentClassID))
+	}
+	if len(f.PriceRanges) != 0 {
+		ranges := []string{}
+		for _, pr := range f.PriceRanges {
+			rangeConds := []string{b.cond("h.currency = %s", pr.Currency)}
+			if pr.Min != nil {
+				rangeConds = append(rangeConds, b.cond("ap.price >= %s", *pr.Min))
+			}
+			if pr.Max != nil {
+				rangeConds = append(rangeConds, b.cond("ap.price <= %s", *pr.Max))
+			}
+			ranges = append(ranges, "("+and(rangeConds)+")")
+		}
+		apartmentConds = append(apartmentConds, or(ranges))
+	}
+	if !f.DateArrival.IsZero() && !f.DateDeparture.IsZero() {
+		apartmentConds = append(apartmentConds, b.cond(
+			`NOT EXISTS (
+				SELECT 1 FROM transact t
+				WHERE t.apartment_id = ap.id AND t.status = %s AND t.date_arrival < %s AND t.date_departure > %s
+			)`,
+			model.TransactStatusActive,
+			f.DateDeparture,
+			f.DateArrival,
+		))
+	}
+	if len(apartmentConds) != 0 {
+		conds = append(conds, `EXISTS (
+			SELECT 1 FROM apartments ap
+			WHERE ap.hotel_id = h.id AND `+and(apartmentConds)+`
+		)`)
+	}
+
+	return and(conds)
+}
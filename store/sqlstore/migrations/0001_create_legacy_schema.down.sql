@@ -0,0 +1,7 @@
+DROP TABLE transact;
+DROP TABLE users;
+DROP TABLE apartment_images;
+DROP TABLE apartments;
+DROP TABLE apartment_classes;
+DROP TABLE hotels;
+DROP TABLE address;
@@ -0,0 +1,57 @@
+-- The schema the service ran on before it had migrations, as its queries
+-- used it. Databases created back then are adopted with
+-- "migrate baseline 1" and upgraded by the later versions.
+CREATE TABLE address (
+    id      serial PRIMARY KEY,
+    country varchar(40) NOT NULL,
+    city    varchar(40) NOT NULL,
+    street  varchar(60) NOT NULL,
+    house   varchar(10) NOT NULL
+);
+
+CREATE TABLE hotels (
+    id                   serial PRIMARY KEY,
+    name                 varchar(100) NOT NULL,
+    address_id           integer NOT NULL REFERENCES address (id),
+    stars_count          integer NOT NULL,
+    description          text NOT NULL DEFAULT '',
+    header_image_address text NOT NULL DEFAULT ''
+);
+
+CREATE TABLE apartment_classes (
+    id    serial PRIMARY KEY,
+    class varchar(40) NOT NULL UNIQUE
+);
+
+CREATE TABLE apartments (
+    id                 serial PRIMARY KEY,
+    hotel_id           integer NOT NULL REFERENCES hotels (id) ON DELETE CASCADE,
+    apartment_class_id integer NOT NULL REFERENCES apartment_classes (id),
+    name               varchar(40) NOT NULL,
+    is_free            boolean NOT NULL DEFAULT true,
+    bed_count          integer NOT NULL,
+    price              integer NOT NULL
+);
+
+CREATE TABLE apartment_images (
+    id       serial PRIMARY KEY,
+    hotel_id integer NOT NULL REFERENCES hotels (id) ON DELETE CASCADE,
+    address  text NOT NULL
+);
+
+CREATE TABLE users (
+    id           serial PRIMARY KEY,
+    lname        varchar(40) NOT NULL,
+    fname        varchar(40) NOT NULL DEFAULT '',
+    phone_number varchar(16) NOT NULL UNIQUE
+);
+
+CREATE TABLE transact (
+    id             serial PRIMARY KEY,
+    apartment_id   integer NOT NULL REFERENCES apartments (id),
+    user_id        integer NOT NULL REFERENCES users (id),
+    date           timestamptz NOT NULL DEFAULT now(),
+    date_arrival   date NOT NULL,
+    date_departure date NOT NULL,
+    price          integer NOT NULL
+);
@@ -0,0 +1,5 @@
+ALTER TABLE hotels
+    DROP CONSTRAINT hotels_stars_count_check,
+    DROP COLUMN currency,
+    DROP COLUMN cancellation_penalty_percent,
+    DROP COLUMN free_cancellation_days;
@@ -0,0 +1,8 @@
+ALTER TABLE hotels
+    ADD COLUMN free_cancellation_days integer NOT NULL DEFAULT 0 CHECK (free_cancellation_days >= 0),
+    ADD COLUMN cancellation_penalty_percent integer NOT NULL DEFAULT 0 CHECK (cancellation_penalty_percent BETWEEN 0 AND 100),
+    ADD COLUMN currency char(3) NOT NULL DEFAULT 'RUB';
+
+-- Checked for new rows only, the rows of a legacy database were never
+-- validated.
+ALTER TABLE hotels ADD CONSTRAINT hotels_stars_count_check CHECK (stars_count BETWEEN 1 AND 5) NOT VALID;
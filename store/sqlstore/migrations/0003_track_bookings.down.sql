@@ -0,0 +1,25 @@
+-- Prices too big for integer fail the migration instead of being cut.
+DROP TABLE transact_history;
+
+DROP INDEX transact_user_id_idx;
+DROP INDEX transact_apartment_id_idx;
+
+ALTER TABLE transact
+    DROP CONSTRAINT transact_dates_check,
+    DROP COLUMN exchange_rate,
+    DROP COLUMN display_currency,
+    DROP COLUMN display_price,
+    DROP COLUMN refund,
+    DROP COLUMN cancelled_at,
+    DROP COLUMN status,
+    DROP COLUMN currency,
+    ALTER COLUMN price TYPE integer;
+
+DROP INDEX apartment_images_hotel_id_idx;
+DROP INDEX apartments_hotel_id_idx;
+
+ALTER TABLE apartments
+    DROP CONSTRAINT apartments_price_check,
+    DROP CONSTRAINT apartments_bed_count_check,
+    ALTER COLUMN price TYPE integer,
+    ADD COLUMN is_free boolean NOT NULL DEFAULT true;
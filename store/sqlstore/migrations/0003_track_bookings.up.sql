@@ -0,0 +1,47 @@
+-- Availability is told by the dates of the bookings now, not by a flag set
+-- on the first booking.
+ALTER TABLE apartments
+    DROP COLUMN is_free,
+    ALTER COLUMN price TYPE bigint;
+
+-- Checked for new rows only, the rows of a legacy database were never
+-- validated.
+ALTER TABLE apartments
+    ADD CONSTRAINT apartments_bed_count_check CHECK (bed_count > 0) NOT VALID,
+    ADD CONSTRAINT apartments_price_check CHECK (price > 0) NOT VALID;
+
+CREATE INDEX apartments_hotel_id_idx ON apartments (hotel_id);
+CREATE INDEX apartment_images_hotel_id_idx ON apartment_images (hotel_id);
+
+-- Bookings made before were paid in the currency of their hotel.
+ALTER TABLE transact
+    ALTER COLUMN price TYPE bigint,
+    ADD COLUMN currency char(3),
+    ADD COLUMN status varchar(16) NOT NULL DEFAULT 'active',
+    ADD COLUMN cancelled_at timestamptz,
+    ADD COLUMN refund bigint,
+    ADD COLUMN display_price bigint,
+    ADD COLUMN display_currency char(3),
+    ADD COLUMN exchange_rate text,
+    ADD CONSTRAINT transact_dates_check CHECK (date_departure > date_arrival) NOT VALID;
+
+UPDATE transact t SET currency = h.currency
+FROM apartments a, hotels h WHERE a.id = t.apartment_id AND h.id = a.hotel_id;
+
+ALTER TABLE transact ALTER COLUMN currency SET NOT NULL;
+
+CREATE INDEX transact_apartment_id_idx ON transact (apartment_id, date_arrival);
+CREATE INDEX transact_user_id_idx ON transact (user_id);
+
+CREATE TABLE transact_history (
+    id             serial PRIMARY KEY,
+    transact_id    integer NOT NULL REFERENCES transact (id) ON DELETE CASCADE,
+    apartment_id   integer NOT NULL REFERENCES apartments (id),
+    date_arrival   date NOT NULL,
+    date_departure date NOT NULL,
+    price          bigint NOT NULL,
+    currency       char(3) NOT NULL,
+    changed_at     timestamptz NOT NULL DEFAULT now()
+);
+
+CREATE INDEX transact_history_transact_id_idx ON transact_history (transact_id);
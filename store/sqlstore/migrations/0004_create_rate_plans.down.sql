@@ -0,0 +1,3 @@
+DROP TABLE stay_discounts;
+DROP TABLE seasonal_rates;
+DROP TABLE rate_plans;
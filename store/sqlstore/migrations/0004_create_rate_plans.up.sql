@@ -0,0 +1,25 @@
+CREATE TABLE rate_plans (
+    apartment_id              integer PRIMARY KEY REFERENCES apartments (id) ON DELETE CASCADE,
+    weekend_surcharge_percent integer NOT NULL DEFAULT 0 CHECK (weekend_surcharge_percent BETWEEN 0 AND 100),
+    min_stay                  integer NOT NULL DEFAULT 0 CHECK (min_stay >= 0)
+);
+
+CREATE TABLE seasonal_rates (
+    id           serial PRIMARY KEY,
+    apartment_id integer NOT NULL REFERENCES apartments (id) ON DELETE CASCADE,
+    date_from    date NOT NULL,
+    date_to      date NOT NULL,
+    price        bigint NOT NULL CHECK (price > 0),
+    CHECK (date_to >= date_from)
+);
+
+CREATE INDEX seasonal_rates_apartment_id_idx ON seasonal_rates (apartment_id);
+
+CREATE TABLE stay_discounts (
+    id           serial PRIMARY KEY,
+    apartment_id integer NOT NULL REFERENCES apartments (id) ON DELETE CASCADE,
+    min_nights   integer NOT NULL CHECK (min_nights > 0),
+    percent      integer NOT NULL CHECK (percent BETWEEN 1 AND 100)
+);
+
+CREATE INDEX stay_discounts_apartment_id_idx ON stay_discounts (apartment_id);
@@ -0,0 +1,6 @@
+DROP TABLE refresh_tokens;
+DROP TABLE api_key_hotels;
+DROP TABLE api_keys;
+DROP TABLE otp_codes;
+DROP TABLE hotel_managers;
+ALTER TABLE users DROP COLUMN role;
@@ -0,0 +1,46 @@
+ALTER TABLE users ADD COLUMN role varchar(16) NOT NULL DEFAULT 'guest';
+
+CREATE TABLE hotel_managers (
+    user_id  integer NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+    hotel_id integer NOT NULL REFERENCES hotels (id) ON DELETE CASCADE,
+    PRIMARY KEY (user_id, hotel_id)
+);
+
+CREATE TABLE otp_codes (
+    id           serial PRIMARY KEY,
+    phone_number varchar(16) NOT NULL,
+    code_hash    text NOT NULL,
+    attempts     integer NOT NULL DEFAULT 0,
+    expires_at   timestamptz NOT NULL,
+    created_at   timestamptz NOT NULL DEFAULT now()
+);
+
+CREATE INDEX otp_codes_phone_number_idx ON otp_codes (phone_number);
+
+CREATE TABLE api_keys (
+    id           serial PRIMARY KEY,
+    name         varchar(100) NOT NULL,
+    prefix       text NOT NULL,
+    key_hash     text NOT NULL UNIQUE,
+    role         varchar(16) NOT NULL,
+    created_at   timestamptz NOT NULL DEFAULT now(),
+    last_used_at timestamptz,
+    revoked_at   timestamptz
+);
+
+CREATE TABLE api_key_hotels (
+    api_key_id integer NOT NULL REFERENCES api_keys (id) ON DELETE CASCADE,
+    hotel_id   integer NOT NULL REFERENCES hotels (id) ON DELETE CASCADE,
+    PRIMARY KEY (api_key_id, hotel_id)
+);
+
+CREATE TABLE refresh_tokens (
+    id         serial PRIMARY KEY,
+    user_id    integer NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+    token_hash text NOT NULL UNIQUE,
+    expires_at timestamptz NOT NULL,
+    created_at timestamptz NOT NULL DEFAULT now(),
+    revoked_at timestamptz
+);
+
+CREATE INDEX refresh_tokens_user_id_idx ON refresh_tokens (user_id);
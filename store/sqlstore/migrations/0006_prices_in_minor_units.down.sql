@@ -0,0 +1,23 @@
+-- Fractions of the major unit are lost.
+CREATE FUNCTION pg_temp.minor_units(currency char(3)) RETURNS bigint AS $$
+    SELECT CASE
+        WHEN currency IN ('CLP', 'ISK', 'JPY', 'KRW', 'UGX', 'VND') THEN 1
+        WHEN currency IN ('BHD', 'IQD', 'JOD', 'KWD', 'LYD', 'OMR', 'TND') THEN 1000
+        ELSE 100
+    END
+$$ LANGUAGE SQL IMMUTABLE;
+
+UPDATE apartments a SET price = a.price / pg_temp.minor_units(h.currency)
+FROM hotels h WHERE h.id = a.hotel_id;
+
+UPDATE seasonal_rates s SET price = s.price / pg_temp.minor_units(h.currency)
+FROM apartments a, hotels h WHERE a.id = s.apartment_id AND h.id = a.hotel_id;
+
+UPDATE transact SET
+    price = price / pg_temp.minor_units(currency),
+    refund = refund / pg_temp.minor_units(currency),
+    display_price = display_price / pg_temp.minor_units(display_currency);
+
+UPDATE transact_history SET price = price / pg_temp.minor_units(currency);
+
+DROP FUNCTION pg_temp.minor_units(char(3));
@@ -0,0 +1 @@
+-- The numbers as typed are not kept, so they stay normalised.
@@ -0,0 +1,28 @@
+-- Phone numbers used to be stored as typed. The API normalises the numbers
+-- it is given to the E.164 format now, so the stored ones are normalised the
+-- same way or their owners couldn't sign in. When two users share a
+-- normalised number, only the first one gets it.
+CREATE FUNCTION pg_temp.normalize_phone_number(phone text) RETURNS text AS $$
+    SELECT CASE
+        WHEN p ~ '^\+' THEN p
+        WHEN p ~ '^8[0-9]{10}$' THEN '+7' || substr(p, 2)
+        ELSE '+' || p
+    END
+    FROM (SELECT regexp_replace(btrim(phone), '[ ().-]', '', 'g') AS p) AS stripped
+$$ LANGUAGE SQL IMMUTABLE;
+
+UPDATE users u SET phone_number = pg_temp.normalize_phone_number(u.phone_number)
+WHERE u.phone_number <> pg_temp.normalize_phone_number(u.phone_number)
+    AND NOT EXISTS (
+        SELECT 1 FROM users o
+        WHERE o.phone_number = pg_temp.normalize_phone_number(u.phone_number)
+    )
+    AND u.id = (
+        SELECT MIN(o.id) FROM users o
+        WHERE pg_temp.normalize_phone_number(o.phone_number) = pg_temp.normalize_phone_number(u.phone_number)
+    );
+
+-- Codes sent to numbers as typed can't be entered any more.
+DELETE FROM otp_codes WHERE phone_number <> pg_temp.normalize_phone_number(phone_number);
+
+DROP FUNCTION pg_temp.normalize_phone_number(text);
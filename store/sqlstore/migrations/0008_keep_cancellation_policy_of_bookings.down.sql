@@ -0,0 +1,3 @@
+ALTER TABLE transact
+    DROP COLUMN cancellation_penalty_percent,
+    DROP COLUMN free_cancellation_days;
@@ -0,0 +1,15 @@
+-- A booking keeps the cancellation policy of its hotel at the time it was
+-- made, so a policy changed later doesn't change its refund. Bookings made
+-- before get the current policy of their hotel.
+ALTER TABLE transact
+    ADD COLUMN free_cancellation_days integer,
+    ADD COLUMN cancellation_penalty_percent integer;
+
+UPDATE transact t SET
+    free_cancellation_days = h.free_cancellation_days,
+    cancellation_penalty_percent = h.cancellation_penalty_percent
+FROM apartments a, hotels h WHERE a.id = t.apartment_id AND h.id = a.hotel_id;
+
+ALTER TABLE transact
+    ALTER COLUMN free_cancellation_days SET NOT NULL,
+    ALTER COLUMN cancellation_penalty_percent SET NOT NULL;
@@ -0,0 +1,351 @@
+// Package migrations keeps the database schema of sqlstore as versioned SQL
+// migrations embedded into the binary. Every version has an up and a down
+// file named like 0001_create_legacy_schema.up.sql, and the applied versions are
+// recorded in the schema_migrations table.
+package migrations
+
+import (
+	"context"
+	"database/sql"
+	"embed"
+	"fmt"
+	"io/fs"
+	"regexp"
+	"sort"
+	"strconv"
+	"time"
+)
+
+//go:embed *.sql
+var files embed.FS
+
+var fileName = regexp.MustCompile(`^(\d+)_(\w+)\.(up|down)\.sql$`)
+
+// lockID keeps concurrent migrators, like several servers starting with
+// auto-migration, from applying the same migration twice.
+const lockID = 8080
+
+// Migration is a version of the schema.
+type Migration struct {
+	Version int
+	Name    string
+	up      string
+	down    string
+}
+
+// Status tells whether a migration is applied. AppliedAt is nil for pending
+// migrations.
+type Status struct {
+	Version   int        `json:"version"`
+	Name      string     `json:"name"`
+	AppliedAt *time.Time `json:"applied_at"`
+}
+
+// Migrator applies and reverts the embedded migrations.
+type Migrator struct {
+	db         *sql.DB
+	migrations []Migration
+}
+
+func New(db *sql.DB) (*Migrator, error) {
+	migrations, err := load(files)
+	if err != nil {
+		return nil, err
+	}
+	return &Migrator{
+		db:         db,
+		migrations: migrations,
+	}, nil
+}
+
+// load reads the migrations of fsys ordered by version.
+func load(fsys fs.FS) ([]Migration, error) {
+	names, err := fs.Glob(fsys, "*.sql")
+	if err != nil {
+		return nil, err
+	}
+	byVersion := map[int]*Migration{}
+	for _, name := range names {
+		m := fileName.FindStringSubmatch(name)
+		if m == nil {
+			return nil, fmt.Errorf("migration %s: name must look like 0001_name.up.sql", name)
+		}
+		version, _ := strconv.Atoi(m[1])
+		data, err := fs.ReadFile(fsys, name)
+		if err != nil {
+			return nil, err
+		}
+		mg, ok := byVersion[version]
+		if !ok {
+			mg = &Migration{Version: version, Name: m[2]}
+			byVersion[version] = mg
+		}
+		if mg.Name != m[2] {
+			return nil, fmt.Errorf("migration %d has two names: %s and %s", version, mg.Name, m[2])
+		}
+		if m[3] == "up" {
+			mg.up = string(data)
+		} else {
+			mg.down = string(data)
+		}
+	}
+
+	migrations := make([]Migration, 0, len(byVersion))
+	for _, mg := range byVersion {
+		if mg.up == "" || mg.down == "" {
+			return nil, fmt.Errorf("migration %d_%s needs both an up and a down file", mg.Version, mg.Name)
+		}
+		migrations = append(migrations, *mg)
+	}
+	sort.Slice(migrations, func(i, j int) bool {
+		return migrations[i].Version < migrations[j].Version
+	})
+	return migrations, nil
+}
+
+// Latest returns the version of the newest migration.
+func (m *Migrator) Latest() int {
+	if len(m.migrations) == 0 {
+		return 0
+	}
+	return m.migrations[len(m.migrations)-1].Version
+}
+
+// Version returns the version of the last applied migration, 0 for an empty
+// database.
+func (m *Migrator) Version(ctx context.Context) (int, error) {
+	conn, err := m.db.Conn(ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer conn.Close()
+
+	return version(ctx, conn)
+}
+
+// Status lists all migrations with the time they were applied.
+func (m *Migrator) Status() ([]Status, error) {
+	ctx := context.Background()
+	conn, err := m.db.Conn(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	applied := map[int]time.Time{}
+	exists, err := tableExists(ctx, conn)
+	if err != nil {
+		return nil, err
+	}
+	if !exists {
+		return m.statuses(applied), nil
+	}
+	rows, err := conn.QueryContext(ctx, `SELECT version, applied_at FROM schema_migrations`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var v int
+		var at time.Time
+		if err := rows.Scan(&v, &at); err != nil {
+			return nil, err
+		}
+		applied[v] = at
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return m.statuses(applied), nil
+}
+
+// statuses returns the status of every migration given the times the
+// applied ones were applied at.
+func (m *Migrator) statuses(applied map[int]time.Time) []Status {
+	statuses := make([]Status, 0, len(m.migrations))
+	for _, mg := range m.migrations {
+		s := Status{
+			Version: mg.Version,
+			Name:    mg.Name,
+		}
+		if at, ok := applied[mg.Version]; ok {
+			s.AppliedAt = &at
+		}
+		statuses = append(statuses, s)
+	}
+	return statuses
+}
+
+// Up applies all pending migrations.
+func (m *Migrator) Up() error {
+	return m.To(m.Latest())
+}
+
+// Down reverts the last applied migration.
+func (m *Migrator) Down() error {
+	return m.migrate(func(current int) int {
+		target := 0
+		for _, mg := range m.migrations {
+			if mg.Version < current {
+				target = mg.Version
+			}
+		}
+		return target
+	})
+}
+
+// To applies or reverts migrations until the schema is at the version. The
+// version 0 reverts all migrations.
+func (m *Migrator) To(version int) error {
+	if version != 0 && !m.known(version) {
+		return fmt.Errorf("unknown migration version %d", version)
+	}
+	return m.migrate(func(int) int {
+		return version
+	})
+}
+
+// Baseline records the migrations up to the version as applied without
+// running them, for databases whose schema was created before the
+// migrations, by hand or by an older release. Migrations already recorded
+// are kept.
+func (m *Migrator) Baseline(version int) error {
+	if !m.known(version) {
+		return fmt.Errorf("unknown migration version %d", version)
+	}
+	return m.locked(func(ctx context.Context, conn *sql.Conn) error {
+		tx, err := conn.BeginTx(ctx, nil)
+		if err != nil {
+			return err
+		}
+		defer tx.Rollback()
+
+		for _, mg := range m.migrations {
+			if mg.Version > version {
+				break
+			}
+			if _, err := tx.ExecContext(ctx, `INSERT INTO schema_migrations (version, name) VALUES ($1, $2) ON CONFLICT (version) DO NOTHING`, mg.Version, mg.Name); err != nil {
+				return err
+			}
+		}
+		return tx.Commit()
+	})
+}
+
+// migrate moves the schema from the current version to the one returned by
+// target. Each migration runs in a transaction of its own, so a failed one
+// leaves the schema at the previous version.
+func (m *Migrator) migrate(target func(current int) int) error {
+	return m.locked(func(ctx context.Context, conn *sql.Conn) error {
+		current, err := version(ctx, conn)
+		if err != nil {
+			return err
+		}
+		up, down := plan(m.migrations, current, target(current))
+
+		for _, mg := range up {
+			if err := apply(ctx, conn, mg.up, `INSERT INTO schema_migrations (version, name) VALUES ($1, $2)`, mg.Version, mg.Name); err != nil {
+				return fmt.Errorf("migration %d_%s up: %w", mg.Version, mg.Name, err)
+			}
+		}
+		for _, mg := range down {
+			if err := apply(ctx, conn, mg.down, `DELETE FROM schema_migrations WHERE version = $1`, mg.Version); err != nil {
+				return fmt.Errorf("migration %d_%s down: %w", mg.Version, mg.Name, err)
+			}
+		}
+		return nil
+	})
+}
+
+// locked runs fn on a connection holding the migration lock, with the
+// schema_migrations table created.
+func (m *Migrator) locked(fn func(ctx context.Context, conn *sql.Conn) error) error {
+	ctx := context.Background()
+	conn, err := m.db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	if _, err := conn.ExecContext(ctx, `SELECT pg_advisory_lock($1)`, lockID); err != nil {
+		return err
+	}
+	defer conn.ExecContext(ctx, `SELECT pg_advisory_unlock($1)`, lockID)
+
+	if err := createTable(ctx, conn); err != nil {
+		return err
+	}
+	return fn(ctx, conn)
+}
+
+// plan returns the migrations to apply, oldest first, and the ones to
+// revert, newest first, to move the schema from the current version to the
+// target one.
+func plan(migrations []Migration, current, target int) (up, down []Migration) {
+	for _, mg := range migrations {
+		if mg.Version > current && mg.Version <= target {
+			up = append(up, mg)
+		}
+	}
+	for i := len(migrations) - 1; i >= 0; i-- {
+		mg := migrations[i]
+		if mg.Version <= current && mg.Version > target {
+			down = append(down, mg)
+		}
+	}
+	return up, down
+}
+
+func (m *Migrator) known(version int) bool {
+	for _, mg := range m.migrations {
+		if mg.Version == version {
+			return true
+		}
+	}
+	return false
+}
+
+// apply runs the script of a migration and records it with q in one
+// transaction.
+func apply(ctx context.Context, conn *sql.Conn, script, q string, args ...interface{}) error {
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, script); err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, q, args...); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func createTable(ctx context.Context, conn *sql.Conn) error {
+	_, err := conn.ExecContext(ctx, `CREATE TABLE IF NOT EXISTS schema_migrations (
+		version    integer PRIMARY KEY,
+		name       text NOT NULL,
+		applied_at timestamptz NOT NULL DEFAULT now()
+	)`)
+	return err
+}
+
+func tableExists(ctx context.Context, conn *sql.Conn) (bool, error) {
+	var exists bool
+	err := conn.QueryRowContext(ctx, `SELECT to_regclass('schema_migrations') IS NOT NULL`).Scan(&exists)
+	return exists, err
+}
+
+// version returns the last applied version, 0 when nothing was migrated yet.
+func version(ctx context.Context, conn *sql.Conn) (int, error) {
+	exists, err := tableExists(ctx, conn)
+	if err != nil || !exists {
+		return 0, err
+	}
+	var v int
+	err = conn.QueryRowContext(ctx, `SELECT COALESCE(MAX(version), 0) FROM schema_migrations`).Scan(&v)
+	return v, err
+}
@@ -0,0 +1,206 @@
+package migrations
+
+import (
+	"database/sql"
+	"fmt"
+	"github.com/lib/pq"
+	"os"
+	"testing"
+	"testing/fstest"
+	"time"
+)
+
+func TestLoad_Embedded(t *testing.T) {
+	migrations, err := load(files)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(migrations) == 0 {
+		t.Fatal("no migrations")
+	}
+	for i, mg := range migrations {
+		if mg.Version != i+1 {
+			t.Errorf("migration %d_%s: want version %d, the versions must be consecutive", mg.Version, mg.Name, i+1)
+		}
+	}
+}
+
+func TestLoad(t *testing.T) {
+	testCases := []struct {
+		name     string
+		files    fstest.MapFS
+		versions []int
+		wantErr  bool
+	}{
+		{
+			name: "ordered by version",
+			files: fstest.MapFS{
+				"0010_third.up.sql":    {Data: []byte("up")},
+				"0010_third.down.sql":  {Data: []byte("down")},
+				"0002_second.up.sql":   {Data: []byte("up")},
+				"0002_second.down.sql": {Data: []byte("down")},
+				"0001_first.up.sql":    {Data: []byte("up")},
+				"0001_first.down.sql":  {Data: []byte("down")},
+			},
+			versions: []int{1, 2, 10},
+		},
+		{
+			name: "no down file",
+			files: fstest.MapFS{
+				"0001_first.up.sql": {Data: []byte("up")},
+			},
+			wantErr: true,
+		},
+		{
+			name: "two names",
+			files: fstest.MapFS{
+				"0001_first.up.sql":   {Data: []byte("up")},
+				"0001_other.down.sql": {Data: []byte("down")},
+			},
+			wantErr: true,
+		},
+		{
+			name: "bad name",
+			files: fstest.MapFS{
+				"first.up.sql": {Data: []byte("up")},
+			},
+			wantErr: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			migrations, err := load(tc.files)
+			if tc.wantErr {
+				if err == nil {
+					t.Fatal("got no error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := versions(migrations); !equal(got, tc.versions) {
+				t.Errorf("got versions %v, want %v", got, tc.versions)
+			}
+		})
+	}
+}
+
+func TestPlan(t *testing.T) {
+	migrations := []Migration{{Version: 1}, {Version: 2}, {Version: 3}, {Version: 5}}
+	testCases := []struct {
+		name     string
+		current  int
+		target   int
+		wantUp   []int
+		wantDown []int
+	}{
+		{name: "all up", current: 0, target: 5, wantUp: []int{1, 2, 3, 5}},
+		{name: "pending only", current: 2, target: 5, wantUp: []int{3, 5}},
+		{name: "up to a version", current: 1, target: 3, wantUp: []int{2, 3}},
+		{name: "newest first down", current: 5, target: 1, wantDown: []int{5, 3, 2}},
+		{name: "all down", current: 3, target: 0, wantDown: []int{3, 2, 1}},
+		{name: "at the target", current: 3, target: 3},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			up, down := plan(migrations, tc.current, tc.target)
+			if got := versions(up); !equal(got, tc.wantUp) {
+				t.Errorf("up: got %v, want %v", got, tc.wantUp)
+			}
+			if got := versions(down); !equal(got, tc.wantDown) {
+				t.Errorf("down: got %v, want %v", got, tc.wantDown)
+			}
+		})
+	}
+}
+
+// TestMigrator_Legacy adopts a database created before the migrations with
+// baseline 1 and upgrades it. It needs PostgreSQL, so it runs only with
+// TEST_DATABASE_URL set, in a schema of its own that is dropped afterwards.
+func TestMigrator_Legacy(t *testing.T) {
+	url := os.Getenv("TEST_DATABASE_URL")
+	if url == "" {
+		t.Skip("TEST_DATABASE_URL is not set")
+	}
+	dsn, err := pq.ParseURL(url)
+	if err != nil {
+		dsn = url
+	}
+	admin, err := sql.Open("postgres", dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer admin.Close()
+	schema := fmt.Sprintf("migrations_test_%d", time.Now().UnixNano())
+	if _, err := admin.Exec(`CREATE SCHEMA ` + schema); err != nil {
+		t.Fatal(err)
+	}
+	defer admin.Exec(`DROP SCHEMA ` + schema + ` CASCADE`)
+
+	db, err := sql.Open("postgres", dsn+" search_path="+schema)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	legacy, err := os.ReadFile("testdata/legacy.sql")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Exec(string(legacy)); err != nil {
+		t.Fatal(err)
+	}
+
+	m, err := New(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Baseline(1); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Up(); err != nil {
+		t.Fatal(err)
+	}
+
+	var price, bookingPrice int64
+	var currency, phoneNumber string
+	err = db.QueryRow(`SELECT a.price, t.price, t.currency, u.phone_number
+		FROM transact t
+		INNER JOIN apartments a ON a.id = t.apartment_id
+		INNER JOIN users u ON u.id = t.user_id`).Scan(&price, &bookingPrice, &currency, &phoneNumber)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if price != 150000 || bookingPrice != 300000 || currency != "RUB" || phoneNumber != "+79811234567" {
+		t.Errorf("got price %d, booking price %d %s, phone number %s", price, bookingPrice, currency, phoneNumber)
+	}
+
+	if err := m.To(1); err != nil {
+		t.Fatalf("reverting to the legacy schema: %v", err)
+	}
+	if err := db.QueryRow(`SELECT price FROM apartments`).Scan(&price); err != nil || price != 1500 {
+		t.Errorf("reverted price: got %d, %v", price, err)
+	}
+}
+
+func versions(migrations []Migration) []int {
+	v := make([]int, len(migrations))
+	for i, mg := range migrations {
+		v[i] = mg.Version
+	}
+	return v
+}
+
+func equal(a, b []int) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,63 @@
+-- A database created before the migrations, with prices in roubles and
+-- phone numbers as typed.
+CREATE TABLE address (
+    id      serial PRIMARY KEY,
+    country varchar(40) NOT NULL,
+    city    varchar(40) NOT NULL,
+    street  varchar(60) NOT NULL,
+    house   varchar(10) NOT NULL
+);
+
+CREATE TABLE hotels (
+    id                   serial PRIMARY KEY,
+    name                 varchar(100) NOT NULL,
+    address_id           integer NOT NULL REFERENCES address (id),
+    stars_count          integer NOT NULL,
+    description          text NOT NULL DEFAULT '',
+    header_image_address text NOT NULL DEFAULT ''
+);
+
+CREATE TABLE apartment_classes (
+    id    serial PRIMARY KEY,
+    class varchar(40) NOT NULL UNIQUE
+);
+
+CREATE TABLE apartments (
+    id                 serial PRIMARY KEY,
+    hotel_id           integer NOT NULL REFERENCES hotels (id) ON DELETE CASCADE,
+    apartment_class_id integer NOT NULL REFERENCES apartment_classes (id),
+    name               varchar(40) NOT NULL,
+    is_free            boolean NOT NULL DEFAULT true,
+    bed_count          integer NOT NULL,
+    price              integer NOT NULL
+);
+
+CREATE TABLE apartment_images (
+    id       serial PRIMARY KEY,
+    hotel_id integer NOT NULL REFERENCES hotels (id) ON DELETE CASCADE,
+    address  text NOT NULL
+);
+
+CREATE TABLE users (
+    id           serial PRIMARY KEY,
+    lname        varchar(40) NOT NULL,
+    fname        varchar(40) NOT NULL DEFAULT '',
+    phone_number varchar(16) NOT NULL UNIQUE
+);
+
+CREATE TABLE transact (
+    id             serial PRIMARY KEY,
+    apartment_id   integer NOT NULL REFERENCES apartments (id),
+    user_id        integer NOT NULL REFERENCES users (id),
+    date           timestamptz NOT NULL DEFAULT now(),
+    date_arrival   date NOT NULL,
+    date_departure date NOT NULL,
+    price          integer NOT NULL
+);
+
+INSERT INTO address (country, city, street, house) VALUES ('Russia', 'Moscow', 'Tverskaya', '1');
+INSERT INTO hotels (name, address_id, stars_count) VALUES ('Viking', 1, 4);
+INSERT INTO apartment_classes (class) VALUES ('standard');
+INSERT INTO apartments (hotel_id, apartment_class_id, name, is_free, bed_count, price) VALUES (1, 1, 'Standard double', false, 2, 1500);
+INSERT INTO users (lname, phone_number) VALUES ('Ivanov', '89811234567');
+INSERT INTO transact (apartment_id, user_id, date_arrival, date_departure, price) VALUES (1, 1, '2030-03-04', '2030-03-06', 3000);
@@ -0,0 +1,98 @@
+package sqlstore
+
+import (
+	"context"
+	"database/sql"
+	"github.com/zlyaptica/hotel_service_backend/internal/app/model"
+	"github.com/zlyaptica/hotel_service_backend/store"
+	"time"
+)
+
+type OTPRepository struct {
+	store *Store
+}
+
+func (r *OTPRepository) Create(ctx context.Context, o *model.OTP) error {
+	ctx, cancel := r.store.withTimeout(ctx)
+	defer cancel()
+
+	q := `INSERT INTO otp_codes (phone_number, code_hash, attempts, expires_at, created_at) VALUES ($1, $2, $3, $4, $5) RETURNING id`
+	return storeError(ctx, r.store.conn().QueryRowContext(ctx,
+		q,
+		o.PhoneNumber,
+		o.CodeHash,
+		o.Attempts,
+		o.ExpiresAt,
+		o.CreatedAt,
+	).Scan(&o.ID))
+}
+
+// FindLatest returns the last code sent to the phone number.
+func (r *OTPRepository) FindLatest(ctx context.Context, phoneNumber string) (*model.OTP, error) {
+	ctx, cancel := r.store.withTimeout(ctx)
+	defer cancel()
+
+	o := &model.OTP{}
+	q := `SELECT id, phone_number, code_hash, attempts, expires_at, created_at FROM otp_codes
+		  WHERE phone_number = $1 ORDER BY created_at DESC, id DESC LIMIT 1`
+	if err := r.store.conn().QueryRowContext(ctx,
+		q,
+		phoneNumber,
+	).Scan(
+		&o.ID,
+		&o.PhoneNumber,
+		&o.CodeHash,
+		&o.Attempts,
+		&o.ExpiresAt,
+		&o.CreatedAt,
+	); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, store.ErrRecordNotFound
+		}
+		return nil, storeError(ctx, err)
+	}
+	return o, nil
+}
+
+// IncrementAttempts counts an attempt to enter the code. Once max attempts
+// were made it returns store.ErrRecordNotFound, checked in the same statement
+// so concurrent guesses can't exceed the limit.
+func (r *OTPRepository) IncrementAttempts(ctx context.Context, id, max int) error {
+	ctx, cancel := r.store.withTimeout(ctx)
+	defer cancel()
+
+	var attempts int
+	q := `UPDATE otp_codes SET attempts = attempts + 1 WHERE id = $1 AND attempts < $2 RETURNING attempts`
+	if err := r.store.conn().QueryRowContext(ctx, q, id, max).Scan(&attempts); err != nil {
+		if err == sql.ErrNoRows {
+			return store.ErrRecordNotFound
+		}
+		return storeError(ctx, err)
+	}
+	return nil
+}
+
+// CountAttempts returns the number of attempts made with the codes sent to
+// the phone number since the time.
+func (r *OTPRepository) CountAttempts(ctx context.Context, phoneNumber string, since time.Time) (int, error) {
+	ctx, cancel := r.store.withTimeout(ctx)
+	defer cancel()
+
+	var attempts int
+	q := `SELECT COALESCE(SUM(attempts), 0) FROM otp_codes WHERE phone_number = $1 AND created_at >= $2`
+	if err := r.store.conn().QueryRowContext(ctx, q, phoneNumber, since).Scan(&attempts); err != nil {
+		return 0, storeError(ctx, err)
+	}
+	return attempts, nil
+}
+
+// DeleteByPhoneNumber removes the codes sent to the phone number before the
+// time, once one of them was used or they are too old to count.
+func (r *OTPRepository) DeleteByPhoneNumber(ctx context.Context, phoneNumber string, before time.Time) error {
+	ctx, cancel := r.store.withTimeout(ctx)
+	defer cancel()
+
+	q := `DELETE FROM otp_codes WHERE phone_number = $1 AND created_at < $2`
+	_, err := r.store.conn().ExecContext(ctx, q, phoneNumber, before)
+	return storeError(ctx, err)
+}
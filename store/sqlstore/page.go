@@ -0,0 +1,120 @@
+package sqlstore
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"github.com/zlyaptica/hotel_service_backend/store"
+)
+
+// sortKey is an SQL expression a list can be ordered by. Type is the
+// PostgreSQL type of the expression, used to read it back from a cursor.
+type sortKey struct {
+	expr string
+	typ  string
+}
+
+// cursor points past the last row of a page. It remembers the sort of the
+// list so it can't be reused with another one.
+type cursor struct {
+	Sort  string `json:"s"`
+	Desc  bool   `json:"d"`
+	Value string `json:"v"`
+	ID    int    `json:"id"`
+}
+
+// page implements keyset pagination: rows are ordered by the sort key with
+// the id as a tie-breaker, and the next page starts after the (value, id)
+// pair of the last row of the previous one.
+type page struct {
+	sort  string
+	key   sortKey
+	id    string
+	desc  bool
+	limit int
+	after *cursor
+}
+
+// newPage validates opts against the sort keys of a list whose rows are
+// identified by the id expression.
+func newPage(opts *store.ListOptions, keys map[string]sortKey, defaultSort, id string) (*page, error) {
+	if opts == nil {
+		opts = &store.ListOptions{}
+	}
+	p := &page{
+		sort:  opts.Sort,
+		id:    id,
+		desc:  opts.Desc,
+		limit: opts.Limit,
+	}
+	if p.sort == "" {
+		p.sort = defaultSort
+	}
+	key, ok := keys[p.sort]
+	if !ok {
+		return nil, store.ErrInvalidSort
+	}
+	p.key = key
+	if p.limit <= 0 || p.limit > store.MaxLimit {
+		p.limit = store.DefaultLimit
+	}
+
+	if opts.Cursor != "" {
+		data, err := base64.RawURLEncoding.DecodeString(opts.Cursor)
+		if err != nil {
+			return nil, store.ErrInvalidCursor
+		}
+		c := &cursor{}
+		if err := json.Unmarshal(data, c); err != nil {
+			return nil, store.ErrInvalidCursor
+		}
+		if c.Sort != p.sort || c.Desc != p.desc {
+			return nil, store.ErrInvalidCursor
+		}
+		p.after = c
+	}
+	return p, nil
+}
+
+// column returns the select list entry reading the sort value of a row.
+func (p *page) column() string {
+	return "(" + p.key.expr + ")::text"
+}
+
+// cond returns the condition selecting the rows after the cursor.
+func (p *page) cond(b *queryBuilder) string {
+	if p.after == nil {
+		return and(nil)
+	}
+	op := ">"
+	if p.desc {
+		op = "<"
+	}
+	return b.cond("(("+p.key.expr+"), "+p.id+") "+op+" (%s::"+p.key.typ+", %s)", p.after.Value, p.after.ID)
+}
+
+// orderBy returns the ORDER BY and LIMIT clauses. One row more than the page
+// size is fetched to find out whether there is a next page.
+func (p *page) orderBy(b *queryBuilder) string {
+	dir := "ASC"
+	if p.desc {
+		dir = "DESC"
+	}
+	return "ORDER BY (" + p.key.expr + ") " + dir + ", " + p.id + " " + dir + " LIMIT " + b.arg(p.limit+1)
+}
+
+// next trims the fetched rows to the page size and returns the cursor of the
+// next page, or an empty string on the last page. values and ids are the sort
+// values and ids of the fetched rows.
+func (p *page) next(values []string, ids []int) (int, string) {
+	if len(ids) <= p.limit {
+		return len(ids), ""
+	}
+	last := p.limit - 1
+	data, _ := json.Marshal(&cursor{
+		Sort:  p.sort,
+		Desc:  p.desc,
+		Value: values[last],
+		ID:    ids[last],
+	})
+	return p.limit, base64.RawURLEncoding.EncodeToString(data)
+}
@@ -0,0 +1,45 @@
+package sqlstore
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// queryBuilder collects the arguments of a query built from optional
+// conditions and numbers their placeholders in the order they are added.
+type queryBuilder struct {
+	args []interface{}
+}
+
+// arg adds an argument and returns its placeholder.
+func (b *queryBuilder) arg(v interface{}) string {
+	b.args = append(b.args, v)
+	return "$" + strconv.Itoa(len(b.args))
+}
+
+// cond formats a condition, replacing every %s in format with the
+// placeholder of the matching argument.
+func (b *queryBuilder) cond(format string, args ...interface{}) string {
+	placeholders := make([]interface{}, len(args))
+	for i, a := range args {
+		placeholders[i] = b.arg(a)
+	}
+	return fmt.Sprintf(format, placeholders...)
+}
+
+// and joins conditions, returning "TRUE" when there are none.
+func and(conds []string) string {
+	if len(conds) == 0 {
+		return "TRUE"
+	}
+	return strings.Join(conds, " AND ")
+}
+
+// or joins conditions, returning "FALSE" when there are none.
+func or(conds []string) string {
+	if len(conds) == 0 {
+		return "FALSE"
+	}
+	return "(" + strings.Join(conds, " OR ") + ")"
+}
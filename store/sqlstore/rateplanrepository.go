@@ -0,0 +1,101 @@
+package sqlstore
+
+import (
+	"context"
+	"database/sql"
+	"github.com/zlyaptica/hotel_service_backend/internal/app/model"
+)
+
+type RatePlanRepository struct {
+	store *Store
+}
+
+// Find returns the rate plan of the apartment. An apartment without a plan
+// gets an empty one, which prices every night at the base price.
+func (r RatePlanRepository) Find(ctx context.Context, apartmentID int) (*model.RatePlan, error) {
+	ctx, cancel := r.store.withTimeout(ctx)
+	defer cancel()
+
+	p := &model.RatePlan{
+		ApartmentID:   apartmentID,
+		Seasons:       []model.SeasonalRate{},
+		StayDiscounts: []model.StayDiscount{},
+	}
+	q := `SELECT weekend_surcharge_percent, min_stay FROM rate_plans WHERE apartment_id = $1`
+	if err := r.store.conn().QueryRowContext(ctx, q, apartmentID).Scan(
+		&p.WeekendSurchargePercent,
+		&p.MinStay,
+	); err != nil && err != sql.ErrNoRows {
+		return nil, storeError(ctx, err)
+	}
+
+	q = `SELECT s.date_from, s.date_to, s.price, h.currency FROM seasonal_rates s
+		 INNER JOIN apartments a on a.id = s.apartment_id
+		 INNER JOIN hotels h on h.id = a.hotel_id
+		 WHERE s.apartment_id = $1 ORDER BY s.date_from`
+	rows, err := r.store.conn().QueryContext(ctx, q, apartmentID)
+	if err != nil {
+		return nil, storeError(ctx, err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		s := model.SeasonalRate{}
+		if err := rows.Scan(&s.DateFrom, &s.DateTo, &s.Price.Amount, &s.Price.Currency); err != nil {
+			return nil, storeError(ctx, err)
+		}
+		p.Seasons = append(p.Seasons, s)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, storeError(ctx, err)
+	}
+
+	q = `SELECT min_nights, percent FROM stay_discounts WHERE apartment_id = $1 ORDER BY min_nights`
+	rows, err = r.store.conn().QueryContext(ctx, q, apartmentID)
+	if err != nil {
+		return nil, storeError(ctx, err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		d := model.StayDiscount{}
+		if err := rows.Scan(&d.MinNights, &d.Percent); err != nil {
+			return nil, storeError(ctx, err)
+		}
+		p.StayDiscounts = append(p.StayDiscounts, d)
+	}
+	return p, rows.Err()
+}
+
+// Save replaces the rate plan of p.ApartmentID with p.
+func (r RatePlanRepository) Save(ctx context.Context, p *model.RatePlan) error {
+	ctx, cancel := r.store.withTimeout(ctx)
+	defer cancel()
+
+	return r.store.inTx(ctx, func(tx *sql.Tx) error {
+		q := `INSERT INTO rate_plans (apartment_id, weekend_surcharge_percent, min_stay) VALUES ($1, $2, $3)
+			  ON CONFLICT (apartment_id) DO UPDATE SET (weekend_surcharge_percent, min_stay) = ($2, $3)`
+		if _, err := tx.ExecContext(ctx, q, p.ApartmentID, p.WeekendSurchargePercent, p.MinStay); err != nil {
+			return storeError(ctx, err)
+		}
+
+		if _, err := tx.ExecContext(ctx, `DELETE FROM seasonal_rates WHERE apartment_id = $1`, p.ApartmentID); err != nil {
+			return storeError(ctx, err)
+		}
+		q = `INSERT INTO seasonal_rates (apartment_id, date_from, date_to, price) VALUES ($1, $2, $3, $4)`
+		for _, s := range p.Seasons {
+			if _, err := tx.ExecContext(ctx, q, p.ApartmentID, s.DateFrom, s.DateTo, s.Price.Amount); err != nil {
+				return storeError(ctx, err)
+			}
+		}
+
+		if _, err := tx.ExecContext(ctx, `DELETE FROM stay_discounts WHERE apartment_id = $1`, p.ApartmentID); err != nil {
+			return storeError(ctx, err)
+		}
+		q = `INSERT INTO stay_discounts (apartment_id, min_nights, percent) VALUES ($1, $2, $3)`
+		for _, d := range p.StayDiscounts {
+			if _, err := tx.ExecContext(ctx, q, p.ApartmentID, d.MinNights, d.Percent); err != nil {
+				return storeError(ctx, err)
+			}
+		}
+		return nil
+	})
+}
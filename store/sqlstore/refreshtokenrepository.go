@@ -0,0 +1,83 @@
+package sqlstore
+
+import (
+	"context"
+	"database/sql"
+	"github.com/zlyaptica/hotel_service_backend/internal/app/model"
+	"github.com/zlyaptica/hotel_service_backend/store"
+	"time"
+)
+
+type RefreshTokenRepository struct {
+	store *Store
+}
+
+func (r *RefreshTokenRepository) Create(ctx context.Context, t *model.RefreshToken) error {
+	ctx, cancel := r.store.withTimeout(ctx)
+	defer cancel()
+
+	q := `INSERT INTO refresh_tokens (user_id, token_hash, expires_at, created_at) VALUES ($1, $2, $3, $4) RETURNING id`
+	return storeError(ctx, r.store.conn().QueryRowContext(ctx,
+		q,
+		t.UserID,
+		t.TokenHash,
+		t.ExpiresAt,
+		t.CreatedAt,
+	).Scan(&t.ID))
+}
+
+// FindByHash returns the token with the hash, revoked or not.
+func (r *RefreshTokenRepository) FindByHash(ctx context.Context, hash string) (*model.RefreshToken, error) {
+	ctx, cancel := r.store.withTimeout(ctx)
+	defer cancel()
+
+	t := &model.RefreshToken{}
+	q := `SELECT id, user_id, token_hash, expires_at, created_at, revoked_at FROM refresh_tokens WHERE token_hash = $1`
+	if err := r.store.conn().QueryRowContext(ctx,
+		q,
+		hash,
+	).Scan(
+		&t.ID,
+		&t.UserID,
+		&t.TokenHash,
+		&t.ExpiresAt,
+		&t.CreatedAt,
+		&t.RevokedAt,
+	); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, store.ErrRecordNotFound
+		}
+		return nil, storeError(ctx, err)
+	}
+	return t, nil
+}
+
+// Revoke revokes an active token. It returns store.ErrRecordNotFound when
+// the token was revoked already, so a token can't be used twice even by
+// concurrent requests.
+func (r *RefreshTokenRepository) Revoke(ctx context.Context, id int, at time.Time) error {
+	ctx, cancel := r.store.withTimeout(ctx)
+	defer cancel()
+
+	result, err := r.store.conn().ExecContext(ctx, `UPDATE refresh_tokens SET revoked_at = $1 WHERE id = $2 AND revoked_at IS NULL`, at, id)
+	if err != nil {
+		return storeError(ctx, err)
+	}
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return storeError(ctx, err)
+	}
+	if rows != 1 {
+		return store.ErrRecordNotFound
+	}
+	return nil
+}
+
+// RevokeByUserID revokes all active tokens of the user.
+func (r *RefreshTokenRepository) RevokeByUserID(ctx context.Context, userID int, at time.Time) error {
+	ctx, cancel := r.store.withTimeout(ctx)
+	defer cancel()
+
+	_, err := r.store.conn().ExecContext(ctx, `UPDATE refresh_tokens SET revoked_at = $1 WHERE user_id = $2 AND revoked_at IS NULL`, at, userID)
+	return storeError(ctx, err)
+}
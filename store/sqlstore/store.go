@@ -1,13 +1,27 @@
 package sqlstore
 
 import (
+	"context"
 	"database/sql"
 	_ "github.com/lib/pq"
 	"github.com/zlyaptica/hotel_service_backend/store"
+	"time"
 )
 
+// querier runs queries on the database or inside a transaction.
+type querier interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+}
+
 type Store struct {
-	db                       *sql.DB
+	db *sql.DB
+	// queryTimeout limits each call of a repository, 0 means no limit.
+	queryTimeout time.Duration
+	// tx is the transaction all repositories of a store returned by WithTx
+	// work in.
+	tx                       *sql.Tx
 	addressRepository        *AddressRepository
 	apartmentClassRepository *ApartmentClassRepository
 	apartmentRepository      *ApartmentRepository
@@ -15,12 +29,62 @@ type Store struct {
 	hotelRepository          *HotelRepository
 	apartmentImageRepository *ApartmentImageRepository
 	transactRepository       *TransactRepository
+	ratePlanRepository       *RatePlanRepository
+	otpRepository            *OTPRepository
+	apiKeyRepository         *APIKeyRepository
+	refreshTokenRepository   *RefreshTokenRepository
 }
 
-func New(db *sql.DB) *Store {
+func New(db *sql.DB, queryTimeout time.Duration) *Store {
 	return &Store{
-		db: db,
+		db:           db,
+		queryTimeout: queryTimeout,
+	}
+}
+
+// WithTx runs fn with a store whose repositories all work in one
+// transaction, committed when fn returns nil and rolled back otherwise.
+// Called on such a store, it runs fn in the transaction already open.
+func (s *Store) WithTx(ctx context.Context, fn func(store.Store) error) error {
+	if s.tx != nil {
+		return fn(s)
+	}
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return storeError(ctx, err)
+	}
+	defer tx.Rollback()
+
+	if err := fn(&Store{db: s.db, queryTimeout: s.queryTimeout, tx: tx}); err != nil {
+		return err
+	}
+	return storeError(ctx, tx.Commit())
+}
+
+// conn returns the transaction of the store, or the database outside of one.
+func (s *Store) conn() querier {
+	if s.tx != nil {
+		return s.tx
 	}
+	return s.db
+}
+
+// withTimeout returns ctx limited by the query timeout of the store. The
+// statements of a repository call share the limit.
+func (s *Store) withTimeout(ctx context.Context) (context.Context, context.CancelFunc) {
+	if s.queryTimeout <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, s.queryTimeout)
+}
+
+// inTx runs fn in the transaction of the store, or in a transaction of its
+// own outside of one, so repositories can group their statements either way.
+func (s *Store) inTx(ctx context.Context, fn func(tx *sql.Tx) error) error {
+	return s.WithTx(ctx, func(st store.Store) error {
+		return fn(st.(*Store).tx)
+	})
 }
 
 func (s *Store) Address() store.AddressRepository {
@@ -107,3 +171,51 @@ func (s *Store) Transact() store.TransactRepository {
 	return s.transactRepository
 
 }
+
+func (s *Store) RatePlan() store.RatePlanRepository {
+	if s.ratePlanRepository != nil {
+		return s.ratePlanRepository
+	}
+
+	s.ratePlanRepository = &RatePlanRepository{
+		store: s,
+	}
+
+	return s.ratePlanRepository
+}
+
+func (s *Store) OTP() store.OTPRepository {
+	if s.otpRepository != nil {
+		return s.otpRepository
+	}
+
+	s.otpRepository = &OTPRepository{
+		store: s,
+	}
+
+	return s.otpRepository
+}
+
+func (s *Store) APIKey() store.APIKeyRepository {
+	if s.apiKeyRepository != nil {
+		return s.apiKeyRepository
+	}
+
+	s.apiKeyRepository = &APIKeyRepository{
+		store: s,
+	}
+
+	return s.apiKeyRepository
+}
+
+func (s *Store) RefreshToken() store.RefreshTokenRepository {
+	if s.refreshTokenRepository != nil {
+		return s.refreshTokenRepository
+	}
+
+	s.refreshTokenRepository = &RefreshTokenRepository{
+		store: s,
+	}
+
+	return s.refreshTokenRepository
+}
@@ -1,6 +1,7 @@
 package sqlstore
 
 import (
+	"context"
 	"database/sql"
 	"github.com/zlyaptica/hotel_service_backend/internal/app/model"
 	"github.com/zlyaptica/hotel_service_backend/store"
@@ -11,53 +12,289 @@ type TransactRepository struct {
 	store *Store
 }
 
-func (r TransactRepository) Create(t *model.Transact) error {
-	q := `INSERT INTO transact (apartment_id, user_id, date_arrival, date_departure, price, date) VALUES ($1, $2, $3, $4, $5, $6) RETURNING id`
-	return r.store.db.QueryRow(
-		q,
-		t.Apartment.ID,
-		t.User.ID,
-		t.DateArrival,
-		t.DateDeparture,
-		t.Price,
-		time.Now(),
-	).Scan(&t.ID)
+func (r TransactRepository) Create(ctx context.Context, t *model.Transact) error {
+	ctx, cancel := r.store.withTimeout(ctx)
+	defer cancel()
+
+	// The policy is copied from the hotel in the statement, so the stay
+	// keeps the policy it was booked under.
+	q := `INSERT INTO transact (apartment_id, user_id, date_arrival, date_departure, price, date, status, currency,
+		  display_price, display_currency, exchange_rate, free_cancellation_days, cancellation_penalty_percent)
+		  VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11,
+		  (SELECT h.free_cancellation_days FROM apartments a INNER JOIN hotels h ON h.id = a.hotel_id WHERE a.id = $1),
+		  (SELECT h.cancellation_penalty_percent FROM apartments a INNER JOIN hotels h ON h.id = a.hotel_id WHERE a.id = $1))
+		  RETURNING id, free_cancellation_days, cancellation_penalty_percent`
+	return r.store.inTx(ctx, func(tx *sql.Tx) error {
+		if err := reserve(ctx, tx, t.Apartment.ID, t.DateArrival, t.DateDeparture, 0); err != nil {
+			return storeError(ctx, err)
+		}
+
+		t.Status = model.TransactStatusActive
+		t.CancellationPolicy = &model.CancellationPolicy{}
+		displayPrice, displayCurrency, exchangeRate := displayColumns(t)
+		return storeError(ctx, tx.QueryRowContext(ctx,
+			q,
+			t.Apartment.ID,
+			t.User.ID,
+			t.DateArrival,
+			t.DateDeparture,
+			t.Price.Amount,
+			time.Now(),
+			t.Status,
+			t.Price.Currency,
+			displayPrice,
+			displayCurrency,
+			exchangeRate,
+		).Scan(&t.ID, &t.CancellationPolicy.FreeDays, &t.CancellationPolicy.PenaltyPercent))
+	})
+}
+
+// Update moves an active transact to t.Apartment and the t.DateArrival,
+// t.DateDeparture range at t.Price. The version being replaced is kept in
+// transact_history.
+func (r TransactRepository) Update(ctx context.Context, t *model.Transact) error {
+	ctx, cancel := r.store.withTimeout(ctx)
+	defer cancel()
+
+	return r.store.inTx(ctx, func(tx *sql.Tx) error {
+		var status string
+		if err := tx.QueryRowContext(ctx,
+			`SELECT status FROM transact WHERE id = $1 FOR UPDATE`,
+			t.ID,
+		).Scan(&status); err != nil {
+			if err == sql.ErrNoRows {
+				return store.ErrRecordNotFound
+			}
+			return storeError(ctx, err)
+		}
+		if status != model.TransactStatusActive {
+			return store.ErrTransactCancelled
+		}
+
+		if err := reserve(ctx, tx, t.Apartment.ID, t.DateArrival, t.DateDeparture, t.ID); err != nil {
+			return storeError(ctx, err)
+		}
+
+		q := `INSERT INTO transact_history (transact_id, apartment_id, date_arrival, date_departure, price, currency, changed_at)
+			  SELECT id, apartment_id, date_arrival, date_departure, price, currency, $2 FROM transact WHERE id = $1`
+		if _, err := tx.ExecContext(ctx, q, t.ID, time.Now()); err != nil {
+			return storeError(ctx, err)
+		}
+
+		q = `UPDATE transact SET (apartment_id, date_arrival, date_departure, price, currency,
+			 display_price, display_currency, exchange_rate) = ($1, $2, $3, $4, $5, $6, $7, $8) WHERE id = $9`
+		displayPrice, displayCurrency, exchangeRate := displayColumns(t)
+		_, err := tx.ExecContext(ctx,
+			q,
+			t.Apartment.ID,
+			t.DateArrival,
+			t.DateDeparture,
+			t.Price.Amount,
+			t.Price.Currency,
+			displayPrice,
+			displayCurrency,
+			exchangeRate,
+			t.ID,
+		)
+		return storeError(ctx, err)
+	})
+}
+
+func (r TransactRepository) History(ctx context.Context, id int) ([]model.TransactVersion, error) {
+	ctx, cancel := r.store.withTimeout(ctx)
+	defer cancel()
+
+	versions := []model.TransactVersion{}
+	q := `SELECT id, transact_id, apartment_id, price, currency, date_arrival, date_departure, changed_at
+		  FROM transact_history WHERE transact_id = $1 ORDER BY changed_at`
+	rows, err := r.store.conn().QueryContext(ctx, q, id)
+	if err != nil {
+		return nil, storeError(ctx, err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		v := model.TransactVersion{}
+		err := rows.Scan(
+			&v.ID,
+			&v.TransactID,
+			&v.ApartmentID,
+			&v.Price.Amount,
+			&v.Price.Currency,
+			&v.DateArrival,
+			&v.DateDeparture,
+			&v.ChangedAt,
+		)
+		if err != nil {
+			return nil, storeError(ctx, err)
+		}
+		versions = append(versions, v)
+	}
+	return versions, rows.Err()
+}
+
+// reserve checks inside tx that the apartment has no active stay overlapping
+// the [arrival, departure) range, ignoring the transact with id except. The
+// apartment row is locked for the rest of the transaction, so two concurrent
+// bookings of the same apartment are serialized and the second one sees the
+// stay written by the first.
+func reserve(ctx context.Context, tx *sql.Tx, apartmentID int, arrival, departure time.Time, except int) error {
+	var id int
+	if err := tx.QueryRowContext(ctx,
+		`SELECT id FROM apartments WHERE id = $1 FOR UPDATE`,
+		apartmentID,
+	).Scan(&id); err != nil {
+		if err == sql.ErrNoRows {
+			return store.ErrRecordNotFound
+		}
+		return storeError(ctx, err)
+	}
+
+	var overlaps bool
+	if err := tx.QueryRowContext(ctx,
+		`SELECT EXISTS (
+			SELECT 1 FROM transact
+			WHERE apartment_id = $1 AND id <> $5 AND status = $4 AND date_arrival < $3 AND date_departure > $2
+		)`,
+		apartmentID,
+		arrival,
+		departure,
+		model.TransactStatusActive,
+		except,
+	).Scan(&overlaps); err != nil {
+		return storeError(ctx, err)
+	}
+	if overlaps {
+		return store.ErrApartmentUnavailable
+	}
+	return nil
+}
+
+func (r TransactRepository) Find(ctx context.Context, id int) (*model.Transact, error) {
+	ctx, cancel := r.store.withTimeout(ctx)
+	defer cancel()
+
+	u := &model.User{}
+	h := &model.Hotel{}
+	a := &model.Apartment{
+		Hotel: h,
+	}
+	t := &model.Transact{
+		User:               u,
+		Apartment:          a,
+		CancellationPolicy: &model.CancellationPolicy{},
+	}
+	var refund, displayPrice sql.NullInt64
+	var displayCurrency, exchangeRate sql.NullString
+	q := `SELECT t.id, g.id, g.phone_number, t.price, t.currency, t.date, t.date_arrival, t.date_departure,
+       t.status, t.cancelled_at, t.refund, t.display_price, t.display_currency, t.exchange_rate,
+       t.free_cancellation_days, t.cancellation_penalty_percent, a.id, a.name, h.id
+       FROM transact t
+			INNER JOIN users g on t.user_id = g.id
+			INNER JOIN apartments a on a.id = t.apartment_id
+			INNER JOIN hotels h on h.id = a.hotel_id
+			WHERE t.id = $1`
+	if err := r.store.conn().QueryRowContext(ctx, q, id).Scan(
+		&t.ID,
+		&t.User.ID,
+		&t.User.PhoneNumber,
+		&t.Price.Amount,
+		&t.Price.Currency,
+		&t.OperationDate,
+		&t.DateArrival,
+		&t.DateDeparture,
+		&t.Status,
+		&t.CancelledAt,
+		&refund,
+		&displayPrice,
+		&displayCurrency,
+		&exchangeRate,
+		&t.CancellationPolicy.FreeDays,
+		&t.CancellationPolicy.PenaltyPercent,
+		&t.Apartment.ID,
+		&t.Apartment.Name,
+		&t.Apartment.Hotel.ID,
+	); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, store.ErrRecordNotFound
+		}
+		return nil, storeError(ctx, err)
+	}
+	t.Refund = nullMoney(refund, t.Price.Currency)
+	t.DisplayPrice = nullMoney(displayPrice, displayCurrency.String)
+	t.ExchangeRate = exchangeRate.String
+	return t, nil
 }
 
-func (r TransactRepository) CreateTransact(t *model.Transact) error {
-	q := `INSERT INTO transact (apartment_id, user_id, date_arrival, date_departure, price, date) 
-		  VALUES ($1, (SELECT id FROM users WHERE phone_number = $2), $3, $4, $5, $6) RETURNING id`
-	return r.store.db.QueryRow(
+// Cancel marks an active transact as cancelled at t.CancelledAt with t.Refund
+// returned to the guest. Cancelled stays no longer block the apartment.
+func (r TransactRepository) Cancel(ctx context.Context, t *model.Transact) error {
+	ctx, cancel := r.store.withTimeout(ctx)
+	defer cancel()
+
+	q := `UPDATE transact SET (status, cancelled_at, refund) = ($1, $2, $3) WHERE id = $4 AND status = $5`
+	result, err := r.store.conn().ExecContext(ctx,
 		q,
-		t.Apartment.ID,
-		t.User.PhoneNumber,
-		t.DateArrival,
-		t.DateDeparture,
-		t.Price,
-		time.Now(),
-	).Scan(&t.ID)
+		model.TransactStatusCancelled,
+		t.CancelledAt,
+		t.Refund.Amount,
+		t.ID,
+		model.TransactStatusActive,
+	)
+	if err != nil {
+		return storeError(ctx, err)
+	}
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return storeError(ctx, err)
+	}
+	if rows != 1 {
+		return store.ErrTransactCancelled
+	}
+	t.Status = model.TransactStatusCancelled
+	return nil
+}
+
+var transactSortKeys = map[string]sortKey{
+	"id":             {"t.id", "bigint"},
+	"operation_date": {"t.date", "timestamptz"},
+	"arrival":        {"t.date_arrival", "date"},
+	"price":          {"t.price", "bigint"},
 }
 
-func (r TransactRepository) FindTransactsByPhoneNumber(phoneNumber string) ([]model.Transact, error) {
+func (r TransactRepository) FindTransactsByPhoneNumber(ctx context.Context, phoneNumber string, opts *store.ListOptions) ([]model.Transact, string, error) {
+	ctx, cancel := r.store.withTimeout(ctx)
+	defer cancel()
+
+	p, err := newPage(opts, transactSortKeys, "operation_date", "t.id")
+	if err != nil {
+		return nil, "", storeError(ctx, err)
+	}
+
 	transacts := []model.Transact{}
-	q := `SELECT t.id, g.id, g.phone_number, t.price, t.date, t.date_arrival, t.date_departure, 
-       a.id, a.bed_count, a.is_free, a.name, a.price, ac.id, ac.class, h.id, h.name
+	b := &queryBuilder{}
+	q := `SELECT t.id, g.id, g.phone_number, t.price, t.currency, t.date, t.date_arrival, t.date_departure, 
+       t.status, t.cancelled_at, t.refund, t.display_price, t.display_currency, t.exchange_rate,
+       t.free_cancellation_days, t.cancellation_penalty_percent, a.id, a.bed_count, a.name, a.price, h.currency, ac.id, ac.class, h.id, h.name,
+       ` + p.column() + `
        FROM transact t
 			INNER JOIN users g on t.user_id = g.id
 			INNER JOIN apartments a on a.id = t.apartment_id
        		INNER JOIN apartment_classes ac on ac.id = a.apartment_class_id
        		INNER JOIN hotels h on h.id = a.hotel_id
-			WHERE g.phone_number = $1`
-	rows, err := r.store.db.Query(q, phoneNumber)
-	defer rows.Close()
-
+			WHERE ` + b.cond("g.phone_number = %s", phoneNumber) + ` AND ` + p.cond(b) + `
+			` + p.orderBy(b)
+	rows, err := r.store.conn().QueryContext(ctx, q, b.args...)
 	if err != nil {
-		if err == sql.ErrNoRows {
-			return nil, store.ErrRecordNotFound
-		}
-		return nil, err
+		return nil, "", storeError(ctx, err)
 	}
+	defer rows.Close()
+
+	values, ids := []string{}, []int{}
 	for rows.Next() {
+		var value string
+		var refund, displayPrice sql.NullInt64
+		var displayCurrency, exchangeRate sql.NullString
 		u := &model.User{}
 		ac := &model.ApartmentClass{}
 		h := &model.Hotel{}
@@ -66,31 +303,69 @@ func (r TransactRepository) FindTransactsByPhoneNumber(phoneNumber string) ([]mo
 			ApartmentClass: ac,
 		}
 		t := model.Transact{
-			User:      u,
-			Apartment: a,
+			User:               u,
+			Apartment:          a,
+			CancellationPolicy: &model.CancellationPolicy{},
 		}
 		err := rows.Scan(
 			&t.ID,
 			&t.User.ID,
 			&t.User.PhoneNumber,
-			&t.Price,
+			&t.Price.Amount,
+			&t.Price.Currency,
 			&t.OperationDate,
 			&t.DateArrival,
 			&t.DateDeparture,
+			&t.Status,
+			&t.CancelledAt,
+			&refund,
+			&displayPrice,
+			&displayCurrency,
+			&exchangeRate,
+			&t.CancellationPolicy.FreeDays,
+			&t.CancellationPolicy.PenaltyPercent,
 			&t.Apartment.ID,
 			&t.Apartment.BedCount,
-			&t.Apartment.IsFree,
 			&t.Apartment.Name,
-			&t.Apartment.Price,
+			&t.Apartment.Price.Amount,
+			&t.Apartment.Price.Currency,
 			&t.Apartment.ApartmentClass.ID,
 			&t.Apartment.ApartmentClass.Class,
 			&t.Apartment.Hotel.ID,
 			&t.Apartment.Hotel.Name,
+			&value,
 		)
 		if err != nil {
-			return nil, err
+			return nil, "", storeError(ctx, err)
 		}
+		t.Refund = nullMoney(refund, t.Price.Currency)
+		t.DisplayPrice = nullMoney(displayPrice, displayCurrency.String)
+		t.ExchangeRate = exchangeRate.String
 		transacts = append(transacts, t)
+		values, ids = append(values, value), append(ids, t.ID)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, "", storeError(ctx, err)
+	}
+	n, next := p.next(values, ids)
+	return transacts[:n], next, nil
+}
+
+// nullMoney returns the money for a nullable amount column, or nil when the
+// column is NULL.
+func nullMoney(amount sql.NullInt64, currency string) *model.Money {
+	if !amount.Valid {
+		return nil
+	}
+	return &model.Money{Amount: amount.Int64, Currency: currency}
+}
+
+// displayColumns returns the values of the display_price, display_currency
+// and exchange_rate columns of t, which are NULL for stays shown to the guest
+// in the currency of the hotel.
+func displayColumns(t *model.Transact) (interface{}, interface{}, interface{}) {
+	if t.DisplayPrice == nil {
+		return nil, nil, nil
 	}
-	return transacts, nil
+	return t.DisplayPrice.Amount, t.DisplayPrice.Currency, t.ExchangeRate
 }
@@ -1,80 +1,135 @@
 package sqlstore
 
 import (
+	"context"
+	"database/sql"
 	"errors"
+	"github.com/lib/pq"
 	"github.com/zlyaptica/hotel_service_backend/internal/app/model"
+	"github.com/zlyaptica/hotel_service_backend/store"
 )
 
 type UserRepository struct {
 	store *Store
 }
 
-var (
-	errUnknownPhoneNumber = errors.New("there is no user with this phone number")
-)
+func (r *UserRepository) Create(ctx context.Context, u *model.User) error {
+	ctx, cancel := r.store.withTimeout(ctx)
+	defer cancel()
 
-func (r *UserRepository) Create(u *model.User) error {
-	q := `INSERT INTO users (lname, fname, phone_number) VALUES ($1, $2, $3) RETURNING id`
-	return r.store.db.QueryRow(
+	if u.Role == "" {
+		u.Role = model.RoleGuest
+	}
+	q := `INSERT INTO users (lname, fname, phone_number, role) VALUES ($1, $2, $3, $4) RETURNING id`
+	return storeError(ctx, r.store.conn().QueryRowContext(ctx,
 		q,
 		u.LName,
 		u.FName,
 		u.PhoneNumber,
-	).Scan(&u.ID)
+		u.Role,
+	).Scan(&u.ID))
 }
 
-func (r *UserRepository) Delete(phoneNumber string) error {
+func (r *UserRepository) Delete(ctx context.Context, phoneNumber string) error {
+	ctx, cancel := r.store.withTimeout(ctx)
+	defer cancel()
+
 	q := `DELETE FROM users WHERE phone_number = $1`
-	result, err := r.store.db.Exec(q, phoneNumber)
+	result, err := r.store.conn().ExecContext(ctx, q, phoneNumber)
 	if err != nil {
-		return err
+		// Bookings are the only records kept when their user is deleted.
+		var pqErr *pq.Error
+		if errors.As(err, &pqErr) && pqErr.Code.Name() == "foreign_key_violation" {
+			return store.ErrUserHasBookings
+		}
+		return storeError(ctx, err)
 	}
 	row, err := result.RowsAffected()
 	if err != nil {
-		return err
+		return storeError(ctx, err)
 	}
 	if row != 1 {
-		return errUnknownPhoneNumber
+		return store.ErrRecordNotFound
 	}
-	return err
+	return nil
+}
+
+func (r *UserRepository) FindByPhone(ctx context.Context, phone string) (*model.User, error) {
+	ctx, cancel := r.store.withTimeout(ctx)
+	defer cancel()
+
+	return r.find(ctx, `SELECT id, lname, fname, phone_number, role FROM users WHERE phone_number = $1`, phone)
 }
 
-//func (r *UserRepository) FindByPhone(phone string) (*model.User, error) {
-//	u := &model.User{}
-//	q := `SELECT id, lname, fname, phone_number FROM users WHERE phone_number = $1`
-//	if err := r.store.db.QueryRow(
-//		q,
-//		phone,
-//	).Scan(
-//		&u.ID,
-//		&u.LName,
-//		&u.FName,
-//		&u.PhoneNumber,
-//	); err != nil {
-//		if err == sql.ErrNoRows {
-//			return nil, store.ErrRecordNotFound
-//		}
-//		return nil, err
-//	}
-//	return u, nil
-//}
-
-//func (r *UserRepository) Find(id int) (*model.User, error) {
-//	u := &model.User{}
-//	q := `SELECT id, lname, fname, phone_number FROM users WHERE id = $1`
-//	if err := r.store.db.QueryRow(
-//		q,
-//		id,
-//	).Scan(
-//		&u.ID,
-//		&u.LName,
-//		&u.FName,
-//		&u.PhoneNumber,
-//	); err != nil {
-//		if err == sql.ErrNoRows {
-//			return nil, store.ErrRecordNotFound
-//		}
-//		return nil, err
-//	}
-//	return u, nil
-//}
+func (r *UserRepository) Find(ctx context.Context, id int) (*model.User, error) {
+	ctx, cancel := r.store.withTimeout(ctx)
+	defer cancel()
+
+	return r.find(ctx, `SELECT id, lname, fname, phone_number, role FROM users WHERE id = $1`, id)
+}
+
+// find loads the user selected by q together with the hotels they manage.
+func (r *UserRepository) find(ctx context.Context, q string, arg interface{}) (*model.User, error) {
+	u := &model.User{}
+	if err := r.store.conn().QueryRowContext(ctx,
+		q,
+		arg,
+	).Scan(
+		&u.ID,
+		&u.LName,
+		&u.FName,
+		&u.PhoneNumber,
+		&u.Role,
+	); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, store.ErrRecordNotFound
+		}
+		return nil, storeError(ctx, err)
+	}
+
+	rows, err := r.store.conn().QueryContext(ctx, `SELECT hotel_id FROM hotel_managers WHERE user_id = $1 ORDER BY hotel_id`, u.ID)
+	if err != nil {
+		return nil, storeError(ctx, err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			return nil, storeError(ctx, err)
+		}
+		u.HotelIDs = append(u.HotelIDs, id)
+	}
+	return u, rows.Err()
+}
+
+// SetRole changes the role of the user and replaces the hotels they manage
+// with u.HotelIDs.
+func (r *UserRepository) SetRole(ctx context.Context, u *model.User) error {
+	ctx, cancel := r.store.withTimeout(ctx)
+	defer cancel()
+
+	return r.store.inTx(ctx, func(tx *sql.Tx) error {
+		result, err := tx.ExecContext(ctx, `UPDATE users SET role = $1 WHERE id = $2`, u.Role, u.ID)
+		if err != nil {
+			return storeError(ctx, err)
+		}
+		rows, err := result.RowsAffected()
+		if err != nil {
+			return storeError(ctx, err)
+		}
+		if rows != 1 {
+			return store.ErrRecordNotFound
+		}
+
+		if _, err := tx.ExecContext(ctx, `DELETE FROM hotel_managers WHERE user_id = $1`, u.ID); err != nil {
+			return storeError(ctx, err)
+		}
+		for _, id := range u.HotelIDs {
+			if _, err := tx.ExecContext(ctx, `INSERT INTO hotel_managers (user_id, hotel_id) VALUES ($1, $2)`, u.ID, id); err != nil {
+				return storeError(ctx, err)
+			}
+		}
+		return nil
+	})
+}
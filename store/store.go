@@ -1,6 +1,12 @@
 package store
 
+import "context"
+
 type Store interface {
+	// WithTx runs fn with a store whose repositories all work in one
+	// transaction, committed when fn returns nil and rolled back otherwise.
+	WithTx(ctx context.Context, fn func(Store) error) error
+
 	Address() AddressRepository
 	ApartmentClass() ApartmentClassRepository
 	Apartment() ApartmentRepository
@@ -8,4 +14,8 @@ type Store interface {
 	Hotel() HotelRepository
 	ApartmentImage() ApartmentImageRepository
 	Transact() TransactRepository
+	RatePlan() RatePlanRepository
+	OTP() OTPRepository
+	APIKey() APIKeyRepository
+	RefreshToken() RefreshTokenRepository
 }
@@ -0,0 +1,33 @@
+package teststore
+
+import (
+	"context"
+	"github.com/zlyaptica/hotel_service_backend/internal/app/model"
+	"github.com/zlyaptica/hotel_service_backend/store"
+)
+
+type AddressRepository struct {
+	store *Store
+}
+
+func (r *AddressRepository) Create(ctx context.Context, a *model.Address) error {
+	r.store.mu.Lock()
+	defer r.store.mu.Unlock()
+
+	a.ID = r.store.nextID("address")
+	c := *a
+	r.store.addresses[a.ID] = &c
+	return nil
+}
+
+func (r *AddressRepository) Update(ctx context.Context, a *model.Address) error {
+	r.store.mu.Lock()
+	defer r.store.mu.Unlock()
+
+	if _, ok := r.store.addresses[a.ID]; !ok {
+		return store.ErrRecordNotFound
+	}
+	c := *a
+	r.store.addresses[a.ID] = &c
+	return nil
+}
@@ -0,0 +1,47 @@
+package teststore
+
+import (
+	"context"
+	"github.com/zlyaptica/hotel_service_backend/internal/app/model"
+	"github.com/zlyaptica/hotel_service_backend/store"
+)
+
+type ApartmentClassRepository struct {
+	store *Store
+}
+
+// Create adds an apartment class. Classes are seeded in the database, so
+// only the test store can create them.
+func (r *ApartmentClassRepository) Create(ctx context.Context, ac *model.ApartmentClass) error {
+	r.store.mu.Lock()
+	defer r.store.mu.Unlock()
+
+	ac.ID = r.store.nextID("apartment_classes")
+	c := *ac
+	r.store.apartmentClasses[ac.ID] = &c
+	return nil
+}
+
+func (r *ApartmentClassRepository) FindAll(ctx context.Context, opts *store.ListOptions) ([]model.ApartmentClass, string, error) {
+	r.store.mu.Lock()
+	defer r.store.mu.Unlock()
+
+	apartmentClasses := []model.ApartmentClass{}
+	for _, ac := range r.store.apartmentClasses {
+		apartmentClasses = append(apartmentClasses, *ac)
+	}
+	keys := map[string]sortKey{
+		"id":    func(i int) sortValue { return numValue(int64(apartmentClasses[i].ID)) },
+		"class": func(i int) sortValue { return textValue(apartmentClasses[i].Class) },
+	}
+	rows, next, err := paginate(len(apartmentClasses), func(i int) int { return apartmentClasses[i].ID }, keys, "id", opts)
+	if err != nil {
+		return nil, "", err
+	}
+
+	page := make([]model.ApartmentClass, 0, len(rows))
+	for _, i := range rows {
+		page = append(page, apartmentClasses[i])
+	}
+	return page, next, nil
+}
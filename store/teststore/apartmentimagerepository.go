@@ -0,0 +1,51 @@
+package teststore
+
+import (
+	"context"
+	"github.com/zlyaptica/hotel_service_backend/internal/app/model"
+	"sort"
+)
+
+type ApartmentImageRepository struct {
+	store *Store
+}
+
+// Create adds an image of the hotel hotelID. Like in the apartment_images
+// table, images belong to hotels.
+func (r *ApartmentImageRepository) Create(ctx context.Context, hotelID int, i *model.ApartmentImage) error {
+	r.store.mu.Lock()
+	defer r.store.mu.Unlock()
+
+	if _, ok := r.store.hotels[hotelID]; !ok {
+		return errInvalidReference
+	}
+	i.ID = r.store.nextID("apartment_images")
+	r.store.apartmentImages[i.ID] = &model.ApartmentImage{
+		ID:        i.ID,
+		Apartment: &model.Apartment{ID: hotelID},
+		Address:   i.Address,
+	}
+	return nil
+}
+
+// GetImagesByHotelID returns the images of the hotel. As in sqlstore, the
+// hotel id is reported as the id of the apartment of the image.
+func (r *ApartmentImageRepository) GetImagesByHotelID(ctx context.Context, id int) ([]model.ApartmentImage, error) {
+	r.store.mu.Lock()
+	defer r.store.mu.Unlock()
+
+	images := []model.ApartmentImage{}
+	for _, i := range r.store.apartmentImages {
+		if i.Apartment.ID == id {
+			images = append(images, model.ApartmentImage{
+				ID:        i.ID,
+				Apartment: &model.Apartment{ID: id},
+				Address:   i.Address,
+			})
+		}
+	}
+	sort.Slice(images, func(a, b int) bool {
+		return images[a].ID < images[b].ID
+	})
+	return images, nil
+}
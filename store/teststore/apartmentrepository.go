@@ -0,0 +1,105 @@
+package teststore
+
+import (
+	"context"
+	"github.com/zlyaptica/hotel_service_backend/internal/app/model"
+	"github.com/zlyaptica/hotel_service_backend/store"
+	"time"
+)
+
+type ApartmentRepository struct {
+	store *Store
+}
+
+func (r *ApartmentRepository) Create(ctx context.Context, a *model.Apartment) error {
+	r.store.mu.Lock()
+	defer r.store.mu.Unlock()
+
+	if _, ok := r.store.hotels[a.Hotel.ID]; !ok {
+		return errInvalidReference
+	}
+	if _, ok := r.store.apartmentClasses[a.ApartmentClass.ID]; !ok {
+		return errInvalidReference
+	}
+
+	a.ID = r.store.nextID("apartments")
+	r.store.apartments[a.ID] = &model.Apartment{
+		ID:             a.ID,
+		Name:           a.Name,
+		Hotel:          &model.Hotel{ID: a.Hotel.ID},
+		ApartmentClass: &model.ApartmentClass{ID: a.ApartmentClass.ID},
+		BedCount:       a.BedCount,
+		Price:          model.Money{Amount: a.Price.Amount},
+	}
+	return nil
+}
+
+func (r *ApartmentRepository) Find(ctx context.Context, id int) (*model.Apartment, error) {
+	r.store.mu.Lock()
+	defer r.store.mu.Unlock()
+
+	a, ok := r.store.apartments[id]
+	if !ok {
+		return nil, store.ErrRecordNotFound
+	}
+	return r.store.apartment(a), nil
+}
+
+func (r *ApartmentRepository) FindByHotelID(ctx context.Context, id int, opts *store.ListOptions) ([]model.Apartment, string, error) {
+	return r.find(func(a *model.Apartment) bool {
+		return a.Hotel.ID == id
+	}, opts)
+}
+
+// FindAvailableByHotelID returns the apartments of the hotel that have no active
+// stay overlapping the [arrival, departure) range.
+func (r *ApartmentRepository) FindAvailableByHotelID(ctx context.Context, id int, arrival, departure time.Time, opts *store.ListOptions) ([]model.Apartment, string, error) {
+	return r.find(func(a *model.Apartment) bool {
+		return a.Hotel.ID == id && !r.store.booked(a.ID, arrival, departure, 0)
+	}, opts)
+}
+
+// find returns a page of the apartments matching the condition.
+func (r *ApartmentRepository) find(cond func(a *model.Apartment) bool, opts *store.ListOptions) ([]model.Apartment, string, error) {
+	r.store.mu.Lock()
+	defer r.store.mu.Unlock()
+
+	apartments := []model.Apartment{}
+	for _, a := range r.store.apartments {
+		if cond(a) {
+			c := r.store.apartment(a)
+			c.ApartmentClass.ID = 0
+			apartments = append(apartments, *c)
+		}
+	}
+	keys := map[string]sortKey{
+		"id":        func(i int) sortValue { return numValue(int64(apartments[i].ID)) },
+		"price":     func(i int) sortValue { return numValue(apartments[i].Price.Amount) },
+		"bed_count": func(i int) sortValue { return numValue(int64(apartments[i].BedCount)) },
+		"name":      func(i int) sortValue { return textValue(apartments[i].Name) },
+	}
+	rows, next, err := paginate(len(apartments), func(i int) int { return apartments[i].ID }, keys, "id", opts)
+	if err != nil {
+		return nil, "", err
+	}
+
+	page := make([]model.Apartment, 0, len(rows))
+	for _, i := range rows {
+		page = append(page, apartments[i])
+	}
+	return page, next, nil
+}
+
+// apartment returns a copy of a priced in the currency of its hotel.
+func (s *Store) apartment(a *model.Apartment) *model.Apartment {
+	c := *a
+	c.Hotel = &model.Hotel{ID: a.Hotel.ID}
+	c.ApartmentClass = &model.ApartmentClass{ID: a.ApartmentClass.ID}
+	if ac, ok := s.apartmentClasses[a.ApartmentClass.ID]; ok {
+		c.ApartmentClass.Class = ac.Class
+	}
+	if h, ok := s.hotels[a.Hotel.ID]; ok {
+		c.Price.Currency = h.Currency
+	}
+	return &c
+}
@@ -0,0 +1,134 @@
+package teststore
+
+import (
+	"context"
+	"github.com/zlyaptica/hotel_service_backend/internal/app/model"
+	"github.com/zlyaptica/hotel_service_backend/store"
+	"sort"
+	"time"
+)
+
+type APIKeyRepository struct {
+	store *Store
+}
+
+func (r *APIKeyRepository) Create(ctx context.Context, k *model.APIKey) error {
+	r.store.mu.Lock()
+	defer r.store.mu.Unlock()
+
+	if r.store.apiKeyByHash(k.KeyHash) != nil {
+		return errAlreadyExists
+	}
+	for _, id := range k.HotelIDs {
+		if _, ok := r.store.hotels[id]; !ok {
+			return errInvalidReference
+		}
+	}
+	k.ID = r.store.nextID("api_keys")
+	c := copyAPIKey(k)
+	c.Key = ""
+	r.store.apiKeys[k.ID] = c
+	return nil
+}
+
+func (r *APIKeyRepository) Find(ctx context.Context, id int) (*model.APIKey, error) {
+	r.store.mu.Lock()
+	defer r.store.mu.Unlock()
+
+	k, ok := r.store.apiKeys[id]
+	if !ok {
+		return nil, store.ErrRecordNotFound
+	}
+	return copyAPIKey(k), nil
+}
+
+// FindByHash returns the key with the hash, revoked or not.
+func (r *APIKeyRepository) FindByHash(ctx context.Context, hash string) (*model.APIKey, error) {
+	r.store.mu.Lock()
+	defer r.store.mu.Unlock()
+
+	k := r.store.apiKeyByHash(hash)
+	if k == nil {
+		return nil, store.ErrRecordNotFound
+	}
+	return copyAPIKey(k), nil
+}
+
+func (r *APIKeyRepository) FindAll(ctx context.Context) ([]model.APIKey, error) {
+	r.store.mu.Lock()
+	defer r.store.mu.Unlock()
+
+	keys := []model.APIKey{}
+	for _, k := range r.store.apiKeys {
+		keys = append(keys, *copyAPIKey(k))
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		return keys[i].ID < keys[j].ID
+	})
+	return keys, nil
+}
+
+// Rotate replaces the secret of an active key with k.Prefix and k.KeyHash.
+func (r *APIKeyRepository) Rotate(ctx context.Context, k *model.APIKey) error {
+	r.store.mu.Lock()
+	defer r.store.mu.Unlock()
+
+	current, ok := r.store.apiKeys[k.ID]
+	if !ok || current.RevokedAt != nil {
+		return store.ErrRecordNotFound
+	}
+	if other := r.store.apiKeyByHash(k.KeyHash); other != nil && other.ID != k.ID {
+		return errAlreadyExists
+	}
+	current.Prefix = k.Prefix
+	current.KeyHash = k.KeyHash
+	return nil
+}
+
+// Revoke disables an active key for good.
+func (r *APIKeyRepository) Revoke(ctx context.Context, id int, at time.Time) error {
+	r.store.mu.Lock()
+	defer r.store.mu.Unlock()
+
+	k, ok := r.store.apiKeys[id]
+	if !ok || k.RevokedAt != nil {
+		return store.ErrRecordNotFound
+	}
+	k.RevokedAt = &at
+	return nil
+}
+
+// Touch records that the key was used at the given time.
+func (r *APIKeyRepository) Touch(ctx context.Context, id int, at time.Time) error {
+	r.store.mu.Lock()
+	defer r.store.mu.Unlock()
+
+	if k, ok := r.store.apiKeys[id]; ok {
+		k.LastUsedAt = &at
+	}
+	return nil
+}
+
+// apiKeyByHash returns the stored key with the hash, or nil.
+func (s *Store) apiKeyByHash(hash string) *model.APIKey {
+	for _, k := range s.apiKeys {
+		if k.KeyHash == hash {
+			return k
+		}
+	}
+	return nil
+}
+
+func copyAPIKey(k *model.APIKey) *model.APIKey {
+	c := *k
+	c.HotelIDs = append([]int(nil), k.HotelIDs...)
+	if k.LastUsedAt != nil {
+		at := *k.LastUsedAt
+		c.LastUsedAt = &at
+	}
+	if k.RevokedAt != nil {
+		at := *k.RevokedAt
+		c.RevokedAt = &at
+	}
+	return &c
+}
@@ -0,0 +1,17 @@
+package teststore
+
+import "github.com/zlyaptica/hotel_service_backend/store"
+
+// The errors sqlstore returns for violated constraints.
+var (
+	errAlreadyExists = &store.Error{
+		Kind:    store.KindConflict,
+		Code:    "already_exists",
+		Message: "record already exists",
+	}
+	errInvalidReference = &store.Error{
+		Kind:    store.KindValidation,
+		Code:    "invalid_reference",
+		Message: "referenced record does not exist",
+	}
+)
@@ -0,0 +1,275 @@
+package teststore
+
+import (
+	"context"
+	"github.com/zlyaptica/hotel_service_backend/internal/app/model"
+	"github.com/zlyaptica/hotel_service_backend/store"
+	"html"
+	"math"
+	"math/big"
+	"strings"
+	"unicode"
+)
+
+type HotelRepository struct {
+	store *Store
+}
+
+// Create inserts the hotel located at the already created hotel.Address.
+func (r *HotelRepository) Create(ctx context.Context, hotel *model.Hotel) error {
+	r.store.mu.Lock()
+	defer r.store.mu.Unlock()
+
+	if _, ok := r.store.addresses[hotel.Address.ID]; !ok {
+		return errInvalidReference
+	}
+	hotel.ID = r.store.nextID("hotels")
+	h := copyHotel(hotel)
+	h.Address = &model.Address{ID: hotel.Address.ID}
+	r.store.hotels[hotel.ID] = h
+	return nil
+}
+
+// Update saves the hotel. Its address is saved by AddressRepository.Update.
+func (r *HotelRepository) Update(ctx context.Context, hotel *model.Hotel) error {
+	r.store.mu.Lock()
+	defer r.store.mu.Unlock()
+
+	current, ok := r.store.hotels[hotel.ID]
+	if !ok {
+		return store.ErrRecordNotFound
+	}
+	h := copyHotel(hotel)
+	h.Address = &model.Address{ID: current.Address.ID}
+	r.store.hotels[hotel.ID] = h
+	return nil
+}
+
+func (r *HotelRepository) FindAll(ctx context.Context, f *store.HotelFilter, opts *store.ListOptions) ([]model.Hotel, string, error) {
+	r.store.mu.Lock()
+	defer r.store.mu.Unlock()
+
+	hotels := []model.Hotel{}
+	for _, h := range r.store.hotels {
+		if c := r.store.hotel(h); r.store.hotelMatches(c, f) {
+			hotels = append(hotels, *c)
+		}
+	}
+	keys := map[string]sortKey{
+		"id":    func(i int) sortValue { return numValue(int64(hotels[i].ID)) },
+		"name":  func(i int) sortValue { return textValue(hotels[i].Name) },
+		"stars": func(i int) sortValue { return numValue(int64(hotels[i].StarsCount)) },
+		"price": func(i int) sortValue {
+			return numValue(priceSortValue(hotels[i].MinPrice, f, opts))
+		},
+	}
+	rows, next, err := paginate(len(hotels), func(i int) int { return hotels[i].ID }, keys, "id", opts)
+	if err != nil {
+		return nil, "", err
+	}
+
+	page := make([]model.Hotel, 0, len(rows))
+	for _, i := range rows {
+		page = append(page, hotels[i])
+	}
+	return page, next, nil
+}
+
+// priceSortValue converts the price with the rates of f like sqlstore does.
+// Missing prices come last in either direction.
+func priceSortValue(price *model.Money, f *store.HotelFilter, opts *store.ListOptions) int64 {
+	var rate *big.Rat
+	if price != nil && f != nil {
+		rate = f.PriceRates[price.Currency]
+	}
+	if rate == nil {
+		if opts != nil && opts.Desc {
+			return -1
+		}
+		return math.MaxInt64
+	}
+	v := new(big.Rat).Mul(new(big.Rat).SetInt64(price.Amount), rate)
+	return new(big.Int).Quo(v.Num(), v.Denom()).Int64()
+}
+
+func (r *HotelRepository) Find(ctx context.Context, id int) (*model.Hotel, error) {
+	r.store.mu.Lock()
+	defer r.store.mu.Unlock()
+
+	h, ok := r.store.hotels[id]
+	if !ok {
+		return nil, store.ErrRecordNotFound
+	}
+	return r.store.hotel(h), nil
+}
+
+// Search finds the hotels whose name, description or address contain every
+// word of the text, ignoring case. Hotels are ranked by the number of
+// occurrences of the words, since there is no stemming in memory.
+func (r *HotelRepository) Search(ctx context.Context, text string, opts *store.ListOptions) ([]model.HotelSearchResult, string, error) {
+	r.store.mu.Lock()
+	defer r.store.mu.Unlock()
+
+	words := strings.Fields(lower(text))
+	results := []model.HotelSearchResult{}
+	for _, stored := range r.store.hotels {
+		h := r.store.hotel(stored)
+		document := lower(strings.Join([]string{h.Name, h.Description, h.Address.Country, h.Address.City, h.Address.Street}, " "))
+		rank := 0
+		for _, w := range words {
+			n := strings.Count(document, w)
+			if n == 0 {
+				rank = 0
+				break
+			}
+			rank += n
+		}
+		if rank == 0 {
+			continue
+		}
+		results = append(results, model.HotelSearchResult{
+			Hotel:   h,
+			Rank:    float64(rank),
+			Name:    highlight(h.Name, words),
+			Snippet: highlight(h.Description, words),
+		})
+	}
+	keys := map[string]sortKey{
+		"rank": func(i int) sortValue { return numValue(int64(results[i].Rank)) },
+	}
+	rows, next, err := paginate(len(results), func(i int) int { return results[i].Hotel.ID }, keys, "rank", opts)
+	if err != nil {
+		return nil, "", err
+	}
+
+	page := make([]model.HotelSearchResult, 0, len(rows))
+	for _, i := range rows {
+		page = append(page, results[i])
+	}
+	return page, next, nil
+}
+
+// hotel returns a copy of h with its address and the lowest price of its
+// apartments.
+func (s *Store) hotel(h *model.Hotel) *model.Hotel {
+	c := copyHotel(h)
+	if a, ok := s.addresses[h.Address.ID]; ok {
+		addr := *a
+		c.Address = &addr
+	}
+	for _, a := range s.apartments {
+		if a.Hotel.ID == h.ID && (c.MinPrice == nil || a.Price.Amount < c.MinPrice.Amount) {
+			c.MinPrice = &model.Money{Amount: a.Price.Amount, Currency: h.Currency}
+		}
+	}
+	return c
+}
+
+// hotelMatches reports whether the hotel, read with its address, matches the
+// filter with the same rules as the hotel conditions of sqlstore.
+func (s *Store) hotelMatches(h *model.Hotel, f *store.HotelFilter) bool {
+	if f == nil {
+		return true
+	}
+	if f.Country != "" && !strings.EqualFold(h.Address.Country, f.Country) {
+		return false
+	}
+	if f.City != "" && !strings.EqualFold(h.Address.City, f.City) {
+		return false
+	}
+	if f.MinStars != 0 && h.StarsCount < f.MinStars {
+		return false
+	}
+	if f.MaxStars != 0 && h.StarsCount > f.MaxStars {
+		return false
+	}
+
+	dates := !f.DateArrival.IsZero() && !f.DateDeparture.IsZero()
+	if f.MinBedCount == 0 && f.ApartmentClassID == 0 && len(f.PriceRanges) == 0 && !dates {
+		return true
+	}
+	for _, a := range s.apartments {
+		if a.Hotel.ID != h.ID {
+			continue
+		}
+		if f.MinBedCount != 0 && a.BedCount < f.MinBedCount {
+			continue
+		}
+		if f.ApartmentClassID != 0 && a.ApartmentClass.ID != f.ApartmentClassID {
+			continue
+		}
+		if len(f.PriceRanges) != 0 && !inPriceRanges(a.Price.Amount, h.Currency, f.PriceRanges) {
+			continue
+		}
+		if dates && s.booked(a.ID, f.DateArrival, f.DateDeparture, 0) {
+			continue
+		}
+		return true
+	}
+	return false
+}
+
+func inPriceRanges(amount int64, currency string, ranges []store.PriceRange) bool {
+	for _, pr := range ranges {
+		if pr.Currency != currency {
+			continue
+		}
+		if pr.Min != nil && amount < *pr.Min {
+			continue
+		}
+		if pr.Max != nil && amount > *pr.Max {
+			continue
+		}
+		return true
+	}
+	return false
+}
+
+func copyHotel(h *model.Hotel) *model.Hotel {
+	c := *h
+	if h.Address != nil {
+		a := *h.Address
+		c.Address = &a
+	}
+	if h.CancellationPolicy != nil {
+		p := *h.CancellationPolicy
+		c.CancellationPolicy = &p
+	}
+	c.MinPrice = nil
+	return &c
+}
+
+// lower lower-cases s rune by rune, so offsets in runes don't change.
+func lower(s string) string {
+	return strings.Map(unicode.ToLower, s)
+}
+
+// highlight wraps the occurrences of the lower-case words in text in <mark>
+// tags and escapes the rest of it, like the headlines of sqlstore.
+func highlight(text string, words []string) string {
+	runes := []rune(text)
+	lowered := []rune(lower(text))
+	marked := make([]bool, len(runes))
+	for _, w := range words {
+		wr := []rune(w)
+		for i := 0; i+len(wr) <= len(lowered); i++ {
+			if string(lowered[i:i+len(wr)]) == w {
+				for j := i; j < i+len(wr); j++ {
+					marked[j] = true
+				}
+			}
+		}
+	}
+
+	b := strings.Builder{}
+	for i, c := range runes {
+		if marked[i] && (i == 0 || !marked[i-1]) {
+			b.WriteString("<mark>")
+		}
+		b.WriteString(html.EscapeString(string(c)))
+		if marked[i] && (i == len(runes)-1 || !marked[i+1]) {
+			b.WriteString("</mark>")
+		}
+	}
+	return b.String()
+}
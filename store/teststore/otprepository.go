@@ -0,0 +1,85 @@
+package teststore
+
+import (
+	"context"
+	"github.com/zlyaptica/hotel_service_backend/internal/app/model"
+	"github.com/zlyaptica/hotel_service_backend/store"
+	"time"
+)
+
+type OTPRepository struct {
+	store *Store
+}
+
+func (r *OTPRepository) Create(ctx context.Context, o *model.OTP) error {
+	r.store.mu.Lock()
+	defer r.store.mu.Unlock()
+
+	o.ID = r.store.nextID("otp_codes")
+	c := *o
+	r.store.otps[o.ID] = &c
+	return nil
+}
+
+// FindLatest returns the last code sent to the phone number.
+func (r *OTPRepository) FindLatest(ctx context.Context, phoneNumber string) (*model.OTP, error) {
+	r.store.mu.Lock()
+	defer r.store.mu.Unlock()
+
+	var latest *model.OTP
+	for _, o := range r.store.otps {
+		if o.PhoneNumber != phoneNumber {
+			continue
+		}
+		if latest == nil || o.CreatedAt.After(latest.CreatedAt) ||
+			(o.CreatedAt.Equal(latest.CreatedAt) && o.ID > latest.ID) {
+			latest = o
+		}
+	}
+	if latest == nil {
+		return nil, store.ErrRecordNotFound
+	}
+	c := *latest
+	return &c, nil
+}
+
+func (r *OTPRepository) IncrementAttempts(ctx context.Context, id, max int) error {
+	r.store.mu.Lock()
+	defer r.store.mu.Unlock()
+
+	o, ok := r.store.otps[id]
+	if !ok || o.Attempts >= max {
+		return store.ErrRecordNotFound
+	}
+	o.Attempts++
+	return nil
+}
+
+// CountAttempts returns the number of attempts made with the codes sent to
+// the phone number since the time.
+func (r *OTPRepository) CountAttempts(ctx context.Context, phoneNumber string, since time.Time) (int, error) {
+	r.store.mu.Lock()
+	defer r.store.mu.Unlock()
+
+	attempts := 0
+	for _, o := range r.store.otps {
+		if o.PhoneNumber == phoneNumber && !o.CreatedAt.Before(since) {
+			attempts += o.Attempts
+		}
+	}
+	return attempts, nil
+}
+
+// DeleteByPhoneNumber removes the codes sent to the phone number before the
+// time.
+func (r *OTPRepository) DeleteByPhoneNumber(ctx context.Context, phoneNumber string, before time.Time) error {
+	r.store.mu.Lock()
+	defer r.store.mu.Unlock()
+
+	for id, o := range r.store.otps {
+		if o.PhoneNumber == phoneNumber && o.CreatedAt.Before(before) {
+			delete(r.store.otps, id)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,115 @@
+package teststore
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"github.com/zlyaptica/hotel_service_backend/store"
+	"sort"
+	"strings"
+)
+
+// sortValue is the value a row is ordered by: a number or a text.
+type sortValue struct {
+	Num  int64  `json:"n,omitempty"`
+	Text string `json:"t,omitempty"`
+}
+
+func (v sortValue) compare(o sortValue) int {
+	switch {
+	case v.Num < o.Num:
+		return -1
+	case v.Num > o.Num:
+		return 1
+	}
+	return strings.Compare(v.Text, o.Text)
+}
+
+// sortKey returns the sort value of the i-th row of a list.
+type sortKey func(i int) sortValue
+
+// cursor points past the last row of a page, like the cursors of sqlstore.
+type cursor struct {
+	Sort  string    `json:"s"`
+	Desc  bool      `json:"d"`
+	Value sortValue `json:"v"`
+	ID    int       `json:"id"`
+}
+
+// paginate orders the n rows of a list by the sort key selected in opts with
+// the id as a tie-breaker. It returns the indexes of the rows of the page and
+// the cursor of the next page, or an empty string on the last page.
+func paginate(n int, id func(i int) int, keys map[string]sortKey, defaultSort string, opts *store.ListOptions) ([]int, string, error) {
+	if opts == nil {
+		opts = &store.ListOptions{}
+	}
+	name := opts.Sort
+	if name == "" {
+		name = defaultSort
+	}
+	key, ok := keys[name]
+	if !ok {
+		return nil, "", store.ErrInvalidSort
+	}
+	limit := opts.Limit
+	if limit <= 0 || limit > store.MaxLimit {
+		limit = store.DefaultLimit
+	}
+
+	var after *cursor
+	if opts.Cursor != "" {
+		data, err := base64.RawURLEncoding.DecodeString(opts.Cursor)
+		if err != nil {
+			return nil, "", store.ErrInvalidCursor
+		}
+		c := &cursor{}
+		if err := json.Unmarshal(data, c); err != nil {
+			return nil, "", store.ErrInvalidCursor
+		}
+		if c.Sort != name || c.Desc != opts.Desc {
+			return nil, "", store.ErrInvalidCursor
+		}
+		after = c
+	}
+
+	// compare orders the rows ascending; descending lists reverse it.
+	compare := func(v sortValue, vID int, o sortValue, oID int) int {
+		c := v.compare(o)
+		if c == 0 {
+			c = vID - oID
+		}
+		if opts.Desc {
+			c = -c
+		}
+		return c
+	}
+
+	rows := []int{}
+	for i := 0; i < n; i++ {
+		if after == nil || compare(key(i), id(i), after.Value, after.ID) > 0 {
+			rows = append(rows, i)
+		}
+	}
+	sort.Slice(rows, func(a, b int) bool {
+		return compare(key(rows[a]), id(rows[a]), key(rows[b]), id(rows[b])) < 0
+	})
+
+	if len(rows) <= limit {
+		return rows, "", nil
+	}
+	last := rows[limit-1]
+	data, _ := json.Marshal(&cursor{
+		Sort:  name,
+		Desc:  opts.Desc,
+		Value: key(last),
+		ID:    id(last),
+	})
+	return rows[:limit], base64.RawURLEncoding.EncodeToString(data), nil
+}
+
+func numValue(n int64) sortValue {
+	return sortValue{Num: n}
+}
+
+func textValue(s string) sortValue {
+	return sortValue{Text: s}
+}
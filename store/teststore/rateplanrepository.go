@@ -0,0 +1,65 @@
+package teststore
+
+import (
+	"context"
+	"github.com/zlyaptica/hotel_service_backend/internal/app/model"
+	"sort"
+)
+
+type RatePlanRepository struct {
+	store *Store
+}
+
+// Find returns the rate plan of the apartment. An apartment without a plan
+// gets an empty one, which prices every night at the base price.
+func (r *RatePlanRepository) Find(ctx context.Context, apartmentID int) (*model.RatePlan, error) {
+	r.store.mu.Lock()
+	defer r.store.mu.Unlock()
+
+	p := &model.RatePlan{
+		ApartmentID:   apartmentID,
+		Seasons:       []model.SeasonalRate{},
+		StayDiscounts: []model.StayDiscount{},
+	}
+	saved, ok := r.store.ratePlans[apartmentID]
+	if !ok {
+		return p, nil
+	}
+
+	currency := ""
+	if a, ok := r.store.apartments[apartmentID]; ok {
+		currency = r.store.apartment(a).Price.Currency
+	}
+	p.WeekendSurchargePercent = saved.WeekendSurchargePercent
+	p.MinStay = saved.MinStay
+	for _, s := range saved.Seasons {
+		s.Price.Currency = currency
+		p.Seasons = append(p.Seasons, s)
+	}
+	p.StayDiscounts = append(p.StayDiscounts, saved.StayDiscounts...)
+	sort.SliceStable(p.Seasons, func(i, j int) bool {
+		return p.Seasons[i].DateFrom.Before(p.Seasons[j].DateFrom)
+	})
+	sort.SliceStable(p.StayDiscounts, func(i, j int) bool {
+		return p.StayDiscounts[i].MinNights < p.StayDiscounts[j].MinNights
+	})
+	return p, nil
+}
+
+// Save replaces the rate plan of p.ApartmentID with p.
+func (r *RatePlanRepository) Save(ctx context.Context, p *model.RatePlan) error {
+	r.store.mu.Lock()
+	defer r.store.mu.Unlock()
+
+	if _, ok := r.store.apartments[p.ApartmentID]; !ok {
+		return errInvalidReference
+	}
+	r.store.ratePlans[p.ApartmentID] = &model.RatePlan{
+		ApartmentID:             p.ApartmentID,
+		WeekendSurchargePercent: p.WeekendSurchargePercent,
+		MinStay:                 p.MinStay,
+		Seasons:                 append([]model.SeasonalRate{}, p.Seasons...),
+		StayDiscounts:           append([]model.StayDiscount{}, p.StayDiscounts...),
+	}
+	return nil
+}
@@ -0,0 +1,75 @@
+package teststore
+
+import (
+	"context"
+	"github.com/zlyaptica/hotel_service_backend/internal/app/model"
+	"github.com/zlyaptica/hotel_service_backend/store"
+	"time"
+)
+
+type RefreshTokenRepository struct {
+	store *Store
+}
+
+func (r *RefreshTokenRepository) Create(ctx context.Context, t *model.RefreshToken) error {
+	r.store.mu.Lock()
+	defer r.store.mu.Unlock()
+
+	if _, ok := r.store.users[t.UserID]; !ok {
+		return errInvalidReference
+	}
+	t.ID = r.store.nextID("refresh_tokens")
+	c := *t
+	r.store.refreshTokens[t.ID] = &c
+	return nil
+}
+
+// FindByHash returns the token with the hash, revoked or not.
+func (r *RefreshTokenRepository) FindByHash(ctx context.Context, hash string) (*model.RefreshToken, error) {
+	r.store.mu.Lock()
+	defer r.store.mu.Unlock()
+
+	for _, t := range r.store.refreshTokens {
+		if t.TokenHash == hash {
+			return copyRefreshToken(t), nil
+		}
+	}
+	return nil, store.ErrRecordNotFound
+}
+
+// Revoke revokes an active token. It returns store.ErrRecordNotFound when
+// the token was revoked already.
+func (r *RefreshTokenRepository) Revoke(ctx context.Context, id int, at time.Time) error {
+	r.store.mu.Lock()
+	defer r.store.mu.Unlock()
+
+	t, ok := r.store.refreshTokens[id]
+	if !ok || t.RevokedAt != nil {
+		return store.ErrRecordNotFound
+	}
+	t.RevokedAt = &at
+	return nil
+}
+
+// RevokeByUserID revokes all active tokens of the user.
+func (r *RefreshTokenRepository) RevokeByUserID(ctx context.Context, userID int, at time.Time) error {
+	r.store.mu.Lock()
+	defer r.store.mu.Unlock()
+
+	for _, t := range r.store.refreshTokens {
+		if t.UserID == userID && t.RevokedAt == nil {
+			revokedAt := at
+			t.RevokedAt = &revokedAt
+		}
+	}
+	return nil
+}
+
+func copyRefreshToken(t *model.RefreshToken) *model.RefreshToken {
+	c := *t
+	if t.RevokedAt != nil {
+		at := *t.RevokedAt
+		c.RevokedAt = &at
+	}
+	return &c
+}
@@ -0,0 +1,303 @@
+package teststore
+
+import (
+	"context"
+	"github.com/zlyaptica/hotel_service_backend/internal/app/model"
+	"github.com/zlyaptica/hotel_service_backend/store"
+	"sync"
+)
+
+// Store keeps all records in memory. It behaves like sqlstore, returning the
+// same errors, so handlers can be tested without PostgreSQL. Records are
+// copied in and out, so callers never share them with the store.
+type Store struct {
+	mu sync.Mutex
+	// txMu lets one transaction run at a time.
+	txMu sync.Mutex
+	tables
+
+	addressRepository        *AddressRepository
+	apartmentClassRepository *ApartmentClassRepository
+	apartmentRepository      *ApartmentRepository
+	userRepository           *UserRepository
+	hotelRepository          *HotelRepository
+	apartmentImageRepository *ApartmentImageRepository
+	transactRepository       *TransactRepository
+	ratePlanRepository       *RatePlanRepository
+	otpRepository            *OTPRepository
+	apiKeyRepository         *APIKeyRepository
+	refreshTokenRepository   *RefreshTokenRepository
+}
+
+// tables holds the records of a store, keyed by id like the tables of
+// sqlstore.
+type tables struct {
+	seq map[string]int
+
+	addresses        map[int]*model.Address
+	hotels           map[int]*model.Hotel
+	apartments       map[int]*model.Apartment
+	apartmentClasses map[int]*model.ApartmentClass
+	apartmentImages  map[int]*model.ApartmentImage
+	users            map[int]*model.User
+	transacts        map[int]*model.Transact
+	transactHistory  []model.TransactVersion
+	ratePlans        map[int]*model.RatePlan
+	otps             map[int]*model.OTP
+	apiKeys          map[int]*model.APIKey
+	refreshTokens    map[int]*model.RefreshToken
+}
+
+func New() *Store {
+	return &Store{
+		tables: tables{
+			seq:              map[string]int{},
+			addresses:        map[int]*model.Address{},
+			hotels:           map[int]*model.Hotel{},
+			apartments:       map[int]*model.Apartment{},
+			apartmentClasses: map[int]*model.ApartmentClass{},
+			apartmentImages:  map[int]*model.ApartmentImage{},
+			users:            map[int]*model.User{},
+			transacts:        map[int]*model.Transact{},
+			ratePlans:        map[int]*model.RatePlan{},
+			otps:             map[int]*model.OTP{},
+			apiKeys:          map[int]*model.APIKey{},
+			refreshTokens:    map[int]*model.RefreshToken{},
+		},
+	}
+}
+
+// WithTx runs fn with the store in a transaction. When fn fails, all
+// records are put back as they were before, like a rolled back transaction.
+// Changes made outside of the transaction meanwhile are lost too, which
+// doesn't matter in tests. Called within fn, it runs in the transaction
+// already open, like sqlstore.
+func (s *Store) WithTx(ctx context.Context, fn func(store.Store) error) error {
+	s.txMu.Lock()
+	defer s.txMu.Unlock()
+
+	s.mu.Lock()
+	saved := s.tables.clone()
+	s.mu.Unlock()
+
+	if err := fn(&txStore{s}); err != nil {
+		s.mu.Lock()
+		s.tables = saved
+		s.mu.Unlock()
+		return err
+	}
+	return nil
+}
+
+// txStore is the store given to the function run by WithTx. Holding txMu
+// already, it runs nested transactions in the open one instead of waiting
+// for itself.
+type txStore struct {
+	*Store
+}
+
+func (s *txStore) WithTx(ctx context.Context, fn func(store.Store) error) error {
+	return fn(s)
+}
+
+// nextID returns the next id of the table, like a serial column.
+func (s *Store) nextID(table string) int {
+	s.seq[table]++
+	return s.seq[table]
+}
+
+// clone returns a deep copy of the tables.
+func (t *tables) clone() tables {
+	c := tables{
+		seq:              map[string]int{},
+		addresses:        map[int]*model.Address{},
+		hotels:           map[int]*model.Hotel{},
+		apartments:       map[int]*model.Apartment{},
+		apartmentClasses: map[int]*model.ApartmentClass{},
+		apartmentImages:  map[int]*model.ApartmentImage{},
+		users:            map[int]*model.User{},
+		transacts:        map[int]*model.Transact{},
+		transactHistory:  append([]model.TransactVersion(nil), t.transactHistory...),
+		ratePlans:        map[int]*model.RatePlan{},
+		otps:             map[int]*model.OTP{},
+		apiKeys:          map[int]*model.APIKey{},
+		refreshTokens:    map[int]*model.RefreshToken{},
+	}
+	for k, v := range t.seq {
+		c.seq[k] = v
+	}
+	for id, a := range t.addresses {
+		addr := *a
+		c.addresses[id] = &addr
+	}
+	for id, h := range t.hotels {
+		c.hotels[id] = copyHotel(h)
+	}
+	for id, a := range t.apartments {
+		ap := *a
+		c.apartments[id] = &ap
+	}
+	for id, ac := range t.apartmentClasses {
+		class := *ac
+		c.apartmentClasses[id] = &class
+	}
+	for id, i := range t.apartmentImages {
+		img := *i
+		c.apartmentImages[id] = &img
+	}
+	for id, u := range t.users {
+		c.users[id] = copyUser(u)
+	}
+	for id, tr := range t.transacts {
+		c.transacts[id] = copyTransact(tr)
+	}
+	for id, p := range t.ratePlans {
+		plan := *p
+		plan.Seasons = append([]model.SeasonalRate(nil), p.Seasons...)
+		plan.StayDiscounts = append([]model.StayDiscount(nil), p.StayDiscounts...)
+		c.ratePlans[id] = &plan
+	}
+	for id, o := range t.otps {
+		otp := *o
+		c.otps[id] = &otp
+	}
+	for id, k := range t.apiKeys {
+		c.apiKeys[id] = copyAPIKey(k)
+	}
+	for id, rt := range t.refreshTokens {
+		c.refreshTokens[id] = copyRefreshToken(rt)
+	}
+	return c
+}
+
+func (s *Store) Address() store.AddressRepository {
+	if s.addressRepository != nil {
+		return s.addressRepository
+	}
+
+	s.addressRepository = &AddressRepository{
+		store: s,
+	}
+
+	return s.addressRepository
+}
+
+func (s *Store) ApartmentClass() store.ApartmentClassRepository {
+	if s.apartmentClassRepository != nil {
+		return s.apartmentClassRepository
+	}
+
+	s.apartmentClassRepository = &ApartmentClassRepository{
+		store: s,
+	}
+
+	return s.apartmentClassRepository
+}
+
+func (s *Store) Apartment() store.ApartmentRepository {
+	if s.apartmentRepository != nil {
+		return s.apartmentRepository
+	}
+
+	s.apartmentRepository = &ApartmentRepository{
+		store: s,
+	}
+
+	return s.apartmentRepository
+}
+
+func (s *Store) User() store.UserRepository {
+	if s.userRepository != nil {
+		return s.userRepository
+	}
+
+	s.userRepository = &UserRepository{
+		store: s,
+	}
+
+	return s.userRepository
+}
+
+func (s *Store) Hotel() store.HotelRepository {
+	if s.hotelRepository != nil {
+		return s.hotelRepository
+	}
+
+	s.hotelRepository = &HotelRepository{
+		store: s,
+	}
+
+	return s.hotelRepository
+}
+
+func (s *Store) ApartmentImage() store.ApartmentImageRepository {
+	if s.apartmentImageRepository != nil {
+		return s.apartmentImageRepository
+	}
+
+	s.apartmentImageRepository = &ApartmentImageRepository{
+		store: s,
+	}
+
+	return s.apartmentImageRepository
+}
+
+func (s *Store) Transact() store.TransactRepository {
+	if s.transactRepository != nil {
+		return s.transactRepository
+	}
+
+	s.transactRepository = &TransactRepository{
+		store: s,
+	}
+
+	return s.transactRepository
+}
+
+func (s *Store) RatePlan() store.RatePlanRepository {
+	if s.ratePlanRepository != nil {
+		return s.ratePlanRepository
+	}
+
+	s.ratePlanRepository = &RatePlanRepository{
+		store: s,
+	}
+
+	return s.ratePlanRepository
+}
+
+func (s *Store) OTP() store.OTPRepository {
+	if s.otpRepository != nil {
+		return s.otpRepository
+	}
+
+	s.otpRepository = &OTPRepository{
+		store: s,
+	}
+
+	return s.otpRepository
+}
+
+func (s *Store) APIKey() store.APIKeyRepository {
+	if s.apiKeyRepository != nil {
+		return s.apiKeyRepository
+	}
+
+	s.apiKeyRepository = &APIKeyRepository{
+		store: s,
+	}
+
+	return s.apiKeyRepository
+}
+
+func (s *Store) RefreshToken() store.RefreshTokenRepository {
+	if s.refreshTokenRepository != nil {
+		return s.refreshTokenRepository
+	}
+
+	s.refreshTokenRepository = &RefreshTokenRepository{
+		store: s,
+	}
+
+	return s.refreshTokenRepository
+}
@@ -0,0 +1,42 @@
+package teststore
+
+import (
+	"context"
+	"errors"
+	"github.com/zlyaptica/hotel_service_backend/internal/app/model"
+	"github.com/zlyaptica/hotel_service_backend/store"
+	"testing"
+)
+
+func TestStore_WithTx_Nested(t *testing.T) {
+	ctx := context.Background()
+	s := New()
+	errFailed := errors.New("failed")
+
+	err := s.WithTx(ctx, func(tx store.Store) error {
+		return tx.WithTx(ctx, func(tx store.Store) error {
+			if err := tx.User().Create(ctx, &model.User{LName: "Ivanov", PhoneNumber: "+79811234567", Role: model.RoleGuest}); err != nil {
+				return err
+			}
+			return errFailed
+		})
+	})
+	if err != errFailed {
+		t.Fatalf("got %v, want %v", err, errFailed)
+	}
+	if _, err := s.User().FindByPhone(ctx, "+79811234567"); err != store.ErrRecordNotFound {
+		t.Errorf("the nested transaction wasn't rolled back: got %v", err)
+	}
+
+	err = s.WithTx(ctx, func(tx store.Store) error {
+		return tx.WithTx(ctx, func(tx store.Store) error {
+			return tx.User().Create(ctx, &model.User{LName: "Ivanov", PhoneNumber: "+79811234567", Role: model.RoleGuest})
+		})
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.User().FindByPhone(ctx, "+79811234567"); err != nil {
+		t.Errorf("the nested transaction wasn't committed: %v", err)
+	}
+}
@@ -0,0 +1,234 @@
+package teststore
+
+import (
+	"context"
+	"github.com/zlyaptica/hotel_service_backend/internal/app/model"
+	"github.com/zlyaptica/hotel_service_backend/store"
+	"time"
+)
+
+type TransactRepository struct {
+	store *Store
+}
+
+func (r *TransactRepository) Create(ctx context.Context, t *model.Transact) error {
+	r.store.mu.Lock()
+	defer r.store.mu.Unlock()
+
+	if _, ok := r.store.users[t.User.ID]; !ok {
+		return errInvalidReference
+	}
+	a, ok := r.store.apartments[t.Apartment.ID]
+	if !ok {
+		return store.ErrRecordNotFound
+	}
+	// The store is locked for the whole check and insert, so overlapping
+	// bookings are rejected just like in sqlstore.
+	if err := r.store.reserve(t.Apartment.ID, t.DateArrival, t.DateDeparture, 0); err != nil {
+		return err
+	}
+
+	t.ID = r.store.nextID("transact")
+	t.Status = model.TransactStatusActive
+	t.CancellationPolicy = &model.CancellationPolicy{}
+	if h, ok := r.store.hotels[a.Hotel.ID]; ok && h.CancellationPolicy != nil {
+		*t.CancellationPolicy = *h.CancellationPolicy
+	}
+	r.store.transacts[t.ID] = &model.Transact{
+		ID:            t.ID,
+		OperationDate: time.Now(),
+		Apartment:     &model.Apartment{ID: t.Apartment.ID},
+		User:          &model.User{ID: t.User.ID},
+		Price:         t.Price,
+		DateArrival:   t.DateArrival,
+		DateDeparture: t.DateDeparture,
+		Status:        t.Status,
+		DisplayPrice:  copyMoney(t.DisplayPrice),
+		ExchangeRate:  t.ExchangeRate,
+		CancellationPolicy: &model.CancellationPolicy{
+			FreeDays:       t.CancellationPolicy.FreeDays,
+			PenaltyPercent: t.CancellationPolicy.PenaltyPercent,
+		},
+	}
+	return nil
+}
+
+// Update moves an active transact to t.Apartment and the t.DateArrival,
+// t.DateDeparture range at t.Price, keeping the replaced version in the
+// history.
+func (r *TransactRepository) Update(ctx context.Context, t *model.Transact) error {
+	r.store.mu.Lock()
+	defer r.store.mu.Unlock()
+
+	current, ok := r.store.transacts[t.ID]
+	if !ok {
+		return store.ErrRecordNotFound
+	}
+	if current.Status != model.TransactStatusActive {
+		return store.ErrTransactCancelled
+	}
+	if err := r.store.reserve(t.Apartment.ID, t.DateArrival, t.DateDeparture, t.ID); err != nil {
+		return err
+	}
+
+	r.store.transactHistory = append(r.store.transactHistory, model.TransactVersion{
+		ID:            r.store.nextID("transact_history"),
+		TransactID:    current.ID,
+		ApartmentID:   current.Apartment.ID,
+		Price:         current.Price,
+		DateArrival:   current.DateArrival,
+		DateDeparture: current.DateDeparture,
+		ChangedAt:     time.Now(),
+	})
+
+	current.Apartment = &model.Apartment{ID: t.Apartment.ID}
+	current.DateArrival = t.DateArrival
+	current.DateDeparture = t.DateDeparture
+	current.Price = t.Price
+	current.DisplayPrice = copyMoney(t.DisplayPrice)
+	current.ExchangeRate = t.ExchangeRate
+	if current.DisplayPrice == nil {
+		current.ExchangeRate = ""
+	}
+	return nil
+}
+
+func (r *TransactRepository) History(ctx context.Context, id int) ([]model.TransactVersion, error) {
+	r.store.mu.Lock()
+	defer r.store.mu.Unlock()
+
+	versions := []model.TransactVersion{}
+	for _, v := range r.store.transactHistory {
+		if v.TransactID == id {
+			versions = append(versions, v)
+		}
+	}
+	return versions, nil
+}
+
+func (r *TransactRepository) Find(ctx context.Context, id int) (*model.Transact, error) {
+	r.store.mu.Lock()
+	defer r.store.mu.Unlock()
+
+	t, ok := r.store.transacts[id]
+	if !ok {
+		return nil, store.ErrRecordNotFound
+	}
+	c := copyTransact(t)
+	if u, ok := r.store.users[t.User.ID]; ok {
+		c.User.PhoneNumber = u.PhoneNumber
+	}
+	if a, ok := r.store.apartments[t.Apartment.ID]; ok {
+		c.Apartment.Name = a.Name
+		c.Apartment.Hotel = &model.Hotel{ID: a.Hotel.ID}
+	}
+	return c, nil
+}
+
+// Cancel marks an active transact as cancelled at t.CancelledAt with t.Refund
+// returned to the guest.
+func (r *TransactRepository) Cancel(ctx context.Context, t *model.Transact) error {
+	r.store.mu.Lock()
+	defer r.store.mu.Unlock()
+
+	current, ok := r.store.transacts[t.ID]
+	if !ok || current.Status != model.TransactStatusActive {
+		return store.ErrTransactCancelled
+	}
+	current.Status = model.TransactStatusCancelled
+	if t.CancelledAt != nil {
+		at := *t.CancelledAt
+		current.CancelledAt = &at
+	}
+	current.Refund = &model.Money{Amount: t.Refund.Amount, Currency: current.Price.Currency}
+	t.Status = model.TransactStatusCancelled
+	return nil
+}
+
+func (r *TransactRepository) FindTransactsByPhoneNumber(ctx context.Context, phoneNumber string, opts *store.ListOptions) ([]model.Transact, string, error) {
+	r.store.mu.Lock()
+	defer r.store.mu.Unlock()
+
+	transacts := []model.Transact{}
+	u := r.store.userByPhone(phoneNumber)
+	for _, t := range r.store.transacts {
+		if u == nil || t.User.ID != u.ID {
+			continue
+		}
+		c := copyTransact(t)
+		c.User.PhoneNumber = u.PhoneNumber
+		if a, ok := r.store.apartments[t.Apartment.ID]; ok {
+			c.Apartment = r.store.apartment(a)
+			if h, ok := r.store.hotels[a.Hotel.ID]; ok {
+				c.Apartment.Hotel.Name = h.Name
+			}
+		}
+		transacts = append(transacts, *c)
+	}
+	keys := map[string]sortKey{
+		"id":             func(i int) sortValue { return numValue(int64(transacts[i].ID)) },
+		"operation_date": func(i int) sortValue { return numValue(transacts[i].OperationDate.UnixNano()) },
+		"arrival":        func(i int) sortValue { return numValue(transacts[i].DateArrival.Unix()) },
+		"price":          func(i int) sortValue { return numValue(transacts[i].Price.Amount) },
+	}
+	rows, next, err := paginate(len(transacts), func(i int) int { return transacts[i].ID }, keys, "operation_date", opts)
+	if err != nil {
+		return nil, "", err
+	}
+
+	page := make([]model.Transact, 0, len(rows))
+	for _, i := range rows {
+		page = append(page, transacts[i])
+	}
+	return page, next, nil
+}
+
+// reserve checks that the apartment exists and has no active stay
+// overlapping the [arrival, departure) range, ignoring the transact with id
+// except.
+func (s *Store) reserve(apartmentID int, arrival, departure time.Time, except int) error {
+	if _, ok := s.apartments[apartmentID]; !ok {
+		return store.ErrRecordNotFound
+	}
+	if s.booked(apartmentID, arrival, departure, except) {
+		return store.ErrApartmentUnavailable
+	}
+	return nil
+}
+
+// booked reports whether the apartment has an active stay other than except
+// overlapping the [arrival, departure) range.
+func (s *Store) booked(apartmentID int, arrival, departure time.Time, except int) bool {
+	for _, t := range s.transacts {
+		if t.Apartment.ID == apartmentID && t.ID != except && t.Status == model.TransactStatusActive &&
+			t.DateArrival.Before(departure) && t.DateDeparture.After(arrival) {
+			return true
+		}
+	}
+	return false
+}
+
+func copyTransact(t *model.Transact) *model.Transact {
+	c := *t
+	c.User = &model.User{ID: t.User.ID}
+	c.Apartment = &model.Apartment{ID: t.Apartment.ID}
+	if t.CancelledAt != nil {
+		at := *t.CancelledAt
+		c.CancelledAt = &at
+	}
+	c.Refund = copyMoney(t.Refund)
+	c.DisplayPrice = copyMoney(t.DisplayPrice)
+	if t.CancellationPolicy != nil {
+		p := *t.CancellationPolicy
+		c.CancellationPolicy = &p
+	}
+	return &c
+}
+
+func copyMoney(m *model.Money) *model.Money {
+	if m == nil {
+		return nil
+	}
+	c := *m
+	return &c
+}
@@ -0,0 +1,114 @@
+package teststore
+
+import (
+	"context"
+	"github.com/zlyaptica/hotel_service_backend/internal/app/model"
+	"github.com/zlyaptica/hotel_service_backend/store"
+)
+
+type UserRepository struct {
+	store *Store
+}
+
+func (r *UserRepository) Create(ctx context.Context, u *model.User) error {
+	r.store.mu.Lock()
+	defer r.store.mu.Unlock()
+
+	if r.store.userByPhone(u.PhoneNumber) != nil {
+		return errAlreadyExists
+	}
+	if u.Role == "" {
+		u.Role = model.RoleGuest
+	}
+	u.ID = r.store.nextID("users")
+	r.store.users[u.ID] = &model.User{
+		ID:          u.ID,
+		LName:       u.LName,
+		FName:       u.FName,
+		PhoneNumber: u.PhoneNumber,
+		Role:        u.Role,
+	}
+	return nil
+}
+
+// Delete removes the user with their refresh tokens. Users who booked stays
+// can't be removed, as the stays refer to them.
+func (r *UserRepository) Delete(ctx context.Context, phoneNumber string) error {
+	r.store.mu.Lock()
+	defer r.store.mu.Unlock()
+
+	u := r.store.userByPhone(phoneNumber)
+	if u == nil {
+		return store.ErrRecordNotFound
+	}
+	for _, t := range r.store.transacts {
+		if t.User.ID == u.ID {
+			return store.ErrUserHasBookings
+		}
+	}
+	for id, t := range r.store.refreshTokens {
+		if t.UserID == u.ID {
+			delete(r.store.refreshTokens, id)
+		}
+	}
+	delete(r.store.users, u.ID)
+	return nil
+}
+
+func (r *UserRepository) FindByPhone(ctx context.Context, phone string) (*model.User, error) {
+	r.store.mu.Lock()
+	defer r.store.mu.Unlock()
+
+	u := r.store.userByPhone(phone)
+	if u == nil {
+		return nil, store.ErrRecordNotFound
+	}
+	return copyUser(u), nil
+}
+
+func (r *UserRepository) Find(ctx context.Context, id int) (*model.User, error) {
+	r.store.mu.Lock()
+	defer r.store.mu.Unlock()
+
+	u, ok := r.store.users[id]
+	if !ok {
+		return nil, store.ErrRecordNotFound
+	}
+	return copyUser(u), nil
+}
+
+// SetRole changes the role of the user and replaces the hotels they manage
+// with u.HotelIDs.
+func (r *UserRepository) SetRole(ctx context.Context, u *model.User) error {
+	r.store.mu.Lock()
+	defer r.store.mu.Unlock()
+
+	current, ok := r.store.users[u.ID]
+	if !ok {
+		return store.ErrRecordNotFound
+	}
+	for _, id := range u.HotelIDs {
+		if _, ok := r.store.hotels[id]; !ok {
+			return errInvalidReference
+		}
+	}
+	current.Role = u.Role
+	current.HotelIDs = append([]int(nil), u.HotelIDs...)
+	return nil
+}
+
+// userByPhone returns the stored user with the phone number, or nil.
+func (s *Store) userByPhone(phoneNumber string) *model.User {
+	for _, u := range s.users {
+		if u.PhoneNumber == phoneNumber {
+			return u
+		}
+	}
+	return nil
+}
+
+func copyUser(u *model.User) *model.User {
+	c := *u
+	c.HotelIDs = append([]int(nil), u.HotelIDs...)
+	return &c
+}